@@ -4,12 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"api-gateway/middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	orderpb "api-gateway/proto"
 	productpb "api-gateway/proto"
@@ -20,6 +26,7 @@ type Gateway struct {
 	userClient    userpb.UserServiceClient
 	productClient productpb.ProductServiceClient
 	orderClient   orderpb.OrderServiceClient
+	cartClient    orderpb.CartServiceClient
 }
 
 // createGRPCConnection creates a gRPC connection with load balancing support
@@ -50,6 +57,61 @@ func createGRPCConnection(addresses string) (*grpc.ClientConn, error) {
 	return conn, err
 }
 
+// writeGRPCError translates a gRPC status returned by a backend service into the matching HTTP status
+func writeGRPCError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := http.StatusInternalServerError
+	switch st.Code() {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists, codes.Aborted:
+		code = http.StatusConflict
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, st.Message(), code)
+}
+
+// splitList splits a comma-separated query parameter, dropping empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parseTimeParam parses a date query parameter given as unix seconds, RFC 3339 or
+// YYYY-MM-DD into unix seconds. A plain date used as an exclusive upper bound
+// (endOfDay) covers that whole day. An empty value returns 0.
+func parseTimeParam(value string, endOfDay bool) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected unix seconds, RFC 3339 or YYYY-MM-DD")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Unix(), nil
+}
+
 func NewGateway(userURL, productURL, orderURL string) (*Gateway, error) {
 	userConn, err := createGRPCConnection(userURL)
 	if err != nil {
@@ -73,6 +135,7 @@ func NewGateway(userURL, productURL, orderURL string) (*Gateway, error) {
 		userClient:    userpb.NewUserServiceClient(userConn),
 		productClient: productpb.NewProductServiceClient(productConn),
 		orderClient:   orderpb.NewOrderServiceClient(orderConn),
+		cartClient:    orderpb.NewCartServiceClient(orderConn),
 	}, nil
 }
 
@@ -89,17 +152,26 @@ func (g *Gateway) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Name     string `json:"name"`
 		Role     string `json:"role"`
+		Locale   string `json:"locale"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	// Registration is public, so it only creates customers; admins grant other
+	// roles through PUT /admin/users/{id}/role
+	if req.Role != "" && req.Role != "customer" {
+		http.Error(w, "Only admins can assign roles", http.StatusForbidden)
+		return
+	}
+
 	resp, err := g.userClient.CreateUser(context.Background(), &userpb.CreateUserRequest{
 		Email:    req.Email,
 		Password: req.Password,
 		Name:     req.Name,
-		Role:     req.Role,
+		Role:     "customer",
+		Locale:   req.Locale,
 	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -145,6 +217,46 @@ func (g *Gateway) GetUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SetUserRole serves PUT /admin/users/{id}/role with {"role":"admin"} or
+// {"role":"customer"}. The new role applies from the user's next login.
+func (g *Gateway) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract user ID from URL path /admin/users/{id}/role
+	path := strings.TrimPrefix(r.URL.Path, "/admin/users/")
+	path = strings.TrimSuffix(path, "/role")
+	if path == "" || path == r.URL.Path || strings.Contains(path, "/") {
+		http.Error(w, "User ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.userClient.SetUserRole(context.Background(), &userpb.SetUserRoleRequest{
+		UserId: path,
+		Role:   req.Role,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (g *Gateway) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -154,6 +266,7 @@ func (g *Gateway) Login(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		CartID   string `json:"cart_id"` // guest cart to merge into the user's cart
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -196,6 +309,20 @@ func (g *Gateway) Login(w http.ResponseWriter, r *http.Request) {
 		"user":    userResp,
 	}
 
+	// A guest cart that cannot be merged, e.g. because it was merged already, does not fail the login.
+	// One that would make the cart too big is kept, and the client is told why.
+	if req.CartID != "" {
+		cart, err := g.cartClient.MergeCarts(context.Background(), &orderpb.MergeCartsRequest{
+			UserId:      authResp.UserId,
+			GuestCartId: req.CartID,
+		})
+		if err == nil {
+			response["cart"] = cart
+		} else if status.Code(err) == codes.FailedPrecondition {
+			response["cart_merge_error"] = status.Convert(err).Message()
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
@@ -212,10 +339,15 @@ func (g *Gateway) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name        string  `json:"name"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-		Stock       int32   `json:"stock"`
+		Name        string           `json:"name"`
+		Description string           `json:"description"`
+		Price       *productpb.Money `json:"price"` // {"minor_units": 1999, "currency": "USD"}
+		Stock       int32            `json:"stock"`
+		TaxCategory string           `json:"tax_category"`
+		WeightGrams int32            `json:"weight_grams"`
+		LengthCm    float64          `json:"length_cm"`
+		WidthCm     float64          `json:"width_cm"`
+		HeightCm    float64          `json:"height_cm"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -227,6 +359,11 @@ func (g *Gateway) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		Description: req.Description,
 		Price:       req.Price,
 		Stock:       req.Stock,
+		TaxCategory: req.TaxCategory,
+		WeightGrams: req.WeightGrams,
+		LengthCm:    req.LengthCm,
+		WidthCm:     req.WidthCm,
+		HeightCm:    req.HeightCm,
 	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -255,6 +392,7 @@ func (g *Gateway) GetProduct(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := g.productClient.GetProduct(context.Background(), &productpb.GetProductRequest{
 		ProductId: path,
+		Currency:  r.URL.Query().Get("currency"),
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -284,7 +422,8 @@ func (g *Gateway) ListProducts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp, err := g.productClient.ListProducts(context.Background(), &productpb.ListProductsRequest{
-		Limit: limit,
+		Limit:    limit,
+		Currency: r.URL.Query().Get("currency"),
 	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -340,8 +479,141 @@ func (g *Gateway) UpdateInventory(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ProductPrice sets (PUT) or removes (DELETE) the price of a product in one currency.
+// Setting the price in the product's base currency changes its base price.
+func (g *Gateway) ProductPrice(w http.ResponseWriter, r *http.Request) {
+	// Extract product ID and currency from URL path /products/{id}/prices/{currency}
+	path := strings.TrimPrefix(r.URL.Path, "/products/")
+	parts := strings.Split(path, "/prices/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "Product ID and currency required", http.StatusBadRequest)
+		return
+	}
+	productID, currency := parts[0], parts[1]
+
+	var resp *productpb.ProductResponse
+	var err error
+	switch r.Method {
+	case "PUT":
+		var req struct {
+			MinorUnits int64 `json:"minor_units"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		resp, err = g.productClient.SetProductPrice(context.Background(), &productpb.SetProductPriceRequest{
+			ProductId: productID,
+			Price:     &productpb.Money{MinorUnits: req.MinorUnits, Currency: currency},
+		})
+	case "DELETE":
+		resp, err = g.productClient.RemoveProductPrice(context.Background(), &productpb.RemoveProductPriceRequest{
+			ProductId: productID,
+			Currency:  currency,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) ListExchangeRates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := g.productClient.ListExchangeRates(context.Background(), &productpb.ListExchangeRatesRequest{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetExchangeRate sets how many units of a currency one unit of the base currency buys
+func (g *Gateway) SetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract currency from URL path /admin/exchange-rates/{currency}
+	currency := strings.TrimPrefix(r.URL.Path, "/admin/exchange-rates/")
+	if currency == "" {
+		http.Error(w, "Currency required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Rate string `json:"rate"` // decimal string, e.g. "0.92"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.productClient.SetExchangeRate(context.Background(), &productpb.SetExchangeRateRequest{
+		Currency: currency,
+		Rate:     req.Rate,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 // ========== ORDER ROUTES ==========
 
+// addressBody is the JSON form of a postal address
+type addressBody struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+func (a *addressBody) toProto() *orderpb.Address {
+	if a == nil {
+		return nil
+	}
+	return &orderpb.Address{
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
+
 func (g *Gateway) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -354,6 +626,10 @@ func (g *Gateway) CreateOrder(w http.ResponseWriter, r *http.Request) {
 			ProductID string `json:"product_id"`
 			Quantity  int32  `json:"quantity"`
 		} `json:"items"`
+		CouponCodes     []string     `json:"coupon_codes"`
+		ShippingAddress *addressBody `json:"shipping_address"`
+		ShippingMethod  string       `json:"shipping_method"`
+		Currency        string       `json:"currency"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -379,12 +655,18 @@ func (g *Gateway) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	// Clients retrying on flaky networks send the same Idempotency-Key to avoid duplicate orders
 	resp, err := g.orderClient.CreateOrder(context.Background(), &orderpb.CreateOrderRequest{
-		UserId: req.UserID,
-		Items:  items,
+		UserId:          req.UserID,
+		Items:           items,
+		IdempotencyKey:  r.Header.Get("Idempotency-Key"),
+		CouponCodes:     req.CouponCodes,
+		ShippingAddress: req.ShippingAddress.toProto(),
+		ShippingMethod:  req.ShippingMethod,
+		Currency:        req.Currency,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeGRPCError(w, err)
 		return
 	}
 
@@ -462,11 +744,34 @@ func (g *Gateway) ListOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := g.orderClient.ListOrders(context.Background(), &orderpb.ListOrdersRequest{
-		UserId: requestedUserID,
-	})
+	query := r.URL.Query()
+	listReq := &orderpb.ListOrdersRequest{
+		UserId:    requestedUserID,
+		Statuses:  splitList(query.Get("status")),
+		Sort:      query.Get("sort"),
+		PageToken: query.Get("page_token"),
+	}
+	var err error
+	if listReq.CreatedFrom, err = parseTimeParam(query.Get("from"), false); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if listReq.CreatedTo, err = parseTimeParam(query.Get("to"), true); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		n, err := strconv.ParseInt(pageSize, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid page_size", http.StatusBadRequest)
+			return
+		}
+		listReq.PageSize = int32(n)
+	}
+
+	resp, err := g.orderClient.ListOrders(context.Background(), listReq)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeGRPCError(w, err)
 		return
 	}
 
@@ -493,6 +798,7 @@ func (g *Gateway) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Status string `json:"status"`
+		Reason string `json:"reason"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -500,15 +806,1097 @@ func (g *Gateway) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp, err := g.orderClient.UpdateOrderStatus(context.Background(), &orderpb.UpdateOrderStatusRequest{
+		OrderId:     path,
+		Status:      req.Status,
+		ActorUserId: middleware.GetUserIDFromContext(r),
+		ActorRole:   middleware.GetUserRoleFromContext(r),
+		Reason:      req.Reason,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/cancel
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/cancel")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional, it only carries the reason
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.orderClient.CancelOrder(context.Background(), &orderpb.CancelOrderRequest{
+		OrderId:     path,
+		ActorUserId: middleware.GetUserIDFromContext(r),
+		ActorRole:   middleware.GetUserRoleFromContext(r),
+		Reason:      req.Reason,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// checkOrderAccess loads an order and verifies the caller is its owner or an admin.
+// It writes the error response and returns false if access is not allowed.
+func (g *Gateway) checkOrderAccess(w http.ResponseWriter, r *http.Request, orderID string) bool {
+	order, err := g.orderClient.GetOrder(context.Background(), &orderpb.GetOrderRequest{
+		OrderId: orderID,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return false
+	}
+
+	if middleware.GetUserRoleFromContext(r) != "admin" && order.UserId != middleware.GetUserIDFromContext(r) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (g *Gateway) GetOrderTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/timeline
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/timeline")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !g.checkOrderAccess(w, r, path) {
+		return
+	}
+
+	resp, err := g.orderClient.GetOrderTimeline(context.Background(), &orderpb.GetOrderTimelineRequest{
 		OrderId: path,
-		Status:  req.Status,
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// orderEventsKeepAlive is how often WatchOrder writes a comment to an idle event
+// stream, so proxies do not close it
+const orderEventsKeepAlive = 15 * time.Second
+
+// WatchOrder serves GET /orders/{id}/events as Server-Sent Events to the order owner
+// and admins: a status event with the current status, one per status change after
+// it, and an end event once the order reaches a terminal status
+func (g *Gateway) WatchOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/events
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/events")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !g.checkOrderAccess(w, r, path) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Stop watching when the client goes away
+	stream, err := g.orderClient.WatchOrder(r.Context(), &orderpb.WatchOrderRequest{
+		OrderId: path,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	// The current status arrives first; errors before it become HTTP errors
+	change, err := stream.Recv()
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	changes := make(chan *orderpb.OrderStatusChange)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			change, err := stream.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			select {
+			case changes <- change:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	writeEvent := func(event string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("Failed to encode %s event of order %s: %v", event, path, err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	if !writeEvent("status", change) {
+		return
+	}
+
+	keepAlive := time.NewTicker(orderEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
 			return
+		case change := <-changes:
+			if !writeEvent("status", change) {
+				return
+			}
+		case err := <-done:
+			if err == io.EOF {
+				writeEvent("end", struct{}{})
+			} else {
+				log.Printf("Watching order %s failed: %v", path, err)
+				writeEvent("error", map[string]string{"error": status.Convert(err).Message()})
+			}
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetOrderDocument serves GET /orders/{id}/invoice.pdf to the order owner and admins,
+// and GET /orders/{id}/packing-slip.pdf?shipment_id= to admins
+func (g *Gateway) GetOrderDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID and document from URL path /orders/{id}/{document}.pdf
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	req := &orderpb.GetOrderDocumentRequest{}
+	switch {
+	case strings.HasSuffix(path, "/invoice.pdf"):
+		req.OrderId = strings.TrimSuffix(path, "/invoice.pdf")
+		req.Type = "invoice"
+	case strings.HasSuffix(path, "/packing-slip.pdf"):
+		req.OrderId = strings.TrimSuffix(path, "/packing-slip.pdf")
+		req.Type = "packing_slip"
+		req.ShipmentId = r.URL.Query().Get("shipment_id")
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if req.OrderId == "" || strings.Contains(req.OrderId, "/") {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !g.checkOrderAccess(w, r, req.OrderId) {
+		return
+	}
+
+	resp, err := g.orderClient.GetOrderDocument(context.Background(), req)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", resp.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", resp.FileName))
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Content)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if resp.InvoiceNumber != "" {
+		w.Header().Set("X-Invoice-Number", resp.InvoiceNumber)
+	}
+	w.Write(resp.Content)
+}
+
+func (g *Gateway) GetShippingQuotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Items []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int32  `json:"quantity"`
+		} `json:"items"`
+		ShippingAddress *addressBody `json:"shipping_address"`
+		Currency        string       `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var items []*orderpb.OrderItem
+	for _, item := range req.Items {
+		items = append(items, &orderpb.OrderItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	resp, err := g.orderClient.GetShippingQuotes(context.Background(), &orderpb.GetShippingQuotesRequest{
+		Items:           items,
+		ShippingAddress: req.ShippingAddress.toProto(),
+		Currency:        req.Currency,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ========== RETURN ROUTES ==========
+
+func (g *Gateway) CreateReturn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/returns
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/returns")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+		Items  []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int32  `json:"quantity"`
+			Reason    string `json:"reason"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var items []*orderpb.ReturnItem
+	for _, item := range req.Items {
+		items = append(items, &orderpb.ReturnItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+			Reason:    item.Reason,
+		})
+	}
+
+	resp, err := g.orderClient.CreateReturn(context.Background(), &orderpb.CreateReturnRequest{
+		OrderId:     path,
+		ActorUserId: middleware.GetUserIDFromContext(r),
+		ActorRole:   middleware.GetUserRoleFromContext(r),
+		Reason:      req.Reason,
+		Items:       items,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) ListOrderReturns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/returns
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/returns")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !g.checkOrderAccess(w, r, path) {
+		return
+	}
+
+	resp, err := g.orderClient.ListReturns(context.Background(), &orderpb.ListReturnsRequest{
+		OrderId: path,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) GetReturn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract return ID from URL path /returns/{id}
+	path := strings.TrimPrefix(r.URL.Path, "/returns/")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Return ID required", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.orderClient.GetReturn(context.Background(), &orderpb.GetReturnRequest{
+		ReturnId: path,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	// Check if user can access this return (must be owner or admin)
+	if middleware.GetUserRoleFromContext(r) != "admin" && resp.UserId != middleware.GetUserIDFromContext(r) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ========== ADMIN ROUTES ==========
+
+func (g *Gateway) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	searchReq := &orderpb.SearchOrdersRequest{
+		Statuses:  splitList(query.Get("status")),
+		UserId:    query.Get("user_id"),
+		Email:     query.Get("email"),
+		ProductId: query.Get("product_id"),
+		Sort:      query.Get("sort"),
+		PageToken: query.Get("page_token"),
+		Currency:  query.Get("currency"),
+	}
+
+	var err error
+	if searchReq.CreatedFrom, err = parseTimeParam(query.Get("from"), false); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if searchReq.CreatedTo, err = parseTimeParam(query.Get("to"), true); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+	// Amount bounds are minor units of the currency parameter
+	if value := query.Get("min_amount"); value != "" {
+		minor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid min_amount", http.StatusBadRequest)
+			return
+		}
+		searchReq.MinAmount = &orderpb.Money{MinorUnits: minor, Currency: searchReq.Currency}
+	}
+	if value := query.Get("max_amount"); value != "" {
+		minor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid max_amount", http.StatusBadRequest)
+			return
+		}
+		searchReq.MaxAmount = &orderpb.Money{MinorUnits: minor, Currency: searchReq.Currency}
+	}
+	if value := query.Get("page_size"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid page_size", http.StatusBadRequest)
+			return
+		}
+		searchReq.PageSize = int32(n)
+	}
+
+	resp, err := g.orderClient.SearchOrders(context.Background(), searchReq)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExportOrders streams GET /admin/orders/export?format=csv|jsonl&status=&from=&to= as
+// a download. Chunks are written as they arrive from the order service; an error
+// after the first chunk can only cut the download short.
+func (g *Gateway) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	exportReq := &orderpb.ExportOrdersRequest{
+		Statuses: splitList(query.Get("status")),
+		Format:   strings.ToLower(query.Get("format")),
+	}
+	if exportReq.Format == "" {
+		exportReq.Format = "csv"
+	}
+	var err error
+	if exportReq.CreatedFrom, err = parseTimeParam(query.Get("from"), false); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if exportReq.CreatedTo, err = parseTimeParam(query.Get("to"), true); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Stop the export when the client goes away
+	stream, err := g.orderClient.ExportOrders(r.Context(), exportReq)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	// Validation errors arrive with the first message, before anything is written
+	chunk, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		writeGRPCError(w, err)
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if exportReq.Format == "jsonl" {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"orders-%s.%s\"", time.Now().UTC().Format("20060102-150405"), exportReq.Format))
+	w.Header().Set("Cache-Control", "no-store")
+	flusher, _ := w.(http.Flusher)
+	for chunk != nil {
+		if _, err := w.Write(chunk.Data); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if chunk, err = stream.Recv(); err != nil {
+			if err != io.EOF {
+				log.Printf("Order export failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (g *Gateway) ListReturns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	listReq := &orderpb.ListReturnsRequest{
+		OrderId:  query.Get("order_id"),
+		UserId:   query.Get("user_id"),
+		Statuses: splitList(query.Get("status")),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		listReq.Limit = int32(n)
+	}
+
+	resp, err := g.orderClient.ListReturns(context.Background(), listReq)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) UpdateReturnStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract return ID from URL path /admin/returns/{id}/status
+	path := strings.TrimPrefix(r.URL.Path, "/admin/returns/")
+	path = strings.TrimSuffix(path, "/status")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Return ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.orderClient.UpdateReturnStatus(context.Background(), &orderpb.UpdateReturnStatusRequest{
+		ReturnId: path,
+		Status:   req.Status,
+		Note:     req.Note,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ========== SHIPMENT ROUTES ==========
+
+func (g *Gateway) CreateShipment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/shipments
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/shipments")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Carrier        string `json:"carrier"`
+		TrackingNumber string `json:"tracking_number"`
+		TrackingURL    string `json:"tracking_url"`
+		Items          []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int32  `json:"quantity"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var items []*orderpb.ShipmentItem
+	for _, item := range req.Items {
+		items = append(items, &orderpb.ShipmentItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	resp, err := g.orderClient.CreateShipment(context.Background(), &orderpb.CreateShipmentRequest{
+		OrderId:        path,
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+		TrackingUrl:    req.TrackingURL,
+		Items:          items,
+		ActorUserId:    middleware.GetUserIDFromContext(r),
+		ActorRole:      middleware.GetUserRoleFromContext(r),
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) ListShipments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract order ID from URL path /orders/{id}/shipments
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	path = strings.TrimSuffix(path, "/shipments")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Order ID required", http.StatusBadRequest)
+		return
+	}
+
+	if !g.checkOrderAccess(w, r, path) {
+		return
+	}
+
+	resp, err := g.orderClient.ListShipments(context.Background(), &orderpb.ListShipmentsRequest{
+		OrderId: path,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) MarkShipmentDelivered(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract shipment ID from URL path /admin/shipments/{id}/delivered
+	path := strings.TrimPrefix(r.URL.Path, "/admin/shipments/")
+	path = strings.TrimSuffix(path, "/delivered")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Shipment ID required", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.orderClient.MarkShipmentDelivered(context.Background(), &orderpb.MarkShipmentDeliveredRequest{
+		ShipmentId:  path,
+		ActorUserId: middleware.GetUserIDFromContext(r),
+		ActorRole:   middleware.GetUserRoleFromContext(r),
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ========== PROMOTION ROUTES ==========
+
+// promotionBody is the JSON accepted when creating or replacing a promotion
+type promotionBody struct {
+	Code           string         `json:"code"`
+	Description    string         `json:"description"`
+	Type           string         `json:"type"`
+	Value          float64        `json:"value"`  // percentage
+	Amount         *orderpb.Money `json:"amount"` // fixed
+	ProductID      string         `json:"product_id"`
+	BuyQuantity    int32          `json:"buy_quantity"`
+	GetQuantity    int32          `json:"get_quantity"`
+	MinOrderValue  *orderpb.Money `json:"min_order_value"`
+	MaxUses        int32          `json:"max_uses"`
+	MaxUsesPerUser int32          `json:"max_uses_per_user"`
+	StartsAt       string         `json:"starts_at"`
+	EndsAt         string         `json:"ends_at"`
+	Active         *bool          `json:"active"`
+}
+
+// toProto converts the body to a Promotion. Promotions are active unless active is false.
+func (b *promotionBody) toProto() (*orderpb.Promotion, error) {
+	startsAt, err := parseTimeParam(b.StartsAt, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid starts_at: %v", err)
+	}
+	endsAt, err := parseTimeParam(b.EndsAt, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ends_at: %v", err)
+	}
+
+	return &orderpb.Promotion{
+		Code:           b.Code,
+		Description:    b.Description,
+		Type:           b.Type,
+		Value:          b.Value,
+		Amount:         b.Amount,
+		ProductId:      b.ProductID,
+		BuyQuantity:    b.BuyQuantity,
+		GetQuantity:    b.GetQuantity,
+		MinOrderValue:  b.MinOrderValue,
+		MaxUses:        b.MaxUses,
+		MaxUsesPerUser: b.MaxUsesPerUser,
+		StartsAt:       startsAt,
+		EndsAt:         endsAt,
+		Active:         b.Active == nil || *b.Active,
+	}, nil
+}
+
+func (g *Gateway) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := g.orderClient.ListPromotions(context.Background(), &orderpb.ListPromotionsRequest{
+		ActiveOnly: r.URL.Query().Get("active") == "true",
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req promotionBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	promotion, err := req.toProto()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.orderClient.CreatePromotion(context.Background(), promotion)
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Promotion handles GET, PUT and DELETE on /admin/promotions/{id}
+func (g *Gateway) Promotion(w http.ResponseWriter, r *http.Request) {
+	// Extract promotion ID from URL path /admin/promotions/{id}
+	path := strings.TrimPrefix(r.URL.Path, "/admin/promotions/")
+	if path == "" || path == r.URL.Path {
+		http.Error(w, "Promotion ID required", http.StatusBadRequest)
+		return
+	}
+
+	var resp interface{}
+	var err error
+	switch r.Method {
+	case "GET":
+		resp, err = g.orderClient.GetPromotion(context.Background(), &orderpb.GetPromotionRequest{
+			PromotionId: path,
+		})
+	case "PUT":
+		var req promotionBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		promotion, perr := req.toProto()
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		promotion.PromotionId = path
+		resp, err = g.orderClient.UpdatePromotion(context.Background(), promotion)
+	case "DELETE":
+		resp, err = g.orderClient.DeletePromotion(context.Background(), &orderpb.DeletePromotionRequest{
+			PromotionId: path,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ========== CART ROUTES ==========
+
+// cartRef picks the cart of the logged-in user, or the guest cart named by the
+// X-Cart-ID header
+func cartRef(r *http.Request) *orderpb.CartRef {
+	if userID := middleware.GetUserIDFromContext(r); userID != "" {
+		return &orderpb.CartRef{UserId: userID}
+	}
+	return &orderpb.CartRef{CartId: r.Header.Get("X-Cart-ID")}
+}
+
+func (g *Gateway) GetCart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := g.cartClient.GetCart(context.Background(), &orderpb.GetCartRequest{
+		Cart:     cartRef(r),
+		Currency: r.URL.Query().Get("currency"),
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AddCartItem adds a product to the cart. Guests without a cart get a new one, whose
+// cart_id they send as X-Cart-ID from then on.
+func (g *Gateway) AddCartItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProductID string `json:"product_id"`
+		Quantity  int32  `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.cartClient.AddCartItem(context.Background(), &orderpb.AddCartItemRequest{
+		Cart:      cartRef(r),
+		ProductId: req.ProductID,
+		Quantity:  req.Quantity,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CartItem changes the quantity of (PUT) or removes (DELETE) a product in the cart
+func (g *Gateway) CartItem(w http.ResponseWriter, r *http.Request) {
+	// Extract product ID from URL path /cart/items/{product_id}
+	productID := strings.TrimPrefix(r.URL.Path, "/cart/items/")
+	if productID == "" || productID == r.URL.Path {
+		http.Error(w, "Product ID required", http.StatusBadRequest)
+		return
+	}
+
+	var resp *orderpb.CartResponse
+	var err error
+	switch r.Method {
+	case "PUT":
+		var req struct {
+			Quantity int32 `json:"quantity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		resp, err = g.cartClient.UpdateCartItem(context.Background(), &orderpb.UpdateCartItemRequest{
+			Cart:      cartRef(r),
+			ProductId: productID,
+			Quantity:  req.Quantity,
+		})
+	case "DELETE":
+		resp, err = g.cartClient.RemoveCartItem(context.Background(), &orderpb.RemoveCartItemRequest{
+			Cart:      cartRef(r),
+			ProductId: productID,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// MergeCart moves the guest cart named by X-Cart-ID or the body into the user's cart
+func (g *Gateway) MergeCart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		CartID string `json:"cart_id"`
+	}
+	// The body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.CartID == "" {
+		req.CartID = r.Header.Get("X-Cart-ID")
+	}
+
+	resp, err := g.cartClient.MergeCarts(context.Background(), &orderpb.MergeCartsRequest{
+		UserId:      middleware.GetUserIDFromContext(r),
+		GuestCartId: req.CartID,
+	})
+	if err != nil {
+		writeGRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CheckoutCart places an order for the user's cart
+func (g *Gateway) CheckoutCart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		CouponCodes     []string     `json:"coupon_codes"`
+		ShippingAddress *addressBody `json:"shipping_address"`
+		ShippingMethod  string       `json:"shipping_method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.cartClient.CheckoutCart(context.Background(), &orderpb.CheckoutCartRequest{
+		UserId:          middleware.GetUserIDFromContext(r),
+		CouponCodes:     req.CouponCodes,
+		ShippingAddress: req.ShippingAddress.toProto(),
+		ShippingMethod:  req.ShippingMethod,
+		IdempotencyKey:  r.Header.Get("Idempotency-Key"),
+	})
+	if err != nil {
+		writeGRPCError(w, err)
 		return
 	}
 
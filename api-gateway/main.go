@@ -72,6 +72,9 @@ func main() {
 			} else {
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
+		} else if strings.Contains(r.URL.Path, "/prices/") {
+			// Set or remove a price requires admin role
+			middleware.RequireRole("admin")(gateway.ProductPrice)(w, r)
 		} else if r.Method == "GET" {
 			// Get product - public access
 			gateway.GetProduct(w, r)
@@ -93,7 +96,7 @@ func main() {
 		}
 	})
 
-	// Get order by ID or update status
+	// Get order by ID, update status or cancel
 	http.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/status") {
 			// Update order status requires admin role
@@ -102,6 +105,59 @@ func main() {
 			} else {
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
+		} else if strings.HasSuffix(r.URL.Path, "/returns") {
+			// Request a return or list the returns of an order (owner or admin)
+			if r.Method == "POST" {
+				middleware.AuthMiddleware(gateway.CreateReturn)(w, r)
+			} else if r.Method == "GET" {
+				middleware.AuthMiddleware(gateway.ListOrderReturns)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/shipments") {
+			// Ship items (admin only) or list the shipments of an order (owner or admin)
+			if r.Method == "POST" {
+				middleware.RequireRole("admin")(gateway.CreateShipment)(w, r)
+			} else if r.Method == "GET" {
+				middleware.AuthMiddleware(gateway.ListShipments)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/timeline") {
+			// Status history of an order (owner or admin)
+			if r.Method == "GET" {
+				middleware.AuthMiddleware(gateway.GetOrderTimeline)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/events") {
+			// Live status changes of an order as Server-Sent Events (owner or admin)
+			if r.Method == "GET" {
+				middleware.AuthMiddleware(gateway.WatchOrder)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/invoice.pdf") {
+			// Invoice of a paid order (owner or admin)
+			if r.Method == "GET" {
+				middleware.AuthMiddleware(gateway.GetOrderDocument)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/packing-slip.pdf") {
+			// Packing slip for the warehouse requires admin role
+			if r.Method == "GET" {
+				middleware.RequireRole("admin")(gateway.GetOrderDocument)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/cancel") {
+			// Cancel order requires authentication, ownership is checked by the order service
+			if r.Method == "POST" {
+				middleware.AuthMiddleware(gateway.CancelOrder)(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
 		} else if r.Method == "GET" {
 			// Get order requires authentication
 			middleware.AuthMiddleware(gateway.GetOrder)(w, r)
@@ -110,21 +166,104 @@ func main() {
 		}
 	})
 
+	// Cart routes, for guests (X-Cart-ID header) and logged-in users
+	http.HandleFunc("/cart", middleware.OptionalAuth(gateway.GetCart))                 // GET /cart
+	http.HandleFunc("/cart/items", middleware.OptionalAuth(gateway.AddCartItem))       // POST /cart/items
+	http.HandleFunc("/cart/items/", middleware.OptionalAuth(gateway.CartItem))         // PUT, DELETE /cart/items/{product_id}
+	http.HandleFunc("/cart/merge", middleware.AuthMiddleware(gateway.MergeCart))       // POST /cart/merge
+	http.HandleFunc("/cart/checkout", middleware.AuthMiddleware(gateway.CheckoutCart)) // POST /cart/checkout
+
+	// Exchange rates (public)
+	http.HandleFunc("/exchange-rates", gateway.ListExchangeRates) // GET /exchange-rates
+
+	// Shipping quotes (public)
+	http.HandleFunc("/shipping/quotes", gateway.GetShippingQuotes) // POST /shipping/quotes
+
+	// Get return by ID (owner or admin)
+	http.HandleFunc("/returns/", middleware.AuthMiddleware(gateway.GetReturn))
+
+	// Admin routes
+	http.HandleFunc("/admin/orders", middleware.RequireRole("admin")(gateway.SearchOrders))        // GET /admin/orders - Search all orders
+	http.HandleFunc("/admin/orders/export", middleware.RequireRole("admin")(gateway.ExportOrders)) // GET /admin/orders/export - Stream orders as CSV or JSON Lines
+	http.HandleFunc("/admin/returns", middleware.RequireRole("admin")(gateway.ListReturns))        // GET /admin/returns - List returns
+	http.HandleFunc("/admin/returns/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/status") && r.Method == "PUT" {
+			middleware.RequireRole("admin")(gateway.UpdateReturnStatus)(w, r)
+		} else {
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+	http.HandleFunc("/admin/promotions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			middleware.RequireRole("admin")(gateway.CreatePromotion)(w, r)
+		} else {
+			middleware.RequireRole("admin")(gateway.ListPromotions)(w, r)
+		}
+	})
+	http.HandleFunc("/admin/promotions/", middleware.RequireRole("admin")(gateway.Promotion))           // GET, PUT, DELETE /admin/promotions/{id}
+	http.HandleFunc("/admin/exchange-rates/", middleware.RequireRole("admin")(gateway.SetExchangeRate)) // PUT /admin/exchange-rates/{currency}
+	http.HandleFunc("/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/role") {
+			middleware.RequireRole("admin")(gateway.SetUserRole)(w, r)
+		} else {
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+	http.HandleFunc("/admin/shipments/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/delivered") && r.Method == "PUT" {
+			middleware.RequireRole("admin")(gateway.MarkShipmentDelivered)(w, r)
+		} else {
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
 	log.Println("API Gateway listening on :8080")
 	log.Printf("Connected to User Service: %s", userURL)
 	log.Printf("Connected to Product Service: %s", productURL)
 	log.Printf("Connected to Order Service: %s", orderURL)
 	log.Println("\nAvailable Routes:")
-	log.Println("  POST   /users              - Register new user (public)")
+	log.Println("  POST   /users              - Register new customer (public)")
 	log.Println("  GET    /users/:id          - Get user by ID (auth required)")
 	log.Println("  POST   /auth/login         - Login (public)")
 	log.Println("  GET    /products           - List products (public)")
 	log.Println("  POST   /products            - Create product (admin only)")
 	log.Println("  GET    /products/:id        - Get product by ID (public)")
 	log.Println("  PUT    /products/:id/inventory - Update inventory (admin only)")
+	log.Println("  PUT    /products/:id/prices/:currency - Set a price in a currency (admin only)")
+	log.Println("  DELETE /products/:id/prices/:currency - Remove a price in a currency (admin only)")
+	log.Println("  GET    /exchange-rates      - List exchange rates (public)")
 	log.Println("  GET    /orders              - List orders (auth required)")
 	log.Println("  POST   /orders              - Create order (auth required)")
 	log.Println("  GET    /orders/:id          - Get order by ID (auth required)")
 	log.Println("  PUT    /orders/:id/status   - Update order status (admin only)")
+	log.Println("  GET    /orders/:id/timeline - Status history of an order (owner or admin)")
+	log.Println("  GET    /orders/:id/events   - Live status changes as Server-Sent Events (owner or admin)")
+	log.Println("  POST   /orders/:id/cancel   - Cancel order (owner or admin)")
+	log.Println("  POST   /orders/:id/returns  - Request a return (owner or admin)")
+	log.Println("  GET    /orders/:id/returns  - List returns of an order (owner or admin)")
+	log.Println("  POST   /orders/:id/shipments - Ship some or all items (admin only)")
+	log.Println("  GET    /orders/:id/shipments - List shipments of an order (owner or admin)")
+	log.Println("  GET    /orders/:id/invoice.pdf - Download the invoice of a paid order (owner or admin)")
+	log.Println("  GET    /orders/:id/packing-slip.pdf - Download a packing slip (admin only)")
+	log.Println("  GET    /cart                - Get the cart with current prices and stock (guest or user)")
+	log.Println("  POST   /cart/items          - Add a product to the cart (guest or user)")
+	log.Println("  PUT    /cart/items/:product_id - Change the quantity of a product (guest or user)")
+	log.Println("  DELETE /cart/items/:product_id - Remove a product from the cart (guest or user)")
+	log.Println("  POST   /cart/merge          - Merge a guest cart into your cart (auth required)")
+	log.Println("  POST   /cart/checkout       - Place an order for your cart (auth required)")
+	log.Println("  POST   /shipping/quotes     - Quote shipping methods for a cart (public)")
+	log.Println("  GET    /returns/:id         - Get return by ID (owner or admin)")
+	log.Println("  GET    /admin/orders        - Search all orders (admin only)")
+	log.Println("  GET    /admin/orders/export - Export orders as CSV or JSON Lines (admin only)")
+	log.Println("  GET    /admin/returns       - List returns (admin only)")
+	log.Println("  PUT    /admin/returns/:id/status - Approve, receive, refund or reject a return (admin only)")
+	log.Println("  GET    /admin/promotions    - List promotions (admin only)")
+	log.Println("  POST   /admin/promotions    - Create promotion (admin only)")
+	log.Println("  GET    /admin/promotions/:id - Get promotion (admin only)")
+	log.Println("  PUT    /admin/promotions/:id - Replace promotion (admin only)")
+	log.Println("  DELETE /admin/promotions/:id - Delete an unused promotion (admin only)")
+	log.Println("  PUT    /admin/exchange-rates/:currency - Set an exchange rate (admin only)")
+	log.Println("  PUT    /admin/users/:id/role - Make a user a customer or an admin (admin only)")
+	log.Println("  PUT    /admin/shipments/:id/delivered - Mark a shipment delivered (admin only)")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -89,6 +89,18 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// OptionalAuth passes requests without an Authorization header through as anonymous,
+// and validates the token like AuthMiddleware if there is one
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		AuthMiddleware(next)(w, r)
+	}
+}
+
 // RequireRole middleware checks if user has required role
 func RequireRole(allowedRoles ...string) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
@@ -22,11 +22,17 @@ const (
 )
 
 type CreateProductRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Description   string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
-	Price         float64                `protobuf:"fixed64,3,opt,name=price,proto3" json:"price,omitempty"`
-	Stock         int32                  `protobuf:"varint,4,opt,name=stock,proto3" json:"stock,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Description string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Stock       int32                  `protobuf:"varint,4,opt,name=stock,proto3" json:"stock,omitempty"`
+	TaxCategory string                 `protobuf:"bytes,5,opt,name=tax_category,json=taxCategory,proto3" json:"tax_category,omitempty"` // defaults to standard
+	// Shipping weight and package dimensions of one unit
+	WeightGrams   int32   `protobuf:"varint,6,opt,name=weight_grams,json=weightGrams,proto3" json:"weight_grams,omitempty"`
+	LengthCm      float64 `protobuf:"fixed64,7,opt,name=length_cm,json=lengthCm,proto3" json:"length_cm,omitempty"`
+	WidthCm       float64 `protobuf:"fixed64,8,opt,name=width_cm,json=widthCm,proto3" json:"width_cm,omitempty"`
+	HeightCm      float64 `protobuf:"fixed64,9,opt,name=height_cm,json=heightCm,proto3" json:"height_cm,omitempty"`
+	Price         *Money  `protobuf:"bytes,10,opt,name=price,proto3" json:"price,omitempty"` // base price, its currency is the product's base currency
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -75,23 +81,59 @@ func (x *CreateProductRequest) GetDescription() string {
 	return ""
 }
 
-func (x *CreateProductRequest) GetPrice() float64 {
+func (x *CreateProductRequest) GetStock() int32 {
 	if x != nil {
-		return x.Price
+		return x.Stock
 	}
 	return 0
 }
 
-func (x *CreateProductRequest) GetStock() int32 {
+func (x *CreateProductRequest) GetTaxCategory() string {
 	if x != nil {
-		return x.Stock
+		return x.TaxCategory
+	}
+	return ""
+}
+
+func (x *CreateProductRequest) GetWeightGrams() int32 {
+	if x != nil {
+		return x.WeightGrams
 	}
 	return 0
 }
 
+func (x *CreateProductRequest) GetLengthCm() float64 {
+	if x != nil {
+		return x.LengthCm
+	}
+	return 0
+}
+
+func (x *CreateProductRequest) GetWidthCm() float64 {
+	if x != nil {
+		return x.WidthCm
+	}
+	return 0
+}
+
+func (x *CreateProductRequest) GetHeightCm() float64 {
+	if x != nil {
+		return x.HeightCm
+	}
+	return 0
+}
+
+func (x *CreateProductRequest) GetPrice() *Money {
+	if x != nil {
+		return x.Price
+	}
+	return nil
+}
+
 type GetProductRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ProductId     string                 `protobuf:"bytes,1,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
+	Currency      string                 `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"` // price to return; empty for the base price
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -133,9 +175,17 @@ func (x *GetProductRequest) GetProductId() string {
 	return ""
 }
 
+func (x *GetProductRequest) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
 type ListProductsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Limit         int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Currency      string                 `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -177,13 +227,28 @@ func (x *ListProductsRequest) GetLimit() int32 {
 	return 0
 }
 
+func (x *ListProductsRequest) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
 type ProductResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ProductId     string                 `protobuf:"bytes,1,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
-	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
-	Description   string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
-	Price         float64                `protobuf:"fixed64,4,opt,name=price,proto3" json:"price,omitempty"`
-	Stock         int32                  `protobuf:"varint,5,opt,name=stock,proto3" json:"stock,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	ProductId   string                 `protobuf:"bytes,1,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Description string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Stock       int32                  `protobuf:"varint,5,opt,name=stock,proto3" json:"stock,omitempty"`
+	TaxCategory string                 `protobuf:"bytes,6,opt,name=tax_category,json=taxCategory,proto3" json:"tax_category,omitempty"`
+	WeightGrams int32                  `protobuf:"varint,7,opt,name=weight_grams,json=weightGrams,proto3" json:"weight_grams,omitempty"`
+	LengthCm    float64                `protobuf:"fixed64,8,opt,name=length_cm,json=lengthCm,proto3" json:"length_cm,omitempty"`
+	WidthCm     float64                `protobuf:"fixed64,9,opt,name=width_cm,json=widthCm,proto3" json:"width_cm,omitempty"`
+	HeightCm    float64                `protobuf:"fixed64,10,opt,name=height_cm,json=heightCm,proto3" json:"height_cm,omitempty"`
+	// Price in the requested currency: the price set for it, otherwise the base
+	// price converted at the current exchange rate
+	Price         *Money   `protobuf:"bytes,11,opt,name=price,proto3" json:"price,omitempty"`
+	Prices        []*Money `protobuf:"bytes,12,rep,name=prices,proto3" json:"prices,omitempty"` // base price and every price set per currency
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -239,20 +304,62 @@ func (x *ProductResponse) GetDescription() string {
 	return ""
 }
 
-func (x *ProductResponse) GetPrice() float64 {
+func (x *ProductResponse) GetStock() int32 {
 	if x != nil {
-		return x.Price
+		return x.Stock
 	}
 	return 0
 }
 
-func (x *ProductResponse) GetStock() int32 {
+func (x *ProductResponse) GetTaxCategory() string {
 	if x != nil {
-		return x.Stock
+		return x.TaxCategory
+	}
+	return ""
+}
+
+func (x *ProductResponse) GetWeightGrams() int32 {
+	if x != nil {
+		return x.WeightGrams
 	}
 	return 0
 }
 
+func (x *ProductResponse) GetLengthCm() float64 {
+	if x != nil {
+		return x.LengthCm
+	}
+	return 0
+}
+
+func (x *ProductResponse) GetWidthCm() float64 {
+	if x != nil {
+		return x.WidthCm
+	}
+	return 0
+}
+
+func (x *ProductResponse) GetHeightCm() float64 {
+	if x != nil {
+		return x.HeightCm
+	}
+	return 0
+}
+
+func (x *ProductResponse) GetPrice() *Money {
+	if x != nil {
+		return x.Price
+	}
+	return nil
+}
+
+func (x *ProductResponse) GetPrices() []*Money {
+	if x != nil {
+		return x.Prices
+	}
+	return nil
+}
+
 type ListProductsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Products      []*ProductResponse     `protobuf:"bytes,1,rep,name=products,proto3" json:"products,omitempty"`
@@ -349,40 +456,385 @@ func (x *UpdateInventoryRequest) GetQuantityChange() int32 {
 	return 0
 }
 
+// SetProductPriceRequest sets the price of a product in one currency. Setting it in
+// the product's base currency changes the base price.
+type SetProductPriceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ProductId     string                 `protobuf:"bytes,1,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
+	Price         *Money                 `protobuf:"bytes,2,opt,name=price,proto3" json:"price,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetProductPriceRequest) Reset() {
+	*x = SetProductPriceRequest{}
+	mi := &file_proto_product_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetProductPriceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetProductPriceRequest) ProtoMessage() {}
+
+func (x *SetProductPriceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetProductPriceRequest.ProtoReflect.Descriptor instead.
+func (*SetProductPriceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SetProductPriceRequest) GetProductId() string {
+	if x != nil {
+		return x.ProductId
+	}
+	return ""
+}
+
+func (x *SetProductPriceRequest) GetPrice() *Money {
+	if x != nil {
+		return x.Price
+	}
+	return nil
+}
+
+type RemoveProductPriceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ProductId     string                 `protobuf:"bytes,1,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
+	Currency      string                 `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveProductPriceRequest) Reset() {
+	*x = RemoveProductPriceRequest{}
+	mi := &file_proto_product_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveProductPriceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveProductPriceRequest) ProtoMessage() {}
+
+func (x *RemoveProductPriceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveProductPriceRequest.ProtoReflect.Descriptor instead.
+func (*RemoveProductPriceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RemoveProductPriceRequest) GetProductId() string {
+	if x != nil {
+		return x.ProductId
+	}
+	return ""
+}
+
+func (x *RemoveProductPriceRequest) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+type ExchangeRate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Currency      string                 `protobuf:"bytes,1,opt,name=currency,proto3" json:"currency,omitempty"`
+	Rate          string                 `protobuf:"bytes,2,opt,name=rate,proto3" json:"rate,omitempty"` // decimal, units of currency per unit of the base currency
+	UpdatedAt     int64                  `protobuf:"varint,3,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExchangeRate) Reset() {
+	*x = ExchangeRate{}
+	mi := &file_proto_product_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExchangeRate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExchangeRate) ProtoMessage() {}
+
+func (x *ExchangeRate) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExchangeRate.ProtoReflect.Descriptor instead.
+func (*ExchangeRate) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExchangeRate) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *ExchangeRate) GetRate() string {
+	if x != nil {
+		return x.Rate
+	}
+	return ""
+}
+
+func (x *ExchangeRate) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+type SetExchangeRateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Currency      string                 `protobuf:"bytes,1,opt,name=currency,proto3" json:"currency,omitempty"`
+	Rate          string                 `protobuf:"bytes,2,opt,name=rate,proto3" json:"rate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetExchangeRateRequest) Reset() {
+	*x = SetExchangeRateRequest{}
+	mi := &file_proto_product_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetExchangeRateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetExchangeRateRequest) ProtoMessage() {}
+
+func (x *SetExchangeRateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetExchangeRateRequest.ProtoReflect.Descriptor instead.
+func (*SetExchangeRateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SetExchangeRateRequest) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *SetExchangeRateRequest) GetRate() string {
+	if x != nil {
+		return x.Rate
+	}
+	return ""
+}
+
+type ListExchangeRatesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExchangeRatesRequest) Reset() {
+	*x = ListExchangeRatesRequest{}
+	mi := &file_proto_product_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExchangeRatesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExchangeRatesRequest) ProtoMessage() {}
+
+func (x *ListExchangeRatesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExchangeRatesRequest.ProtoReflect.Descriptor instead.
+func (*ListExchangeRatesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{10}
+}
+
+type ListExchangeRatesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BaseCurrency  string                 `protobuf:"bytes,1,opt,name=base_currency,json=baseCurrency,proto3" json:"base_currency,omitempty"`
+	Rates         []*ExchangeRate        `protobuf:"bytes,2,rep,name=rates,proto3" json:"rates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExchangeRatesResponse) Reset() {
+	*x = ListExchangeRatesResponse{}
+	mi := &file_proto_product_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExchangeRatesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExchangeRatesResponse) ProtoMessage() {}
+
+func (x *ListExchangeRatesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_product_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExchangeRatesResponse.ProtoReflect.Descriptor instead.
+func (*ListExchangeRatesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_product_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListExchangeRatesResponse) GetBaseCurrency() string {
+	if x != nil {
+		return x.BaseCurrency
+	}
+	return ""
+}
+
+func (x *ListExchangeRatesResponse) GetRates() []*ExchangeRate {
+	if x != nil {
+		return x.Rates
+	}
+	return nil
+}
+
 var File_proto_product_proto protoreflect.FileDescriptor
 
 const file_proto_product_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/product.proto\x12\aproduct\"x\n" +
+	"\x13proto/product.proto\x12\aproduct\x1a\x11proto/money.proto\"\xa7\x02\n" +
 	"\x14CreateProductRequest\x12\x12\n" +
 	"\x04name\x18\x01 \x01(\tR\x04name\x12 \n" +
 	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x14\n" +
-	"\x05price\x18\x03 \x01(\x01R\x05price\x12\x14\n" +
-	"\x05stock\x18\x04 \x01(\x05R\x05stock\"2\n" +
+	"\x05stock\x18\x04 \x01(\x05R\x05stock\x12!\n" +
+	"\ftax_category\x18\x05 \x01(\tR\vtaxCategory\x12!\n" +
+	"\fweight_grams\x18\x06 \x01(\x05R\vweightGrams\x12\x1b\n" +
+	"\tlength_cm\x18\a \x01(\x01R\blengthCm\x12\x19\n" +
+	"\bwidth_cm\x18\b \x01(\x01R\awidthCm\x12\x1b\n" +
+	"\theight_cm\x18\t \x01(\x01R\bheightCm\x12\"\n" +
+	"\x05price\x18\n" +
+	" \x01(\v2\f.money.MoneyR\x05priceJ\x04\b\x03\x10\x04\"N\n" +
 	"\x11GetProductRequest\x12\x1d\n" +
 	"\n" +
-	"product_id\x18\x01 \x01(\tR\tproductId\"+\n" +
+	"product_id\x18\x01 \x01(\tR\tproductId\x12\x1a\n" +
+	"\bcurrency\x18\x02 \x01(\tR\bcurrency\"G\n" +
 	"\x13ListProductsRequest\x12\x14\n" +
-	"\x05limit\x18\x01 \x01(\x05R\x05limit\"\x92\x01\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x1a\n" +
+	"\bcurrency\x18\x02 \x01(\tR\bcurrency\"\xe7\x02\n" +
 	"\x0fProductResponse\x12\x1d\n" +
 	"\n" +
 	"product_id\x18\x01 \x01(\tR\tproductId\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12 \n" +
 	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\x14\n" +
-	"\x05price\x18\x04 \x01(\x01R\x05price\x12\x14\n" +
-	"\x05stock\x18\x05 \x01(\x05R\x05stock\"L\n" +
+	"\x05stock\x18\x05 \x01(\x05R\x05stock\x12!\n" +
+	"\ftax_category\x18\x06 \x01(\tR\vtaxCategory\x12!\n" +
+	"\fweight_grams\x18\a \x01(\x05R\vweightGrams\x12\x1b\n" +
+	"\tlength_cm\x18\b \x01(\x01R\blengthCm\x12\x19\n" +
+	"\bwidth_cm\x18\t \x01(\x01R\awidthCm\x12\x1b\n" +
+	"\theight_cm\x18\n" +
+	" \x01(\x01R\bheightCm\x12\"\n" +
+	"\x05price\x18\v \x01(\v2\f.money.MoneyR\x05price\x12$\n" +
+	"\x06prices\x18\f \x03(\v2\f.money.MoneyR\x06pricesJ\x04\b\x04\x10\x05\"L\n" +
 	"\x14ListProductsResponse\x124\n" +
 	"\bproducts\x18\x01 \x03(\v2\x18.product.ProductResponseR\bproducts\"`\n" +
 	"\x16UpdateInventoryRequest\x12\x1d\n" +
 	"\n" +
 	"product_id\x18\x01 \x01(\tR\tproductId\x12'\n" +
-	"\x0fquantity_change\x18\x02 \x01(\x05R\x0equantityChange2\xb9\x02\n" +
+	"\x0fquantity_change\x18\x02 \x01(\x05R\x0equantityChange\"[\n" +
+	"\x16SetProductPriceRequest\x12\x1d\n" +
+	"\n" +
+	"product_id\x18\x01 \x01(\tR\tproductId\x12\"\n" +
+	"\x05price\x18\x02 \x01(\v2\f.money.MoneyR\x05price\"V\n" +
+	"\x19RemoveProductPriceRequest\x12\x1d\n" +
+	"\n" +
+	"product_id\x18\x01 \x01(\tR\tproductId\x12\x1a\n" +
+	"\bcurrency\x18\x02 \x01(\tR\bcurrency\"]\n" +
+	"\fExchangeRate\x12\x1a\n" +
+	"\bcurrency\x18\x01 \x01(\tR\bcurrency\x12\x12\n" +
+	"\x04rate\x18\x02 \x01(\tR\x04rate\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\x03 \x01(\x03R\tupdatedAt\"H\n" +
+	"\x16SetExchangeRateRequest\x12\x1a\n" +
+	"\bcurrency\x18\x01 \x01(\tR\bcurrency\x12\x12\n" +
+	"\x04rate\x18\x02 \x01(\tR\x04rate\"\x1a\n" +
+	"\x18ListExchangeRatesRequest\"m\n" +
+	"\x19ListExchangeRatesResponse\x12#\n" +
+	"\rbase_currency\x18\x01 \x01(\tR\fbaseCurrency\x12+\n" +
+	"\x05rates\x18\x02 \x03(\v2\x15.product.ExchangeRateR\x05rates2\x82\x05\n" +
 	"\x0eProductService\x12H\n" +
 	"\rCreateProduct\x12\x1d.product.CreateProductRequest\x1a\x18.product.ProductResponse\x12B\n" +
 	"\n" +
 	"GetProduct\x12\x1a.product.GetProductRequest\x1a\x18.product.ProductResponse\x12K\n" +
 	"\fListProducts\x12\x1c.product.ListProductsRequest\x1a\x1d.product.ListProductsResponse\x12L\n" +
-	"\x0fUpdateInventory\x12\x1f.product.UpdateInventoryRequest\x1a\x18.product.ProductResponseB\x13Z\x11api-gateway/protob\x06proto3"
+	"\x0fUpdateInventory\x12\x1f.product.UpdateInventoryRequest\x1a\x18.product.ProductResponse\x12L\n" +
+	"\x0fSetProductPrice\x12\x1f.product.SetProductPriceRequest\x1a\x18.product.ProductResponse\x12R\n" +
+	"\x12RemoveProductPrice\x12\".product.RemoveProductPriceRequest\x1a\x18.product.ProductResponse\x12I\n" +
+	"\x0fSetExchangeRate\x12\x1f.product.SetExchangeRateRequest\x1a\x15.product.ExchangeRate\x12Z\n" +
+	"\x11ListExchangeRates\x12!.product.ListExchangeRatesRequest\x1a\".product.ListExchangeRatesResponseB\x13Z\x11api-gateway/protob\x06proto3"
 
 var (
 	file_proto_product_proto_rawDescOnce sync.Once
@@ -396,30 +848,50 @@ func file_proto_product_proto_rawDescGZIP() []byte {
 	return file_proto_product_proto_rawDescData
 }
 
-var file_proto_product_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_product_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_proto_product_proto_goTypes = []any{
-	(*CreateProductRequest)(nil),   // 0: product.CreateProductRequest
-	(*GetProductRequest)(nil),      // 1: product.GetProductRequest
-	(*ListProductsRequest)(nil),    // 2: product.ListProductsRequest
-	(*ProductResponse)(nil),        // 3: product.ProductResponse
-	(*ListProductsResponse)(nil),   // 4: product.ListProductsResponse
-	(*UpdateInventoryRequest)(nil), // 5: product.UpdateInventoryRequest
+	(*CreateProductRequest)(nil),      // 0: product.CreateProductRequest
+	(*GetProductRequest)(nil),         // 1: product.GetProductRequest
+	(*ListProductsRequest)(nil),       // 2: product.ListProductsRequest
+	(*ProductResponse)(nil),           // 3: product.ProductResponse
+	(*ListProductsResponse)(nil),      // 4: product.ListProductsResponse
+	(*UpdateInventoryRequest)(nil),    // 5: product.UpdateInventoryRequest
+	(*SetProductPriceRequest)(nil),    // 6: product.SetProductPriceRequest
+	(*RemoveProductPriceRequest)(nil), // 7: product.RemoveProductPriceRequest
+	(*ExchangeRate)(nil),              // 8: product.ExchangeRate
+	(*SetExchangeRateRequest)(nil),    // 9: product.SetExchangeRateRequest
+	(*ListExchangeRatesRequest)(nil),  // 10: product.ListExchangeRatesRequest
+	(*ListExchangeRatesResponse)(nil), // 11: product.ListExchangeRatesResponse
+	(*Money)(nil),                     // 12: money.Money
 }
 var file_proto_product_proto_depIdxs = []int32{
-	3, // 0: product.ListProductsResponse.products:type_name -> product.ProductResponse
-	0, // 1: product.ProductService.CreateProduct:input_type -> product.CreateProductRequest
-	1, // 2: product.ProductService.GetProduct:input_type -> product.GetProductRequest
-	2, // 3: product.ProductService.ListProducts:input_type -> product.ListProductsRequest
-	5, // 4: product.ProductService.UpdateInventory:input_type -> product.UpdateInventoryRequest
-	3, // 5: product.ProductService.CreateProduct:output_type -> product.ProductResponse
-	3, // 6: product.ProductService.GetProduct:output_type -> product.ProductResponse
-	4, // 7: product.ProductService.ListProducts:output_type -> product.ListProductsResponse
-	3, // 8: product.ProductService.UpdateInventory:output_type -> product.ProductResponse
-	5, // [5:9] is the sub-list for method output_type
-	1, // [1:5] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	12, // 0: product.CreateProductRequest.price:type_name -> money.Money
+	12, // 1: product.ProductResponse.price:type_name -> money.Money
+	12, // 2: product.ProductResponse.prices:type_name -> money.Money
+	3,  // 3: product.ListProductsResponse.products:type_name -> product.ProductResponse
+	12, // 4: product.SetProductPriceRequest.price:type_name -> money.Money
+	8,  // 5: product.ListExchangeRatesResponse.rates:type_name -> product.ExchangeRate
+	0,  // 6: product.ProductService.CreateProduct:input_type -> product.CreateProductRequest
+	1,  // 7: product.ProductService.GetProduct:input_type -> product.GetProductRequest
+	2,  // 8: product.ProductService.ListProducts:input_type -> product.ListProductsRequest
+	5,  // 9: product.ProductService.UpdateInventory:input_type -> product.UpdateInventoryRequest
+	6,  // 10: product.ProductService.SetProductPrice:input_type -> product.SetProductPriceRequest
+	7,  // 11: product.ProductService.RemoveProductPrice:input_type -> product.RemoveProductPriceRequest
+	9,  // 12: product.ProductService.SetExchangeRate:input_type -> product.SetExchangeRateRequest
+	10, // 13: product.ProductService.ListExchangeRates:input_type -> product.ListExchangeRatesRequest
+	3,  // 14: product.ProductService.CreateProduct:output_type -> product.ProductResponse
+	3,  // 15: product.ProductService.GetProduct:output_type -> product.ProductResponse
+	4,  // 16: product.ProductService.ListProducts:output_type -> product.ListProductsResponse
+	3,  // 17: product.ProductService.UpdateInventory:output_type -> product.ProductResponse
+	3,  // 18: product.ProductService.SetProductPrice:output_type -> product.ProductResponse
+	3,  // 19: product.ProductService.RemoveProductPrice:output_type -> product.ProductResponse
+	8,  // 20: product.ProductService.SetExchangeRate:output_type -> product.ExchangeRate
+	11, // 21: product.ProductService.ListExchangeRates:output_type -> product.ListExchangeRatesResponse
+	14, // [14:22] is the sub-list for method output_type
+	6,  // [6:14] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_proto_product_proto_init() }
@@ -427,13 +899,14 @@ func file_proto_product_proto_init() {
 	if File_proto_product_proto != nil {
 		return
 	}
+	file_proto_money_proto_init()
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_product_proto_rawDesc), len(file_proto_product_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,30 @@
+// Package events publishes and consumes the events of the shared catalogue as
+// notification-service
+package events
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	catalogue "shared/events"
+	"shared/messaging"
+)
+
+// Source identifies this service as the producer of the events it publishes
+const Source = "notification-service"
+
+// New wraps data in an envelope for eventType, checking that data is the payload
+// the catalogue lists for it
+func New(ctx context.Context, eventType string, data proto.Message) (*messaging.Envelope, error) {
+	return catalogue.New(ctx, Source, eventType, data)
+}
+
+// Handle registers handle on q for eventType, accepting schema versions up to the
+// one in the catalogue
+func Handle[T any, P interface {
+	*T
+	proto.Message
+}](q *messaging.Queue, eventType string, handle func(ctx context.Context, env *messaging.Envelope, event P) error) {
+	catalogue.Handle(q, eventType, handle)
+}
@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File writes every email as a .eml file into a directory instead of sending it,
+// for development and tests
+type File struct {
+	Dir  string
+	From string
+}
+
+// NewFile reads the options dir and from, creating dir if needed
+func NewFile(options map[string]string) (*File, error) {
+	f := &File{Dir: options["dir"], From: options["from"]}
+	if f.Dir == "" {
+		return nil, fmt.Errorf("mail directory required")
+	}
+	if f.From == "" {
+		f.From = "shop@localhost"
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Name() string {
+	return "file " + f.Dir
+}
+
+func (f *File) Send(ctx context.Context, email *Email) error {
+	if err := checkAddress(email.To); err != nil {
+		return err
+	}
+	msg, err := buildMessage(f.From, email)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary name first so readers never see half a message
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), messageID())
+	tmp := filepath.Join(f.Dir, "."+name)
+	if err := os.WriteFile(tmp, msg, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(f.Dir, name))
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps sent emails in memory for unit tests. Set Err to make Send fail.
+type Memory struct {
+	mu   sync.Mutex
+	sent []*Email
+	Err  error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Name() string {
+	return "memory"
+}
+
+func (m *Memory) Send(ctx context.Context, email *Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	copied := *email
+	m.sent = append(m.sent, &copied)
+	return nil
+}
+
+// Sent returns the emails sent so far, in order
+func (m *Memory) Sent() []*Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Email(nil), m.sent...)
+}
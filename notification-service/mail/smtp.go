@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// smtpTimeout bounds one delivery when ctx has no deadline
+const smtpTimeout = 30 * time.Second
+
+// SMTP sends emails through an SMTP server. It upgrades to TLS when the server
+// offers STARTTLS and authenticates when a username is configured.
+type SMTP struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// NewSMTP reads the options addr, from, username and password
+func NewSMTP(options map[string]string) (*SMTP, error) {
+	s := &SMTP{
+		Addr:     options["addr"],
+		From:     options["from"],
+		Username: options["username"],
+		Password: options["password"],
+	}
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %v", s.Addr, err)
+	}
+	if err := checkAddress(s.From); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SMTP) Name() string {
+	return "smtp " + s.Addr
+}
+
+func (s *SMTP) Send(ctx context.Context, email *Email) error {
+	if err := checkAddress(email.To); err != nil {
+		return err
+	}
+	msg, err := buildMessage(s.From, email)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	host, _, _ := net.SplitHostPort(s.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(nil); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(email.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
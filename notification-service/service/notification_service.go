@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"notification-service/events"
+	"notification-service/mail"
+	"notification-service/templates"
+	"shared/messaging"
+	pb "shared/proto"
+)
+
+// Delivery statuses stored in Notification.Status
+const (
+	StatusPending = "pending" // not sent yet, or sending failed and will be retried
+	StatusSending = "sending" // being sent by a replica until LeaseUntil
+	StatusSent    = "sent"
+	StatusFailed  = "failed"  // the email could not be rendered, or sending failed maxAttempts times
+	StatusSkipped = "skipped" // no email address is known for the user
+)
+
+// orderEventsExchange is the topic exchange order-service publishes to
+const orderEventsExchange = "order_events"
+
+// orderEventsQueue receives the order events customers are emailed about
+const orderEventsQueue = "notification-service.orders"
+
+// userEventsExchange is the topic exchange user-service publishes to
+const userEventsExchange = "user_events"
+
+// userEventsQueue receives the user events customers are emailed about
+const userEventsQueue = "notification-service.users"
+
+// maxAttempts is how often sending an email is tried before it is failed
+const maxAttempts = 8
+
+// sendLease is how long a replica may take to send an email before another one
+// takes it over. It outlasts the SMTP timeout.
+const sendLease = 2 * time.Minute
+
+// Notification is the delivery log entry of the email sent for one event. It is
+// keyed by the event ID, so a redelivered event never sends a second email.
+type Notification struct {
+	ID         string `gorm:"primaryKey;type:varchar(255)"` // ID of the event
+	EventType  string `gorm:"not null;type:varchar(100)"`
+	UserID     string `gorm:"type:varchar(255);index"`
+	Template   string `gorm:"not null;type:varchar(100)"`
+	Recipient  string `gorm:"type:varchar(255)"`
+	Locale     string `gorm:"type:varchar(35)"` // locale of the template that was used
+	Status     string `gorm:"not null;type:varchar(50);index"`
+	Attempts   int    `gorm:"not null;default:0"`
+	LastError  string `gorm:"type:text"`
+	LeaseUntil int64  `gorm:"not null;default:0"` // end of the sending claim, Unix seconds
+	SentAt     *int64
+	CreatedAt  int64 `gorm:"autoCreateTime"`
+	UpdatedAt  int64 `gorm:"autoUpdateTime"`
+}
+
+// Recipient is what user.registered told about a user, for emails about their orders
+type Recipient struct {
+	UserID    string `gorm:"primaryKey;type:varchar(255)"`
+	Email     string `gorm:"not null;type:varchar(255)"`
+	Name      string `gorm:"type:varchar(255)"`
+	Locale    string `gorm:"type:varchar(35)"`
+	UpdatedAt int64  `gorm:"autoUpdateTime"`
+}
+
+type NotificationService struct {
+	db       *gorm.DB
+	sender   mail.Sender
+	renderer *templates.Renderer
+}
+
+func NewNotificationService(db *gorm.DB, sender mail.Sender, renderer *templates.Renderer) *NotificationService {
+	return &NotificationService{
+		db:       db,
+		sender:   sender,
+		renderer: renderer,
+	}
+}
+
+// templateData is what the email templates see
+type templateData struct {
+	Name  string      // the user's name, or their email address if unknown
+	Event interface{} // the payload of the event
+}
+
+// ConsumeEvents starts emailing customers about their account and orders. The
+// queues give up on an event long after notify has given up on its email.
+func (s *NotificationService) ConsumeEvents(ctx context.Context, broker messaging.Broker) error {
+	orders := &messaging.Queue{Name: orderEventsQueue, Exchange: orderEventsExchange, MaxAttempts: 2 * maxAttempts}
+	events.Handle(orders, "order.created", s.handleOrderCreated)
+	events.Handle(orders, "order.shipped", s.handleOrderShipped)
+	events.Handle(orders, "order.status_changed", s.handleOrderStatusChanged)
+	if err := broker.Consume(ctx, orders); err != nil {
+		return err
+	}
+
+	users := &messaging.Queue{Name: userEventsQueue, Exchange: userEventsExchange, MaxAttempts: 2 * maxAttempts}
+	events.Handle(users, "user.registered", s.handleUserRegistered)
+	return broker.Consume(ctx, users)
+}
+
+func (s *NotificationService) handleUserRegistered(ctx context.Context, env *messaging.Envelope, event *pb.UserRegisteredEvent) error {
+	if event.UserId == "" {
+		return messaging.Permanent(fmt.Errorf("user ID missing"))
+	}
+	recipient := &Recipient{
+		UserID: event.UserId,
+		Email:  event.Email,
+		Name:   event.Name,
+		Locale: event.Locale,
+	}
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "name", "locale", "updated_at"}),
+	}
+	if err := s.db.WithContext(ctx).Clauses(upsert).Create(recipient).Error; err != nil {
+		return fmt.Errorf("failed to save recipient: %v", err)
+	}
+	return s.notify(ctx, env, event.UserId, event.Email, "welcome", event)
+}
+
+func (s *NotificationService) handleOrderCreated(ctx context.Context, env *messaging.Envelope, event *pb.OrderCreatedEvent) error {
+	return s.notify(ctx, env, event.UserId, event.UserEmail, "order_created", event)
+}
+
+func (s *NotificationService) handleOrderShipped(ctx context.Context, env *messaging.Envelope, event *pb.OrderShippedEvent) error {
+	return s.notify(ctx, env, event.UserId, event.UserEmail, "order_shipped", event)
+}
+
+// handleOrderStatusChanged emails about deliveries and cancellations. Shipping is
+// announced by order.shipped, which has the tracking details.
+func (s *NotificationService) handleOrderStatusChanged(ctx context.Context, env *messaging.Envelope, event *pb.OrderStatusChangedEvent) error {
+	switch event.NewStatus {
+	case "delivered":
+		return s.notify(ctx, env, event.UserId, event.UserEmail, "order_delivered", event)
+	case "cancelled":
+		return s.notify(ctx, env, event.UserId, event.UserEmail, "order_cancelled", event)
+	}
+	return nil
+}
+
+// notify sends the email rendered from template for the event once. Its delivery
+// log entry is claimed with a lease before sending, so replicas handling a
+// redelivered event do not send it twice, and no transaction stays open while the
+// mail server is talked to. A failed send is retried with the event, up to
+// maxAttempts.
+func (s *NotificationService) notify(ctx context.Context, env *messaging.Envelope, userID, email, template string, event interface{}) error {
+	if env.ID == "" {
+		log.Printf("Not sending %s for %s event without ID", template, env.Type)
+		return nil
+	}
+
+	data := templateData{Event: event}
+	locale := templates.DefaultLocale
+	var recipient Recipient
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Limit(1).Find(&recipient).Error
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if email == "" {
+		email = recipient.Email
+	}
+	if recipient.Name != "" {
+		data.Name = recipient.Name
+	} else {
+		data.Name = email
+	}
+	if recipient.Locale != "" {
+		locale = recipient.Locale
+	}
+
+	entry := &Notification{
+		ID:        env.ID,
+		EventType: env.Type,
+		UserID:    userID,
+		Template:  template,
+		Recipient: email,
+		Locale:    locale,
+		Status:    StatusPending,
+	}
+	if email == "" {
+		entry.Status = StatusSkipped
+		log.Printf("Not sending %s for event %s: no email address for user %s", template, env.ID, userID)
+	}
+	db := s.db.WithContext(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to log notification: %v", err)
+	}
+
+	n, rendered, err := s.claimNotification(ctx, env.ID, data)
+	if err != nil || rendered == nil {
+		return err
+	}
+
+	sendErr := s.sender.Send(ctx, rendered)
+	updates := map[string]interface{}{"lease_until": 0}
+	switch {
+	case sendErr == nil:
+		now := time.Now().Unix()
+		updates["status"] = StatusSent
+		updates["sent_at"] = &now
+		updates["last_error"] = ""
+		log.Printf("Sent %s for event %s to %s", n.Template, n.ID, n.Recipient)
+	case n.Attempts >= maxAttempts:
+		log.Printf("Giving up on %s for event %s after %d attempts: %v", n.Template, n.ID, n.Attempts, sendErr)
+		updates["status"] = StatusFailed
+		updates["last_error"] = sendErr.Error()
+		sendErr = nil
+	default:
+		updates["status"] = StatusPending
+		updates["last_error"] = sendErr.Error()
+	}
+
+	// Only recorded while the lease holds; once it ran out another replica owns the entry
+	result := db.Model(&Notification{}).Where("id = ? AND status = ? AND lease_until = ?", n.ID, StatusSending, n.LeaseUntil).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update notification %s: %v", env.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Lease on notification %s ran out while sending", n.ID)
+	}
+	return sendErr
+}
+
+// claimNotification renders the email of a pending notification and marks it as
+// sending until sendLease from now. It returns no email when there is nothing to
+// send, and an error while another replica holds the lease, so that the event is
+// retried: if that replica dies, its lease runs out and the retry sends the email.
+func (s *NotificationService) claimNotification(ctx context.Context, id string, data templateData) (*Notification, *mail.Email, error) {
+	var n Notification
+	var rendered *mail.Email
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&n).Error; err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		switch {
+		case n.Status == StatusSending && n.LeaseUntil > now:
+			return fmt.Errorf("being sent by another replica")
+		case n.Status != StatusPending && n.Status != StatusSending:
+			return nil
+		}
+
+		email, usedLocale, err := s.renderer.Render(n.Template, n.Locale, data)
+		if err != nil {
+			log.Printf("Failed to render %s for event %s: %v", n.Template, n.ID, err)
+			return tx.Model(&n).Updates(map[string]interface{}{"status": StatusFailed, "last_error": err.Error()}).Error
+		}
+		email.To = n.Recipient
+
+		n.Status = StatusSending
+		n.Attempts++
+		n.LeaseUntil = now + int64(sendLease/time.Second)
+		updates := map[string]interface{}{"status": n.Status, "attempts": n.Attempts, "locale": usedLocale, "lease_until": n.LeaseUntil}
+		if err := tx.Model(&n).Updates(updates).Error; err != nil {
+			return err
+		}
+		rendered = email
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to claim notification %s: %v", id, err)
+	}
+	return &n, rendered, nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"notification-service/events"
+	"notification-service/mail"
+	"notification-service/templates"
+	"shared/messaging"
+	pb "shared/proto"
+)
+
+// newTestService returns a NotificationService on an empty sqlite database that
+// sends to a memory sink and consumes events from a MemoryBroker
+func newTestService(t *testing.T) (*NotificationService, *mail.Memory, *messaging.MemoryBroker) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Notification{}, &Recipient{}); err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := templates.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := mail.NewMemory()
+	broker := messaging.NewMemoryBroker()
+	s := NewNotificationService(db, sink, renderer)
+	if err := s.ConsumeEvents(context.Background(), broker); err != nil {
+		t.Fatal(err)
+	}
+	return s, sink, broker
+}
+
+// orderCreated returns the envelope of an order.created event of user u1
+func orderCreated(t *testing.T, email string) *messaging.Envelope {
+	env, err := events.New(context.Background(), "order.created", &pb.OrderCreatedEvent{
+		OrderId: "o1", UserId: "u1", UserEmail: email, Currency: "USD", TotalMinor: 1999,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func publish(t *testing.T, broker *messaging.MemoryBroker, env *messaging.Envelope) {
+	if err := broker.PublishEvent(orderEventsExchange, env.Type, env); err != nil {
+		t.Fatal(err)
+	}
+	broker.Drain()
+}
+
+func findNotification(t *testing.T, s *NotificationService, id string) *Notification {
+	var n Notification
+	if err := s.db.Where("id = ?", id).First(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &n
+}
+
+func TestNotifySendsOncePerEvent(t *testing.T) {
+	s, sink, broker := newTestService(t)
+	env := orderCreated(t, "u1@example.com")
+
+	publish(t, broker, env)
+	publish(t, broker, env)
+
+	sent := sink.Sent()
+	if len(sent) != 1 || sent[0].To != "u1@example.com" {
+		t.Fatalf("sent %v, want one email to u1@example.com", sent)
+	}
+	if n := findNotification(t, s, env.ID); n.Status != StatusSent || n.Attempts != 1 || n.SentAt == nil {
+		t.Errorf("notification %+v, want sent on the first attempt", n)
+	}
+
+	// Another event about the same order is another email
+	publish(t, broker, orderCreated(t, "u1@example.com"))
+	if got := len(sink.Sent()); got != 2 {
+		t.Errorf("sent %d emails for two events, want 2", got)
+	}
+}
+
+func TestNotifyGivesUpAfterMaxAttempts(t *testing.T) {
+	s, sink, broker := newTestService(t)
+	sink.Err = errors.New("mail server down")
+	env := orderCreated(t, "u1@example.com")
+
+	publish(t, broker, env)
+
+	n := findNotification(t, s, env.ID)
+	if n.Status != StatusFailed || n.Attempts != maxAttempts || n.LastError != "mail server down" {
+		t.Errorf("notification is %s after %d attempts with error %q, want failed after %d", n.Status, n.Attempts, n.LastError, maxAttempts)
+	}
+	// The event is done with once the email is given up on
+	if dead := broker.DeadLetters(orderEventsQueue); len(dead) != 0 {
+		t.Errorf("%d events dead-lettered, want none", len(dead))
+	}
+
+	// A redelivery does not try again
+	sink.Err = nil
+	publish(t, broker, env)
+	if sent := sink.Sent(); len(sent) != 0 {
+		t.Errorf("sent %d emails after giving up, want none", len(sent))
+	}
+}
+
+func TestNotifyRetriesFailedSend(t *testing.T) {
+	s, sink, _ := newTestService(t)
+	sink.Err = errors.New("mail server down")
+	env := orderCreated(t, "u1@example.com")
+	event := &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", UserEmail: "u1@example.com", Currency: "USD"}
+
+	if err := s.notify(context.Background(), env, "u1", "u1@example.com", "order_created", event); err == nil {
+		t.Fatal("notify succeeded although sending failed")
+	}
+	if n := findNotification(t, s, env.ID); n.Status != StatusPending || n.LastError != "mail server down" {
+		t.Errorf("notification %+v after a failed send, want pending with the error", n)
+	}
+
+	sink.Err = nil
+	if err := s.notify(context.Background(), env, "u1", "u1@example.com", "order_created", event); err != nil {
+		t.Fatal(err)
+	}
+	if n := findNotification(t, s, env.ID); n.Status != StatusSent || n.Attempts != 2 || n.LastError != "" {
+		t.Errorf("notification %+v after the retry, want sent on the second attempt", n)
+	}
+}
+
+func TestNotifySkipsUnknownAddress(t *testing.T) {
+	s, sink, broker := newTestService(t)
+	env := orderCreated(t, "")
+
+	publish(t, broker, env)
+
+	if sent := sink.Sent(); len(sent) != 0 {
+		t.Errorf("sent %d emails without an address, want none", len(sent))
+	}
+	if n := findNotification(t, s, env.ID); n.Status != StatusSkipped || n.Attempts != 0 {
+		t.Errorf("notification %+v, want skipped", n)
+	}
+
+	// The address user.registered told is used when the event has none
+	registered, err := events.New(context.Background(), "user.registered", &pb.UserRegisteredEvent{UserId: "u1", Email: "u1@example.com", Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.PublishEvent(userEventsExchange, registered.Type, registered); err != nil {
+		t.Fatal(err)
+	}
+	broker.Drain()
+	publish(t, broker, orderCreated(t, ""))
+	sent := sink.Sent()
+	if len(sent) != 2 || sent[1].To != "u1@example.com" {
+		t.Errorf("sent %v, want the welcome and the order email to u1@example.com", sent)
+	}
+}
+
+func TestNotifyTakesOverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	event := &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", UserEmail: "u1@example.com", Currency: "USD"}
+	tests := []struct {
+		name       string
+		leaseUntil time.Time
+		wantSent   bool
+	}{
+		{"held by another replica", time.Now().Add(time.Minute), false},
+		{"expired", time.Now().Add(-time.Second), true},
+	}
+	for _, tt := range tests {
+		s, sink, _ := newTestService(t)
+		env := orderCreated(t, "u1@example.com")
+		claimed := &Notification{
+			ID: env.ID, EventType: env.Type, UserID: "u1", Template: "order_created", Recipient: "u1@example.com",
+			Locale: templates.DefaultLocale, Status: StatusSending, Attempts: 1, LeaseUntil: tt.leaseUntil.Unix(),
+		}
+		if err := s.db.Create(claimed).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		err := s.notify(ctx, env, "u1", "u1@example.com", "order_created", event)
+		n := findNotification(t, s, env.ID)
+		if !tt.wantSent {
+			// The event is retried, so the email goes out if the other replica dies
+			if err == nil || len(sink.Sent()) != 0 || n.Status != StatusSending || n.Attempts != 1 {
+				t.Errorf("%s: error %v, %d emails sent and notification %+v; want an error and nothing sent", tt.name, err, len(sink.Sent()), n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(sink.Sent()) != 1 || n.Status != StatusSent || n.Attempts != 2 || n.LeaseUntil != 0 {
+			t.Errorf("%s: %d emails sent and notification %+v; want one sent on the second attempt", tt.name, len(sink.Sent()), n)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package templates renders notification emails. Every email has a text and an HTML
+// template per locale, <locale>/<name>.txt and <locale>/<name>.html, and the text
+// template defines the subject as "subject".
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"notification-service/mail"
+	"shared/money"
+)
+
+//go:embed en de
+var files embed.FS
+
+// DefaultLocale is used when there is no template for the recipient's locale
+const DefaultLocale = "en"
+
+var funcs = map[string]interface{}{
+	"money": formatMoney,
+}
+
+// Renderer holds the parsed templates, keyed by locale/name
+type Renderer struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// Load parses the embedded templates. Every text template needs an HTML template
+// in the same locale and the other way round.
+func Load() (*Renderer, error) {
+	r := &Renderer{
+		text: map[string]*texttemplate.Template{},
+		html: map[string]*htmltemplate.Template{},
+	}
+	err := fs.WalkDir(files, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := files.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSuffix(file, path.Ext(file))
+		switch path.Ext(file) {
+		case ".txt":
+			t, err := texttemplate.New(key).Funcs(funcs).Parse(string(content))
+			if err != nil {
+				return err
+			}
+			if t.Lookup("subject") == nil {
+				return fmt.Errorf("%s does not define a subject", file)
+			}
+			r.text[key] = t
+		case ".html":
+			t, err := htmltemplate.New(key).Funcs(funcs).Parse(string(content))
+			if err != nil {
+				return err
+			}
+			r.html[key] = t
+		default:
+			return fmt.Errorf("unexpected template file %s", file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %v", err)
+	}
+
+	for key := range r.text {
+		if r.html[key] == nil {
+			return nil, fmt.Errorf("template %s has no HTML version", key)
+		}
+	}
+	for key := range r.html {
+		if r.text[key] == nil {
+			return nil, fmt.Errorf("template %s has no text version", key)
+		}
+	}
+	return r, nil
+}
+
+// Render renders template name for locale into an email without recipient. It
+// falls back from a regional locale such as de-AT to its language and then to
+// DefaultLocale, and returns the locale it used.
+func (r *Renderer) Render(name, locale string, data interface{}) (*mail.Email, string, error) {
+	for _, candidate := range candidates(locale) {
+		key := candidate + "/" + name
+		text, ok := r.text[key]
+		if !ok {
+			continue
+		}
+
+		var subject, body, html bytes.Buffer
+		if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+			return nil, "", err
+		}
+		if err := text.Execute(&body, data); err != nil {
+			return nil, "", err
+		}
+		if err := r.html[key].Execute(&html, data); err != nil {
+			return nil, "", err
+		}
+		email := &mail.Email{
+			Subject: strings.TrimSpace(subject.String()),
+			Text:    strings.TrimSpace(body.String()) + "\n",
+			HTML:    html.String(),
+		}
+		return email, candidate, nil
+	}
+	return nil, "", fmt.Errorf("no template %s", name)
+}
+
+// candidates lists the locales to try for locale, most specific first
+func candidates(locale string) []string {
+	var list []string
+	tag := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	for tag != "" {
+		list = append(list, tag)
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return append(list, DefaultLocale)
+}
+
+// formatMoney formats an amount in minor units, e.g. "12.50 USD"
+func formatMoney(minor int64, currency string) string {
+	if !money.IsValid(currency) {
+		return fmt.Sprintf("%d %s", minor, currency)
+	}
+	return money.Money{Minor: minor, Currency: currency}.String()
+}
@@ -0,0 +1,128 @@
+// Package documents renders the PDF documents of an order: invoices for finance
+// and the customer, packing slips for the warehouse.
+//
+// Documents are plain A4 pages set in the standard Helvetica fonts, written by a
+// small PDF writer in this package so no fonts or libraries need to be shipped.
+// Text is encoded as WinAnsi (Latin-1 plus the euro sign); other characters are
+// printed as question marks.
+package documents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Seller is the business issuing the invoices, read from a local JSON file
+type Seller struct {
+	Name    string   `json:"name"`
+	Address []string `json:"address"` // printed line by line
+	TaxID   string   `json:"tax_id,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Notes   []string `json:"notes,omitempty"` // printed at the end of every invoice, e.g. bank details
+}
+
+// LoadSeller reads and validates a seller file
+func LoadSeller(path string) (*Seller, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seller details: %v", err)
+	}
+
+	var seller Seller
+	if err := json.Unmarshal(data, &seller); err != nil {
+		return nil, fmt.Errorf("failed to parse seller details %s: %v", path, err)
+	}
+	if strings.TrimSpace(seller.Name) == "" {
+		return nil, fmt.Errorf("invalid seller details %s: name required", path)
+	}
+	return &seller, nil
+}
+
+// Address is a postal address as stored on orders
+type Address struct {
+	Line1      string
+	Line2      string
+	City       string
+	Region     string
+	PostalCode string
+	Country    string // ISO 3166-1 alpha-2
+}
+
+// lines formats the address for printing, skipping empty parts
+func (a Address) lines() []string {
+	var lines []string
+	for _, line := range []string{a.Line1, a.Line2, joinNonEmpty(" ", a.PostalCode, a.City), joinNonEmpty(" ", a.Region, a.Country)} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, sep)
+}
+
+// formatDate prints a unix timestamp as a UTC date
+func formatDate(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format("2006-01-02")
+}
+
+// Page layout, in points
+const (
+	margin     = 50.0
+	contentTop = 60.0
+	footerY    = pageHeight - 30
+	bodyBottom = pageHeight - 60 // content stops here, the rest is the footer's
+)
+
+// layout flows content down the pages of a pdf, starting a new page when the
+// current one is full
+type layout struct {
+	*pdf
+	y      float64 // baseline of the next line
+	footer string  // printed at the bottom of every page, with the page number
+	header func()  // redraws e.g. a table header at the top of continuation pages
+}
+
+func newLayout(title, footer string) *layout {
+	l := &layout{pdf: newPDF(title), footer: footer}
+	l.addPage()
+	l.y = contentTop
+	return l
+}
+
+// need starts a new page unless height more points fit on the current one
+func (l *layout) need(height float64) {
+	if l.y+height <= bodyBottom {
+		return
+	}
+	l.addPage()
+	l.y = contentTop
+	if l.header != nil {
+		l.header()
+	}
+}
+
+// bytes numbers the pages and returns the document
+func (l *layout) bytes() []byte {
+	for i := range l.pages {
+		l.selectPage(i)
+		l.line(margin, footerY-12, pageWidth-margin, footerY-12, 0.5)
+		l.text(margin, footerY, regular, 8, l.footer)
+		l.textRight(pageWidth-margin, footerY, regular, 8, fmt.Sprintf("Page %d of %d", i+1, len(l.pages)))
+	}
+	return l.pdf.bytes()
+}
@@ -0,0 +1,198 @@
+package documents
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"shared/money"
+)
+
+// Invoice is everything printed on the invoice of an order. Amounts are in minor
+// units of Currency.
+type Invoice struct {
+	Number     string
+	IssuedAt   int64 // unix seconds
+	OrderID    string
+	OrderDate  int64 // unix seconds
+	Seller     Seller
+	BuyerEmail string
+	BillTo     Address
+
+	Currency         string
+	Lines            []InvoiceLine
+	Subtotal         int64
+	Discount         int64
+	DiscountCodes    []string
+	ShippingMethod   string
+	Shipping         int64
+	Tax              int64
+	Taxes            []InvoiceTax
+	PricesIncludeTax bool // Tax is part of the line amounts rather than added to them
+	Total            int64
+}
+
+// InvoiceLine is one order line. Total is the line total before Discount.
+type InvoiceLine struct {
+	Description string
+	ProductID   string
+	Quantity    int32
+	UnitPrice   int64
+	Total       int64
+	Discount    int64
+	Tax         int64
+}
+
+// InvoiceTax is the total of one tax at one rate
+type InvoiceTax struct {
+	Name    string
+	Rate    float64 // e.g. 0.19
+	Taxable int64
+	Amount  int64
+}
+
+// Columns of the line table: the description starts at margin, the others are
+// right-aligned at these positions
+const (
+	colQuantity  = 300.0
+	colUnitPrice = 365.0
+	colDiscount  = 425.0
+	colTax       = 485.0
+	colAmount    = pageWidth - margin
+)
+
+// RenderInvoice returns the invoice as a PDF
+func RenderInvoice(inv *Invoice) ([]byte, error) {
+	if !money.IsValid(inv.Currency) {
+		return nil, fmt.Errorf("unsupported currency %q", inv.Currency)
+	}
+	amount := func(minor int64) string { return money.Money{Minor: minor, Currency: inv.Currency}.Decimal() }
+
+	l := newLayout("Invoice "+inv.Number, joinNonEmpty(" · ", inv.Seller.Name, "Invoice "+inv.Number))
+
+	// Seller on the left, invoice details on the right
+	l.text(margin, l.y, bold, 14, inv.Seller.Name)
+	l.textRight(pageWidth-margin, l.y, bold, 20, "INVOICE")
+	sellerY := l.y + 16
+	for _, line := range inv.Seller.Address {
+		l.text(margin, sellerY, regular, 9, line)
+		sellerY += 12
+	}
+	if inv.Seller.TaxID != "" {
+		l.text(margin, sellerY, regular, 9, "Tax ID: "+inv.Seller.TaxID)
+		sellerY += 12
+	}
+	if inv.Seller.Email != "" {
+		l.text(margin, sellerY, regular, 9, inv.Seller.Email)
+		sellerY += 12
+	}
+
+	detailsY := l.y + 24
+	for _, detail := range [][2]string{
+		{"Invoice number", inv.Number},
+		{"Invoice date", formatDate(inv.IssuedAt)},
+		{"Order", inv.OrderID},
+		{"Order date", formatDate(inv.OrderDate)},
+		{"Currency", inv.Currency},
+	} {
+		l.textRight(pageWidth-margin-150, detailsY, regular, 9, detail[0])
+		l.textRight(pageWidth-margin, detailsY, bold, 9, truncate(bold, 9, detail[1], 140))
+		detailsY += 12
+	}
+	l.y = max(sellerY, detailsY) + 18
+
+	// Buyer
+	l.text(margin, l.y, bold, 10, "Bill to")
+	l.y += 14
+	for _, line := range append([]string{inv.BuyerEmail}, inv.BillTo.lines()...) {
+		if line != "" {
+			l.text(margin, l.y, regular, 9, line)
+			l.y += 12
+		}
+	}
+	l.y += 18
+
+	// Line items
+	l.header = func() {
+		l.text(margin, l.y, bold, 9, "Description")
+		l.textRight(colQuantity, l.y, bold, 9, "Qty")
+		l.textRight(colUnitPrice, l.y, bold, 9, "Unit price")
+		l.textRight(colDiscount, l.y, bold, 9, "Discount")
+		l.textRight(colTax, l.y, bold, 9, "Tax")
+		l.textRight(colAmount, l.y, bold, 9, "Amount")
+		l.line(margin, l.y+5, pageWidth-margin, l.y+5, 0.75)
+		l.y += 18
+	}
+	l.need(40)
+	l.header()
+	for _, line := range inv.Lines {
+		l.need(26)
+		l.text(margin, l.y, regular, 9, truncate(regular, 9, line.Description, colQuantity-margin-40))
+		l.textRight(colQuantity, l.y, regular, 9, strconv.Itoa(int(line.Quantity)))
+		l.textRight(colUnitPrice, l.y, regular, 9, amount(line.UnitPrice))
+		if line.Discount != 0 {
+			l.textRight(colDiscount, l.y, regular, 9, amount(-line.Discount))
+		}
+		l.textRight(colTax, l.y, regular, 9, amount(line.Tax))
+		l.textRight(colAmount, l.y, regular, 9, amount(line.Total-line.Discount))
+		l.text(margin, l.y+10, regular, 7, truncate(regular, 7, line.ProductID, colQuantity-margin-40))
+		l.y += 24
+	}
+	l.header = nil
+	l.line(margin, l.y-10, pageWidth-margin, l.y-10, 0.5)
+	l.y += 4
+
+	// Totals
+	total := func(label, value string, f font) {
+		l.need(14)
+		l.textRight(colTax, l.y, f, 9, label)
+		l.textRight(colAmount, l.y, f, 9, value)
+		l.y += 14
+	}
+	total("Subtotal", amount(inv.Subtotal), regular)
+	if inv.Discount != 0 {
+		label := "Discount"
+		if len(inv.DiscountCodes) > 0 {
+			label += " (" + strings.Join(inv.DiscountCodes, ", ") + ")"
+		}
+		total(label, amount(-inv.Discount), regular)
+	}
+	if inv.Shipping != 0 || inv.ShippingMethod != "" {
+		label := "Shipping"
+		if inv.ShippingMethod != "" {
+			label += " (" + inv.ShippingMethod + ")"
+		}
+		total(label, amount(inv.Shipping), regular)
+	}
+	if !inv.PricesIncludeTax {
+		for _, t := range inv.Taxes {
+			total(fmt.Sprintf("%s %s on %s", t.Name, formatRate(t.Rate), amount(t.Taxable)), amount(t.Amount), regular)
+		}
+	}
+	l.need(20)
+	l.line(colTax-120, l.y-8, pageWidth-margin, l.y-8, 0.75)
+	l.y += 4
+	total("Total "+inv.Currency, amount(inv.Total), bold)
+	if inv.PricesIncludeTax {
+		for _, t := range inv.Taxes {
+			total(fmt.Sprintf("Includes %s %s on %s", t.Name, formatRate(t.Rate), amount(t.Taxable)), amount(t.Amount), regular)
+		}
+	}
+
+	if len(inv.Seller.Notes) > 0 {
+		l.y += 18
+		for _, note := range inv.Seller.Notes {
+			l.need(12)
+			l.text(margin, l.y, regular, 9, note)
+			l.y += 12
+		}
+	}
+	return l.bytes(), nil
+}
+
+// formatRate prints a tax rate as a percentage, e.g. 0.0725 as 7.25%
+func formatRate(rate float64) string {
+	pct := strconv.FormatFloat(rate*100, 'f', 2, 64)
+	pct = strings.TrimRight(strings.TrimRight(pct, "0"), ".")
+	return pct + "%"
+}
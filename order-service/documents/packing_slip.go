@@ -0,0 +1,110 @@
+package documents
+
+import "strconv"
+
+// PackingSlip lists what goes into a package, without prices. It covers either
+// one shipment or everything of the order that has not been shipped yet.
+type PackingSlip struct {
+	OrderID        string
+	OrderDate      int64 // unix seconds
+	Seller         Seller
+	CustomerEmail  string
+	ShipTo         Address
+	ShippingMethod string
+	ShipmentID     string // empty for the items still to be shipped
+	Carrier        string
+	TrackingNumber string
+	Items          []PackingItem
+}
+
+// PackingItem is one product to pack
+type PackingItem struct {
+	ProductID   string
+	Description string
+	Ordered     int32 // units on the order
+	Quantity    int32 // units in this package
+}
+
+// Columns of the item table, right-aligned
+const (
+	colProductID = 360.0
+	colOrdered   = 430.0
+	colPack      = 490.0
+	colCheck     = pageWidth - margin
+)
+
+// RenderPackingSlip returns the packing slip as a PDF
+func RenderPackingSlip(slip *PackingSlip) ([]byte, error) {
+	l := newLayout("Packing slip "+slip.OrderID, joinNonEmpty(" · ", slip.Seller.Name, "Order "+slip.OrderID))
+
+	l.text(margin, l.y, bold, 14, slip.Seller.Name)
+	l.textRight(pageWidth-margin, l.y, bold, 20, "PACKING SLIP")
+	returnY := l.y + 16
+	for _, line := range slip.Seller.Address {
+		l.text(margin, returnY, regular, 9, line)
+		returnY += 12
+	}
+
+	detailsY := l.y + 24
+	for _, detail := range [][2]string{
+		{"Order", slip.OrderID},
+		{"Order date", formatDate(slip.OrderDate)},
+		{"Shipping method", slip.ShippingMethod},
+		{"Shipment", slip.ShipmentID},
+		{"Carrier", slip.Carrier},
+		{"Tracking number", slip.TrackingNumber},
+	} {
+		if detail[1] == "" {
+			continue
+		}
+		l.textRight(pageWidth-margin-150, detailsY, regular, 9, detail[0])
+		l.textRight(pageWidth-margin, detailsY, bold, 9, truncate(bold, 9, detail[1], 140))
+		detailsY += 12
+	}
+	l.y = max(returnY, detailsY) + 18
+
+	l.text(margin, l.y, bold, 10, "Ship to")
+	l.y += 14
+	for _, line := range append(slip.ShipTo.lines(), slip.CustomerEmail) {
+		if line != "" {
+			l.text(margin, l.y, regular, 9, line)
+			l.y += 12
+		}
+	}
+	l.y += 18
+
+	l.header = func() {
+		l.text(margin, l.y, bold, 9, "Product")
+		l.textRight(colProductID, l.y, bold, 9, "Product ID")
+		l.textRight(colOrdered, l.y, bold, 9, "Ordered")
+		l.textRight(colPack, l.y, bold, 9, "Pack")
+		l.textRight(colCheck, l.y, bold, 9, "Packed")
+		l.line(margin, l.y+5, pageWidth-margin, l.y+5, 0.75)
+		l.y += 18
+	}
+	l.need(40)
+	l.header()
+	var units int32
+	for _, item := range slip.Items {
+		l.need(20)
+		l.text(margin, l.y, regular, 10, truncate(regular, 10, item.Description, colProductID-margin-130))
+		l.textRight(colProductID, l.y, regular, 8, truncate(regular, 8, item.ProductID, 120))
+		l.textRight(colOrdered, l.y, regular, 10, strconv.Itoa(int(item.Ordered)))
+		l.textRight(colPack, l.y, bold, 10, strconv.Itoa(int(item.Quantity)))
+		// A box to tick off by hand
+		left, top := colCheck-24, l.y-9
+		l.line(left, top, left+11, top, 0.5)
+		l.line(left+11, top, left+11, top+11, 0.5)
+		l.line(left+11, top+11, left, top+11, 0.5)
+		l.line(left, top+11, left, top, 0.5)
+		l.y += 20
+		units += item.Quantity
+	}
+	l.line(margin, l.y-10, pageWidth-margin, l.y-10, 0.5)
+	l.y += 4
+	l.need(14)
+	l.textRight(colOrdered, l.y, bold, 9, "Units")
+	l.textRight(colPack, l.y, bold, 10, strconv.Itoa(int(units)))
+
+	return l.bytes(), nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+	eventspb "shared/proto"
+)
+
+// maxCancelReasonLength caps the free-text reason stored with a cancellation
+const maxCancelReasonLength = 1000
+
+func (s *OrderService) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.OrderResponse, error) {
+	if req.ActorUserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "actor user ID required")
+	}
+	if len(req.Reason) > maxCancelReasonLength {
+		return nil, status.Errorf(codes.InvalidArgument, "reason must be at most %d characters", maxCancelReasonLength)
+	}
+
+	actor := statusActor{UserID: req.ActorUserId, Role: req.ActorRole}
+	order, err := s.cancelOrder(ctx, req.OrderId, req.Reason, actor, func(order *Order) error {
+		if req.ActorRole != "admin" && order.UserID != req.ActorUserId {
+			return status.Error(codes.PermissionDenied, "only the order owner or an admin can cancel this order")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orderToResponse(order)
+}
+
+// cancelOrder cancels an order, publishes order.cancelled and gives its stock back.
+// authorize, if set, is called with the locked order before anything changes.
+func (s *OrderService) cancelOrder(ctx context.Context, orderID, reason string, actor statusActor, authorize func(*Order) error) (*Order, error) {
+	var order Order
+	var saga *OrderSaga
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+		if authorize != nil {
+			if err := authorize(&order); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		saga, err = s.cancelLocked(tx, &order, reason, actor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	s.runRestock(saga)
+	return &order, nil
+}
+
+// cancelLocked cancels an order locked by tx and queues order.cancelled. It returns
+// the restock saga to run with runRestock once tx commits.
+func (s *OrderService) cancelLocked(tx *gorm.DB, order *Order, reason string, actor statusActor) (*OrderSaga, error) {
+	oldStatus := order.Status
+	if !canTransition(oldStatus, StatusCancelled) {
+		return nil, status.Errorf(codes.FailedPrecondition, "order can no longer be cancelled (status %s)", oldStatus)
+	}
+	if err := changeStatus(tx, order, StatusCancelled, actor, reason); err != nil {
+		return nil, err
+	}
+
+	order.CancelReason = reason
+	order.CancelledAt = time.Now().Unix()
+	updates := map[string]interface{}{"cancel_reason": order.CancelReason, "cancelled_at": order.CancelledAt}
+	if err := tx.Model(order).Updates(updates).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to cancel order: %v", err)
+	}
+
+	event := &eventspb.OrderCancelledEvent{
+		OrderId:    order.ID,
+		UserId:     order.UserID,
+		OldStatus:  oldStatus,
+		Reason:     order.CancelReason,
+		Currency:   order.Currency,
+		TotalMinor: order.TotalAmount,
+	}
+	if err := enqueueOrderEvent(tx, "order.cancelled", event); err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	saga, err := s.restockOrder(tx, order)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return saga, nil
+}
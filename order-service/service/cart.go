@@ -0,0 +1,530 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+	productpb "order-service/proto/product"
+)
+
+// Limits on the size of a cart
+const (
+	maxCartLines    = 50
+	maxCartQuantity = 999 // per line
+)
+
+// cartFetchConcurrency is how many products of a cart are fetched at once
+const cartFetchConcurrency = 8
+
+// Problems reported on cart items, see the CartItem proto message
+const (
+	cartProblemUnavailable       = "unavailable"
+	cartProblemOutOfStock        = "out_of_stock"
+	cartProblemInsufficientStock = "insufficient_stock"
+	cartProblemPriceChanged      = "price_changed"
+)
+
+// Cart is a shopping cart kept on the server. Guest carts have no UserID; a user has
+// at most one cart.
+type Cart struct {
+	ID       string `gorm:"primaryKey;type:varchar(255)"`
+	UserID   string `gorm:"type:varchar(255);uniqueIndex:idx_carts_user_id,where:user_id <> ''"`
+	Currency string `gorm:"not null;type:varchar(3)"`
+	// The last checkout, so that retrying it with the same key returns its order
+	CheckoutKey     string `gorm:"type:varchar(255)"`
+	CheckoutOrderID string `gorm:"type:varchar(255)"`
+	CreatedAt       int64  `gorm:"autoCreateTime"`
+	UpdatedAt       int64  `gorm:"autoUpdateTime"`
+}
+
+// CartLine is one product in a cart. SeenPrice is the unit price the customer was last
+// shown, used to tell them when it changes; nil until they have seen one.
+type CartLine struct {
+	CartID    string `gorm:"primaryKey;type:varchar(255)"`
+	ProductID string `gorm:"primaryKey;type:varchar(255)"`
+	Quantity  int32  `gorm:"not null"`
+	SeenPrice *int64 `gorm:"column:seen_price_minor"` // In the cart's currency
+	CreatedAt int64  `gorm:"autoCreateTime"`
+}
+
+// CartService implements the CartService gRPC service on top of the order service,
+// which places the orders and owns the database
+type CartService struct {
+	pb.UnimplementedCartServiceServer
+	orders *OrderService
+}
+
+func NewCartService(orders *OrderService) *CartService {
+	return &CartService{orders: orders}
+}
+
+func (s *CartService) GetCart(ctx context.Context, req *pb.GetCartRequest) (*pb.CartResponse, error) {
+	cart, err := s.findCart(ctx, req.Cart, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Currency != "" {
+		rates, err := s.orders.exchangeRates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		currency, err := orderCurrency(req.Currency, rates)
+		if err != nil {
+			return nil, err
+		}
+		if currency != cart.Currency {
+			// Prices seen in the old currency say nothing about the new one
+			err := s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(cart).Update("currency", currency).Error; err != nil {
+					return err
+				}
+				return tx.Model(&CartLine{}).Where("cart_id = ?", cart.ID).Update("seen_price_minor", nil).Error
+			})
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to change cart currency: %v", err)
+			}
+			cart.Currency = currency
+		}
+	}
+
+	return s.cartResponse(ctx, cart, true)
+}
+
+func (s *CartService) AddCartItem(ctx context.Context, req *pb.AddCartItemRequest) (*pb.CartResponse, error) {
+	if req.ProductId == "" {
+		return nil, status.Error(codes.InvalidArgument, "product ID required")
+	}
+	if req.Quantity <= 0 || req.Quantity > maxCartQuantity {
+		return nil, status.Errorf(codes.InvalidArgument, "quantity must be between 1 and %d", maxCartQuantity)
+	}
+
+	cart, err := s.findCart(ctx, req.Cart, true)
+	if err != nil {
+		return nil, err
+	}
+	product, err := s.fetchProduct(ctx, req.ProductId, cart.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil {
+		return nil, status.Error(codes.NotFound, "product not found")
+	}
+
+	err = s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var line CartLine
+		result := tx.Where("cart_id = ? AND product_id = ?", cart.ID, req.ProductId).Limit(1).Find(&line)
+		if result.Error != nil {
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			if err := checkCartLines(tx, cart.ID, 1); err != nil {
+				return err
+			}
+			price := product.Price.GetMinorUnits()
+			line = CartLine{CartID: cart.ID, ProductID: req.ProductId, SeenPrice: &price}
+		}
+		if line.Quantity+req.Quantity > maxCartQuantity {
+			return status.Errorf(codes.InvalidArgument, "at most %d of a product can be in the cart", maxCartQuantity)
+		}
+		line.Quantity += req.Quantity
+		if err := tx.Save(&line).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to add item: %v", err)
+		}
+		return touchCart(tx, cart)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.cartResponse(ctx, cart, true)
+}
+
+func (s *CartService) UpdateCartItem(ctx context.Context, req *pb.UpdateCartItemRequest) (*pb.CartResponse, error) {
+	if req.Quantity < 0 || req.Quantity > maxCartQuantity {
+		return nil, status.Errorf(codes.InvalidArgument, "quantity must be between 0 and %d", maxCartQuantity)
+	}
+	if req.Quantity == 0 {
+		return s.RemoveCartItem(ctx, &pb.RemoveCartItemRequest{Cart: req.Cart, ProductId: req.ProductId})
+	}
+
+	cart, err := s.findCart(ctx, req.Cart, false)
+	if err != nil {
+		return nil, err
+	}
+	err = s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&CartLine{}).Where("cart_id = ? AND product_id = ?", cart.ID, req.ProductId).Update("quantity", req.Quantity)
+		if result.Error != nil {
+			return status.Errorf(codes.Internal, "failed to update item: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return status.Error(codes.NotFound, "product is not in the cart")
+		}
+		return touchCart(tx, cart)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.cartResponse(ctx, cart, true)
+}
+
+func (s *CartService) RemoveCartItem(ctx context.Context, req *pb.RemoveCartItemRequest) (*pb.CartResponse, error) {
+	cart, err := s.findCart(ctx, req.Cart, false)
+	if err != nil {
+		return nil, err
+	}
+	err = s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("cart_id = ? AND product_id = ?", cart.ID, req.ProductId).Delete(&CartLine{})
+		if result.Error != nil {
+			return status.Errorf(codes.Internal, "failed to remove item: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return status.Error(codes.NotFound, "product is not in the cart")
+		}
+		return touchCart(tx, cart)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.cartResponse(ctx, cart, true)
+}
+
+// MergeCarts moves a guest cart into the user's cart, typically when the guest logs in.
+// Quantities are capped at maxCartQuantity. Carts that together hold more than
+// maxCartLines different products are not merged and the guest cart is kept.
+func (s *CartService) MergeCarts(ctx context.Context, req *pb.MergeCartsRequest) (*pb.CartResponse, error) {
+	if req.UserId == "" || req.GuestCartId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user ID and guest cart ID required")
+	}
+
+	cart, err := s.findCart(ctx, &pb.CartRef{UserId: req.UserId}, true)
+	if err != nil {
+		return nil, err
+	}
+	err = s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var guest Cart
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND user_id = ''", req.GuestCartId).First(&guest)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "cart not found")
+			}
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+
+		var guestLines, lines []CartLine
+		if err := tx.Where("cart_id = ?", guest.ID).Order("created_at, product_id").Find(&guestLines).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if err := tx.Where("cart_id = ?", cart.ID).Find(&lines).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		existing := make(map[string]*CartLine, len(lines))
+		for i := range lines {
+			existing[lines[i].ProductID] = &lines[i]
+		}
+		products := len(existing)
+		for _, guestLine := range guestLines {
+			if _, ok := existing[guestLine.ProductID]; !ok {
+				products++
+			}
+		}
+		if products > maxCartLines {
+			return status.Errorf(codes.FailedPrecondition, "the merged cart would hold %d different products, a cart can hold at most %d", products, maxCartLines)
+		}
+
+		for _, guestLine := range guestLines {
+			line, ok := existing[guestLine.ProductID]
+			if !ok {
+				line = &CartLine{CartID: cart.ID, ProductID: guestLine.ProductID}
+				existing[line.ProductID] = line
+			}
+			line.Quantity += guestLine.Quantity
+			if line.Quantity > maxCartQuantity {
+				line.Quantity = maxCartQuantity
+			}
+			// The guest saw the price in the guest cart's currency
+			if guest.Currency == cart.Currency && line.SeenPrice == nil {
+				line.SeenPrice = guestLine.SeenPrice
+			}
+			if err := tx.Save(line).Error; err != nil {
+				return status.Errorf(codes.Internal, "failed to merge carts: %v", err)
+			}
+		}
+
+		if err := tx.Where("cart_id = ?", guest.ID).Delete(&CartLine{}).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to merge carts: %v", err)
+		}
+		if err := tx.Delete(&guest).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to merge carts: %v", err)
+		}
+		return touchCart(tx, cart)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.cartResponse(ctx, cart, true)
+}
+
+// CheckoutCart turns the user's cart into a CreateOrderRequest and empties the cart
+// once the order is placed. Retrying the last checkout with its idempotency key returns
+// its order, whatever the cart holds by then.
+func (s *CartService) CheckoutCart(ctx context.Context, req *pb.CheckoutCartRequest) (*pb.OrderResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user ID required")
+	}
+	cart, err := s.findCart(ctx, &pb.CartRef{UserId: req.UserId}, true)
+	if err != nil {
+		return nil, err
+	}
+	// The retry of a checkout that went through
+	if req.IdempotencyKey != "" && req.IdempotencyKey == cart.CheckoutKey {
+		return s.orders.GetOrder(ctx, &pb.GetOrderRequest{OrderId: cart.CheckoutOrderID})
+	}
+
+	resp, err := s.cartResponse(ctx, cart, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "cart is empty")
+	}
+
+	var problems []string
+	var items []*pb.OrderItem
+	for _, item := range resp.Items {
+		if item.Problem != "" {
+			problems = append(problems, item.ProductId+": "+item.Problem)
+		}
+		items = append(items, &pb.OrderItem{ProductId: item.ProductId, Quantity: item.Quantity})
+	}
+	if len(problems) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "review the cart before checking out (%s)", strings.Join(problems, ", "))
+	}
+
+	order, err := s.orders.CreateOrder(ctx, &pb.CreateOrderRequest{
+		UserId:          req.UserId,
+		Items:           items,
+		IdempotencyKey:  req.IdempotencyKey,
+		CouponCodes:     req.CouponCodes,
+		ShippingAddress: req.ShippingAddress,
+		ShippingMethod:  req.ShippingMethod,
+		Currency:        cart.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the ordered quantities are taken out, in case the cart changed meanwhile. A
+	// retry that overtook the first request gets its order replayed and must not take
+	// them out a second time.
+	err = s.orders.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", cart.ID).First(cart).Error; err != nil {
+			return err
+		}
+		if req.IdempotencyKey != "" && req.IdempotencyKey == cart.CheckoutKey {
+			return nil
+		}
+		for _, item := range items {
+			err := tx.Model(&CartLine{}).Where("cart_id = ? AND product_id = ?", cart.ID, item.ProductId).
+				Update("quantity", gorm.Expr("quantity - ?", item.Quantity)).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("cart_id = ? AND quantity <= 0", cart.ID).Delete(&CartLine{}).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"checkout_key": req.IdempotencyKey, "checkout_order_id": order.OrderId}
+		return tx.Model(cart).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "order %s was placed but the cart could not be emptied: %v", order.OrderId, err)
+	}
+	return order, nil
+}
+
+// findCart loads the cart ref points to. With create set, a user without a cart gets
+// one, and an empty ref creates a guest cart. Users' carts are always created.
+func (s *CartService) findCart(ctx context.Context, ref *pb.CartRef, create bool) (*Cart, error) {
+	db := s.orders.db.WithContext(ctx)
+	switch {
+	case ref.GetUserId() != "":
+		var cart Cart
+		result := db.Where("user_id = ?", ref.UserId).Limit(1).Find(&cart)
+		if result.Error != nil {
+			return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			return &cart, nil
+		}
+		return s.createCart(ctx, ref.UserId)
+	case ref.GetCartId() != "":
+		var cart Cart
+		result := db.Where("id = ? AND user_id = ''", ref.CartId).First(&cart)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return nil, status.Error(codes.NotFound, "cart not found")
+			}
+			return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		return &cart, nil
+	case create:
+		return s.createCart(ctx, "")
+	}
+	return nil, status.Error(codes.InvalidArgument, "user ID or cart ID required")
+}
+
+// createCart creates an empty cart in the base currency. Two requests creating the
+// same user's cart at once get the same cart.
+func (s *CartService) createCart(ctx context.Context, userID string) (*Cart, error) {
+	rates, err := s.orders.exchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &Cart{ID: generateID(), UserID: userID, Currency: rates.Base}
+	db := s.orders.db.WithContext(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(cart).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create cart: %v", err)
+	}
+	if userID != "" {
+		if err := db.Where("user_id = ?", userID).First(cart).Error; err != nil {
+			return nil, status.Errorf(codes.Internal, "database error: %v", err)
+		}
+	}
+	return cart, nil
+}
+
+// checkCartLines returns an error if adding more lines would make the cart too big
+func checkCartLines(tx *gorm.DB, cartID string, more int) error {
+	var count int64
+	if err := tx.Model(&CartLine{}).Where("cart_id = ?", cartID).Count(&count).Error; err != nil {
+		return status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	if int(count)+more > maxCartLines {
+		return status.Errorf(codes.FailedPrecondition, "a cart can hold at most %d different products", maxCartLines)
+	}
+	return nil
+}
+
+func touchCart(tx *gorm.DB, cart *Cart) error {
+	cart.UpdatedAt = time.Now().Unix()
+	if err := tx.Model(cart).UpdateColumn("updated_at", cart.UpdatedAt).Error; err != nil {
+		return status.Errorf(codes.Internal, "failed to update cart: %v", err)
+	}
+	return nil
+}
+
+// fetchProduct gets a product priced in currency, or nil if it no longer exists
+func (s *CartService) fetchProduct(ctx context.Context, productID, currency string) (*productpb.ProductResponse, error) {
+	product, err := s.orders.productClient.GetProduct(ctx, &productpb.GetProductRequest{ProductId: productID, Currency: currency})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, status.Errorf(codes.Unavailable, "failed to get product %s: %v", productID, err)
+	}
+	return product, nil
+}
+
+// fetchCartProducts gets the products of the lines, at most cartFetchConcurrency at a
+// time, in the order of the lines. A product that no longer exists is nil.
+func (s *CartService) fetchCartProducts(ctx context.Context, lines []CartLine, currency string) ([]*productpb.ProductResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	products := make([]*productpb.ProductResponse, len(lines))
+	errs := make([]error, len(lines))
+	slots := make(chan struct{}, cartFetchConcurrency)
+	var fetches sync.WaitGroup
+	for i := range lines {
+		fetches.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer fetches.Done()
+			defer func() { <-slots }()
+			products[i], errs[i] = s.fetchProduct(ctx, lines[i].ProductID, currency)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	fetches.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+// cartResponse checks every line of the cart against the product's current price and
+// stock. With acknowledge set, the prices are recorded as seen by the customer.
+func (s *CartService) cartResponse(ctx context.Context, cart *Cart, acknowledge bool) (*pb.CartResponse, error) {
+	db := s.orders.db.WithContext(ctx)
+	var lines []CartLine
+	if err := db.Where("cart_id = ?", cart.ID).Order("created_at, product_id").Find(&lines).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	products, err := s.fetchCartProducts(ctx, lines, cart.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.CartResponse{
+		CartId:    cart.ID,
+		UserId:    cart.UserID,
+		Currency:  cart.Currency,
+		Ready:     len(lines) > 0,
+		UpdatedAt: cart.UpdatedAt,
+	}
+	var subtotal int64
+	for i := range lines {
+		line := &lines[i]
+		item := &pb.CartItem{ProductId: line.ProductID, Quantity: line.Quantity}
+		product := products[i]
+
+		switch {
+		case product == nil:
+			item.Problem = cartProblemUnavailable
+		case product.Price.GetCurrency() != cart.Currency:
+			item.ProductName = product.Name
+			item.Problem = cartProblemUnavailable
+		default:
+			price := product.Price.MinorUnits
+			item.ProductName = product.Name
+			item.UnitPrice = moneyToProto(price, cart.Currency)
+			item.LineTotal = moneyToProto(price*int64(line.Quantity), cart.Currency)
+			item.AvailableStock = product.Stock
+			subtotal += price * int64(line.Quantity)
+
+			switch {
+			case product.Stock <= 0:
+				item.Problem = cartProblemOutOfStock
+			case product.Stock < line.Quantity:
+				item.Problem = cartProblemInsufficientStock
+			case line.SeenPrice != nil && *line.SeenPrice != price:
+				item.Problem = cartProblemPriceChanged
+			}
+			if acknowledge && (line.SeenPrice == nil || *line.SeenPrice != price) {
+				if err := db.Model(line).Update("seen_price_minor", price).Error; err != nil {
+					return nil, status.Errorf(codes.Internal, "database error: %v", err)
+				}
+			}
+		}
+		if item.Problem != "" {
+			resp.Ready = false
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	resp.Subtotal = moneyToProto(subtotal, cart.Currency)
+	return resp, nil
+}
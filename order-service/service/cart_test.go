@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+func newCartTestService(t *testing.T) (*CartService, *fakeProducts) {
+	s, products := newCheckoutTestService(t)
+	return NewCartService(s), products
+}
+
+func addToCart(t *testing.T, s *CartService, ref *pb.CartRef, productID string, quantity int32) *pb.CartResponse {
+	cart, err := s.AddCartItem(context.Background(), &pb.AddCartItemRequest{Cart: ref, ProductId: productID, Quantity: quantity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cart
+}
+
+// cartQuantities returns the quantity of every product in the user's cart
+func cartQuantities(t *testing.T, s *CartService, userID string) map[string]int32 {
+	cart, err := s.GetCart(context.Background(), &pb.GetCartRequest{Cart: &pb.CartRef{UserId: userID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quantities := make(map[string]int32)
+	for _, item := range cart.Items {
+		quantities[item.ProductId] = item.Quantity
+	}
+	return quantities
+}
+
+func TestMergeCartsCapsQuantities(t *testing.T) {
+	s, products := newCartTestService(t)
+	products.add("p1", 5000, 100)
+	products.add("p2", 5000, 200)
+	user := &pb.CartRef{UserId: "u1"}
+	addToCart(t, s, user, "p1", 600)
+	guest := addToCart(t, s, nil, "p1", 600)
+	addToCart(t, s, &pb.CartRef{CartId: guest.CartId}, "p2", 2)
+
+	if _, err := s.MergeCarts(context.Background(), &pb.MergeCartsRequest{UserId: "u1", GuestCartId: guest.CartId}); err != nil {
+		t.Fatal(err)
+	}
+	got := cartQuantities(t, s, "u1")
+	if got["p1"] != maxCartQuantity || got["p2"] != 2 || len(got) != 2 {
+		t.Errorf("merged cart %v, want %d of p1 and 2 of p2", got, maxCartQuantity)
+	}
+	if _, err := s.GetCart(context.Background(), &pb.GetCartRequest{Cart: &pb.CartRef{CartId: guest.CartId}}); status.Code(err) != codes.NotFound {
+		t.Errorf("guest cart after the merge: error %v, want NotFound", err)
+	}
+}
+
+func TestMergeCartsRefusesTooManyLines(t *testing.T) {
+	s, products := newCartTestService(t)
+	user := &pb.CartRef{UserId: "u1"}
+	var guest *pb.CartResponse
+	for i := 0; i < maxCartLines; i++ {
+		userProduct, guestProduct := fmt.Sprintf("u%d", i), fmt.Sprintf("g%d", i)
+		products.add(userProduct, 10, 100)
+		products.add(guestProduct, 10, 100)
+		addToCart(t, s, user, userProduct, 1)
+		if guest == nil {
+			guest = addToCart(t, s, nil, guestProduct, 1)
+		} else {
+			addToCart(t, s, &pb.CartRef{CartId: guest.CartId}, guestProduct, 1)
+		}
+	}
+
+	_, err := s.MergeCarts(context.Background(), &pb.MergeCartsRequest{UserId: "u1", GuestCartId: guest.CartId})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("merging %d products: error %v, want FailedPrecondition", 2*maxCartLines, err)
+	}
+	if got := cartQuantities(t, s, "u1"); len(got) != maxCartLines {
+		t.Errorf("user cart holds %d products, want %d", len(got), maxCartLines)
+	}
+	kept, err := s.GetCart(context.Background(), &pb.GetCartRequest{Cart: &pb.CartRef{CartId: guest.CartId}})
+	if err != nil {
+		t.Fatalf("guest cart was not kept: %v", err)
+	}
+	if len(kept.Items) != maxCartLines {
+		t.Errorf("guest cart holds %d products, want %d", len(kept.Items), maxCartLines)
+	}
+}
+
+func TestCheckoutCart(t *testing.T) {
+	s, products := newCartTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	user := &pb.CartRef{UserId: "u1"}
+	addToCart(t, s, user, "p1", 2)
+	addToCart(t, s, user, "p2", 1)
+
+	checkout := &pb.CheckoutCartRequest{UserId: "u1", IdempotencyKey: "k1"}
+	order, err := s.CheckoutCart(context.Background(), checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.TotalAmount.MinorUnits != 4000 || len(order.Items) != 2 {
+		t.Errorf("order of %d items for %d, want 2 items for 4000", len(order.Items), order.TotalAmount.MinorUnits)
+	}
+	if got := cartQuantities(t, s, "u1"); len(got) != 0 {
+		t.Errorf("cart after checkout holds %v, want nothing", got)
+	}
+
+	// The retry returns the same order, even once the cart is filled again, and
+	// leaves the new cart and the stock alone
+	addToCart(t, s, user, "p1", 3)
+	retried, err := s.CheckoutCart(context.Background(), checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.OrderId != order.OrderId {
+		t.Errorf("retry placed order %s, want %s", retried.OrderId, order.OrderId)
+	}
+	if got := cartQuantities(t, s, "u1"); got["p1"] != 3 || len(got) != 1 {
+		t.Errorf("cart after the retry holds %v, want 3 of p1", got)
+	}
+	if products.stockOf("p1") != 8 || products.stockOf("p2") != 9 {
+		t.Errorf("stock %d of p1 and %d of p2, want 8 and 9", products.stockOf("p1"), products.stockOf("p2"))
+	}
+
+	// A new key orders the new cart
+	next, err := s.CheckoutCart(context.Background(), &pb.CheckoutCartRequest{UserId: "u1", IdempotencyKey: "k2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.OrderId == order.OrderId || next.TotalAmount.MinorUnits != 3000 {
+		t.Errorf("second checkout placed order %s for %d, want a new order for 3000", next.OrderId, next.TotalAmount.MinorUnits)
+	}
+}
+
+func TestCheckoutCartWithProblems(t *testing.T) {
+	s, products := newCartTestService(t)
+	products.add("p1", 10, 1000)
+	user := &pb.CartRef{UserId: "u1"}
+
+	if _, err := s.CheckoutCart(context.Background(), &pb.CheckoutCartRequest{UserId: "u1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("checking out an empty cart: error %v, want FailedPrecondition", err)
+	}
+
+	addToCart(t, s, user, "p1", 2)
+	products.add("p1", 10, 1200)
+	if _, err := s.CheckoutCart(context.Background(), &pb.CheckoutCartRequest{UserId: "u1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("checking out after a price change: error %v, want FailedPrecondition", err)
+	}
+	if got := products.stockOf("p1"); got != 10 {
+		t.Errorf("stock = %d, want 10", got)
+	}
+
+	// Seeing the cart acknowledges the new price
+	cartQuantities(t, s, "u1")
+	order, err := s.CheckoutCart(context.Background(), &pb.CheckoutCartRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.TotalAmount.MinorUnits != 2400 {
+		t.Errorf("order total %d, want 2400", order.TotalAmount.MinorUnits)
+	}
+}
+
+func TestCartFetchesProductsConcurrently(t *testing.T) {
+	s, products := newCartTestService(t)
+	user := &pb.CartRef{UserId: "u1"}
+	var want []string
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("p%02d", i)
+		products.add(id, 10, 100)
+		addToCart(t, s, user, id, 1)
+		want = append(want, id)
+	}
+	// p05 is gone from the catalogue since it was added
+	products.mu.Lock()
+	delete(products.stock, "p05")
+	products.getDelay = 10 * time.Millisecond
+	products.maxGets = 0
+	products.mu.Unlock()
+
+	cart, err := s.GetCart(context.Background(), &pb.GetCartRequest{Cart: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range cart.Items {
+		got = append(got, item.ProductId)
+		wantProblem := ""
+		if item.ProductId == "p05" {
+			wantProblem = cartProblemUnavailable
+		}
+		if item.Problem != wantProblem {
+			t.Errorf("%s has problem %q, want %q", item.ProductId, item.Problem, wantProblem)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("cart lines %v, want %v", got, want)
+	}
+	if cart.Subtotal.MinorUnits != 1900 {
+		t.Errorf("subtotal %d, want 1900", cart.Subtotal.MinorUnits)
+	}
+	if products.maxGets < 2 || products.maxGets > cartFetchConcurrency {
+		t.Errorf("%d products fetched at once, want between 2 and %d", products.maxGets, cartFetchConcurrency)
+	}
+
+	// A product that cannot be fetched is not taken for a missing one
+	products.mu.Lock()
+	products.failGet["p07"] = true
+	products.mu.Unlock()
+	if _, err := s.GetCart(context.Background(), &pb.GetCartRequest{Cart: user}); status.Code(err) != codes.Unavailable {
+		t.Errorf("cart with an unreachable product: error %v, want Unavailable", err)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 
+	"order-service/events"
+	"shared/messaging"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -43,10 +46,17 @@ func InitDB() (*gorm.DB, error) {
 	}
 
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&Order{})
-	if err != nil {
+	if err := migrate(db); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	return db, nil
+}
+
+// migrate creates or updates the tables of every model
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Order{}, &OrderItem{}, &Promotion{}, &OrderDiscount{}, &OrderTax{}, &ReturnRequest{}, &ReturnItem{}, &Shipment{}, &ShipmentItem{}, &OrderSaga{}, &OrderSagaStep{}, &IdempotencyKey{}, &OrderStatusHistory{}, &Cart{}, &CartLine{}, &Invoice{}, &InvoiceSequence{}, &SchedulerLease{}); err != nil {
+		return err
+	}
+	return messaging.MigrateOutbox(db, events.OutboxTable)
 }
\ No newline at end of file
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	eventspb "shared/proto"
+)
+
+// orderExpiryLease names the scheduler lease of the pending order expiry
+const orderExpiryLease = "order-expiry"
+
+// orderExpiryBatch is how many orders the expiry loads at a time; the lease is
+// renewed before every batch
+const orderExpiryBatch = 100
+
+// expiredReason is the cancel reason of expired orders
+const expiredReason = "expired: not paid in time"
+
+// errNotExpired skips an order that was paid or cancelled since it was selected
+var errNotExpired = errors.New("order no longer expired")
+
+// ExpirePendingOrders cancels the orders that have been pending for longer than
+// ttl: their stock is given back and order.cancelled and order.expired are
+// published. Only the replica holding the expiry lease does this, for lease at a
+// time; others return at once. Every order is rechecked under its row lock, so a
+// payment that lands first keeps the order.
+func (s *OrderService) ExpirePendingOrders(ctx context.Context, ttl, lease time.Duration) (int, error) {
+	cutoff := time.Now().Add(-ttl).Unix()
+	expired := 0
+	var after orderCursor
+	for {
+		held, err := s.acquireLease(ctx, orderExpiryLease, lease)
+		if err != nil || !held {
+			return expired, err
+		}
+
+		var batch []Order
+		query := s.db.WithContext(ctx).Select("id", "created_at").
+			Where("status = ? AND created_at < ?", StatusPending, cutoff)
+		if after.ID != "" {
+			query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+		}
+		if err := query.Order("created_at, id").Limit(orderExpiryBatch).Find(&batch).Error; err != nil {
+			return expired, err
+		}
+
+		for _, order := range batch {
+			if ctx.Err() != nil {
+				return expired, ctx.Err()
+			}
+			after = orderCursor{CreatedAt: order.CreatedAt, ID: order.ID}
+			switch err := s.expireOrder(ctx, order.ID, cutoff); {
+			case err == nil:
+				expired++
+			case err != errNotExpired:
+				log.Printf("Failed to expire order %s: %v", order.ID, err)
+			}
+		}
+		if len(batch) < orderExpiryBatch {
+			return expired, nil
+		}
+	}
+}
+
+// expireOrder cancels one order if it is still pending and was created before cutoff
+func (s *OrderService) expireOrder(ctx context.Context, orderID string, cutoff int64) error {
+	var saga *OrderSaga
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+		if order.Status != StatusPending || order.CreatedAt >= cutoff {
+			return errNotExpired
+		}
+
+		var err error
+		if saga, err = s.cancelLocked(tx, &order, expiredReason, systemActor); err != nil {
+			return err
+		}
+
+		event := &eventspb.OrderExpiredEvent{
+			OrderId:    order.ID,
+			UserId:     order.UserID,
+			UserEmail:  order.UserEmail,
+			Currency:   order.Currency,
+			TotalMinor: order.TotalAmount,
+			CreatedAt:  order.CreatedAt,
+			ExpiredAt:  order.CancelledAt,
+		}
+		for _, change := range restockChanges(&order) {
+			event.Items = append(event.Items, &eventspb.EventItem{ProductId: change.ProductID, Quantity: change.QuantityChange})
+		}
+		return enqueueOrderEvent(tx, "order.expired", event)
+	})
+	if err != nil {
+		return err
+	}
+	s.outboxRelay.Wake()
+
+	log.Printf("Expired unpaid order %s", orderID)
+	s.runRestock(saga)
+	return nil
+}
+
+// RunOrderExpiry calls ExpirePendingOrders every interval until ctx is cancelled.
+// The lease outlives a few intervals, so the replica running the expiry keeps it
+// and another takes over soon after it stops.
+func (s *OrderService) RunOrderExpiry(ctx context.Context, ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.releaseLease(orderExpiryLease)
+
+	for {
+		if _, err := s.ExpirePendingOrders(ctx, ttl, 3*interval); err != nil {
+			log.Printf("Order expiry failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"shared/messaging"
+)
+
+// createExpiryTestOrder creates an order of one unit of p1 created age ago
+func createExpiryTestOrder(t *testing.T, s *OrderService, id, orderStatus string, age time.Duration) {
+	order := &Order{
+		ID: id, UserID: "u1", Currency: "USD", TotalAmount: 100, Status: orderStatus,
+		Items:     []OrderItem{{ProductID: "p1", UnitPrice: 100, Quantity: 1, LineTotal: 100}},
+		CreatedAt: time.Now().Add(-age).Unix(),
+	}
+	if err := s.db.Create(order).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func orderStatusOf(t *testing.T, s *OrderService, id string) string {
+	var order Order
+	if err := s.db.First(&order, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return order.Status
+}
+
+func TestExpirePendingOrders(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 0, 100)
+
+	// More than a batch of expired orders, so that the expiry has to page through them
+	for i := 0; i <= orderExpiryBatch; i++ {
+		createExpiryTestOrder(t, s, fmt.Sprintf("old%03d", i), StatusPending, 2*time.Hour)
+	}
+	createExpiryTestOrder(t, s, "recent", StatusPending, time.Minute)
+	createExpiryTestOrder(t, s, "paid", StatusProcessing, 2*time.Hour)
+	createExpiryTestOrder(t, s, "late", StatusPending, 3*time.Hour)
+
+	// The late order is paid after the first batch is selected, before its row is locked
+	paid := false
+	err := s.db.Callback().Query().After("gorm:query").Register("test:pay_late_order", func(db *gorm.DB) {
+		if paid || db.Statement.Table != "orders" || len(db.Statement.Selects) == 0 {
+			return
+		}
+		paid = true
+		if err := s.db.Model(&Order{}).Where("id = ?", "late").Update("status", StatusProcessing).Error; err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.ExpirePendingOrders(context.Background(), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paid {
+		t.Fatal("the late order was never paid")
+	}
+	if expired != orderExpiryBatch+1 {
+		t.Errorf("expired %d orders, want %d", expired, orderExpiryBatch+1)
+	}
+	for id, want := range map[string]string{"old000": StatusCancelled, "old100": StatusCancelled, "recent": StatusPending, "paid": StatusProcessing, "late": StatusProcessing} {
+		if got := orderStatusOf(t, s, id); got != want {
+			t.Errorf("order %s is %s, want %s", id, got, want)
+		}
+	}
+	if got := products.stockOf("p1"); got != orderExpiryBatch+1 {
+		t.Errorf("stock = %d, want %d given back", got, orderExpiryBatch+1)
+	}
+
+	var events int64
+	if err := s.db.Model(&messaging.OutboxEvent{}).Where("routing_key = ?", "order.expired").Count(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if events != orderExpiryBatch+1 {
+		t.Errorf("queued %d order.expired events, want %d", events, orderExpiryBatch+1)
+	}
+}
+
+func TestExpirePendingOrdersWithoutLease(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 0, 100)
+	createExpiryTestOrder(t, s, "old", StatusPending, 2*time.Hour)
+
+	lease := &SchedulerLease{Name: orderExpiryLease, Holder: "other-replica", ExpiresAt: time.Now().Add(time.Minute).UnixMilli()}
+	if err := s.db.Create(lease).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.ExpirePendingOrders(context.Background(), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 0 || orderStatusOf(t, s, "old") != StatusPending {
+		t.Errorf("expired %d orders while another replica holds the lease", expired)
+	}
+}
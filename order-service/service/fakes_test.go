@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+	productpb "order-service/proto/product"
+	userpb "order-service/proto/user"
+	"order-service/shipping"
+	"order-service/tax"
+)
+
+// fakeProducts is an in-memory product service priced in USD. Methods the order
+// service does not call panic through the nil embedded client.
+type fakeProducts struct {
+	productpb.ProductServiceClient
+
+	mu    sync.Mutex
+	stock map[string]int32
+	price map[string]int64
+	// UpdateInventory of these products is refused, for the n-th call if set above 0
+	failInventory map[string]int
+	// UpdateInventory of these products times out after applying the change, for
+	// the n-th call if set above 0
+	timeoutInventory map[string]int
+	calls            map[string]int
+	// GetProduct takes getDelay, and fails as unavailable for these products.
+	// maxGets is the most calls that were in flight at once.
+	getDelay      time.Duration
+	failGet       map[string]bool
+	gets, maxGets int
+}
+
+func newFakeProducts() *fakeProducts {
+	return &fakeProducts{
+		stock:            map[string]int32{},
+		price:            map[string]int64{},
+		failInventory:    map[string]int{},
+		timeoutInventory: map[string]int{},
+		calls:            map[string]int{},
+		failGet:          map[string]bool{},
+	}
+}
+
+// add registers a product with its stock and USD price in cents
+func (f *fakeProducts) add(productID string, stock int32, price int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stock[productID] = stock
+	f.price[productID] = price
+}
+
+func (f *fakeProducts) stockOf(productID string) int32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stock[productID]
+}
+
+func (f *fakeProducts) GetProduct(ctx context.Context, req *productpb.GetProductRequest, opts ...grpc.CallOption) (*productpb.ProductResponse, error) {
+	f.mu.Lock()
+	f.gets++
+	if f.gets > f.maxGets {
+		f.maxGets = f.gets
+	}
+	delay := f.getDelay
+	f.mu.Unlock()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets--
+	if f.failGet[req.ProductId] {
+		return nil, status.Error(codes.Unavailable, "product service unavailable")
+	}
+	stock, ok := f.stock[req.ProductId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "product not found")
+	}
+	return &productpb.ProductResponse{
+		ProductId: req.ProductId,
+		Name:      "Product " + req.ProductId,
+		Stock:     stock,
+		Price:     &pb.Money{MinorUnits: f.price[req.ProductId], Currency: "USD"},
+	}, nil
+}
+
+func (f *fakeProducts) UpdateInventory(ctx context.Context, req *productpb.UpdateInventoryRequest, opts ...grpc.CallOption) (*productpb.ProductResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[req.ProductId]++
+	if n, ok := f.failInventory[req.ProductId]; ok && (n == 0 || n == f.calls[req.ProductId]) {
+		return nil, status.Error(codes.FailedPrecondition, "inventory change refused")
+	}
+	if f.stock[req.ProductId]+req.QuantityChange < 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "insufficient stock for %s", req.ProductId)
+	}
+	f.stock[req.ProductId] += req.QuantityChange
+	if n, ok := f.timeoutInventory[req.ProductId]; ok && (n == 0 || n == f.calls[req.ProductId]) {
+		return nil, status.Error(codes.DeadlineExceeded, "context deadline exceeded")
+	}
+	return &productpb.ProductResponse{ProductId: req.ProductId, Stock: f.stock[req.ProductId]}, nil
+}
+
+func (f *fakeProducts) ListExchangeRates(ctx context.Context, req *productpb.ListExchangeRatesRequest, opts ...grpc.CallOption) (*productpb.ListExchangeRatesResponse, error) {
+	return &productpb.ListExchangeRatesResponse{BaseCurrency: "USD"}, nil
+}
+
+// fakeUsers knows every user, with an email derived from the ID
+type fakeUsers struct {
+	userpb.UserServiceClient
+}
+
+func (fakeUsers) GetUser(ctx context.Context, req *userpb.GetUserRequest, opts ...grpc.CallOption) (*userpb.UserResponse, error) {
+	return &userpb.UserResponse{UserId: req.UserId, Email: fmt.Sprintf("%s@example.com", req.UserId)}, nil
+}
+
+// newCheckoutTestService returns the service of newPaymentTestService with fake user
+// and product services, no tax rules and no shipping methods, so order totals are
+// the sum of their lines less discounts
+func newCheckoutTestService(t *testing.T) (*OrderService, *fakeProducts) {
+	s, _ := newPaymentTestService(t)
+	products := newFakeProducts()
+	s.userClient = fakeUsers{}
+	s.productClient = products
+	s.taxCalculator = tax.NewCalculator(nil)
+	s.shippingCalculator = shipping.NewCalculator(nil)
+	return s, products
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// brokerHealthService is the gRPC health service name that reports the RabbitMQ
+// connection. Orders are still accepted while it is down, as their events wait in
+// the outbox.
+const brokerHealthService = "rabbitmq"
+
+// RunHealthReport sets the brokerHealthService status on srv from the state of the
+// message broker every interval until ctx is cancelled
+func (s *OrderService) RunHealthReport(ctx context.Context, srv *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		brokerHealth := s.messageBroker.Health()
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if brokerHealth.Healthy() {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		if status != last {
+			srv.SetServingStatus(brokerHealthService, status)
+			log.Printf("Message broker %s since %s", brokerHealth.State, brokerHealth.Since.Format(time.RFC3339))
+			last = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+)
+
+// maxIdempotencyKeyLength matches the size of the key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKeyRetention is how long a key can be replayed after its order was created
+const idempotencyKeyRetention = 24 * time.Hour
+
+// idempotencyClaimTimeout is how long a key may stay claimed without a response
+// before it is given up on. By then saga recovery has finished or rolled back any
+// checkout the claim started.
+const idempotencyClaimTimeout = 10 * time.Minute
+
+// IdempotencyKey remembers a CreateOrder call made with an idempotency key. ResponseJSON
+// stays empty while the first call is in progress; OrderID is set once its checkout
+// saga is about to start.
+type IdempotencyKey struct {
+	UserID       string `gorm:"primaryKey;type:varchar(255)"`
+	Key          string `gorm:"primaryKey;type:varchar(255)"`
+	Fingerprint  string `gorm:"not null;type:varchar(64)"`
+	OrderID      string `gorm:"type:varchar(255)"`
+	ResponseJSON string `gorm:"type:text"`
+	CreatedAt    int64  `gorm:"autoCreateTime;index"`
+}
+
+// requestFingerprint hashes everything in the request except the key itself
+func requestFingerprint(req *pb.CreateOrderRequest) (string, error) {
+	clone := proto.Clone(req).(*pb.CreateOrderRequest)
+	clone.IdempotencyKey = ""
+
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(clone)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey reserves the request's key for this call. If the key was already
+// used for the same request, the original response is returned instead.
+func (s *OrderService) claimIdempotencyKey(ctx context.Context, req *pb.CreateOrderRequest) (*pb.OrderResponse, error) {
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fingerprint request: %v", err)
+	}
+
+	row := &IdempotencyKey{
+		UserID:      req.UserId,
+		Key:         req.IdempotencyKey,
+		Fingerprint: fingerprint,
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing IdempotencyKey
+	if err := s.db.WithContext(ctx).Where("user_id = ? AND key = ?", req.UserId, req.IdempotencyKey).First(&existing).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, status.Error(codes.AlreadyExists, "idempotency key was already used with a different request")
+	}
+	if existing.ResponseJSON == "" {
+		// A claim left behind by a crashed call is taken over
+		cutoff := time.Now().Add(-idempotencyClaimTimeout).Unix()
+		result := staleIdempotencyClaims(s.db.WithContext(ctx), cutoff).Model(&IdempotencyKey{}).
+			Where("user_id = ? AND key = ?", req.UserId, req.IdempotencyKey).
+			Updates(map[string]interface{}{"order_id": "", "created_at": time.Now().Unix()})
+		if result.Error != nil {
+			return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Aborted, "a request with this idempotency key is still in progress")
+	}
+
+	var resp pb.OrderResponse
+	if err := protojson.Unmarshal([]byte(existing.ResponseJSON), &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to deserialize stored response: %v", err)
+	}
+	return &resp, nil
+}
+
+// staleIdempotencyClaims narrows db to keys claimed before cutoff that never got a
+// response and have no unfinished checkout saga that could still produce one: the
+// call crashed before its saga started, or the saga was rolled back.
+func staleIdempotencyClaims(db *gorm.DB, cutoff int64) *gorm.DB {
+	return db.Where("response_json = '' AND created_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM order_sagas WHERE order_sagas.order_id = idempotency_keys.order_id AND order_sagas.state IN ?)",
+			[]string{sagaStarted, sagaCompensating})
+}
+
+// markIdempotencyKeyOrder records the order a claimed key is about to create, before
+// its checkout saga starts
+func (s *OrderService) markIdempotencyKeyOrder(ctx context.Context, userID, key, orderID string) error {
+	result := s.db.WithContext(ctx).Model(&IdempotencyKey{}).
+		Where("user_id = ? AND key = ?", userID, key).
+		Update("order_id", orderID)
+	if result.Error != nil {
+		return status.Errorf(codes.Internal, "failed to record order of idempotency key: %v", result.Error)
+	}
+	return nil
+}
+
+// saveIdempotentResponse stores the response for the order's key as part of tx
+func saveIdempotentResponse(tx *gorm.DB, order *Order) error {
+	resp, err := orderToResponse(order)
+	if err != nil {
+		return err
+	}
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to serialize response: %v", err)
+	}
+
+	result := tx.Model(&IdempotencyKey{}).
+		Where("user_id = ? AND key = ?", order.UserID, order.IdempotencyKey).
+		Updates(map[string]interface{}{"order_id": order.ID, "response_json": string(body)})
+	if result.Error != nil {
+		return fmt.Errorf("failed to store idempotent response: %v", result.Error)
+	}
+	return nil
+}
+
+// releaseIdempotencyKey frees a key whose call failed, so the client can retry with it
+func (s *OrderService) releaseIdempotencyKey(userID, key string) {
+	result := s.db.Where("user_id = ? AND key = ? AND response_json = ''", userID, key).Delete(&IdempotencyKey{})
+	if result.Error != nil {
+		log.Printf("Failed to release idempotency key %s for user %s: %v", key, userID, result.Error)
+	}
+}
+
+// RunIdempotencyKeyPurge deletes expired idempotency keys, and claims left behind by
+// crashed calls, every interval until ctx is cancelled
+func (s *OrderService) RunIdempotencyKeyPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cutoff := time.Now().Add(-idempotencyKeyRetention).Unix()
+		result := s.db.WithContext(ctx).Where("created_at < ? AND response_json <> ''", cutoff).Delete(&IdempotencyKey{})
+		if result.Error != nil {
+			log.Printf("Failed to purge idempotency keys: %v", result.Error)
+		}
+
+		claimCutoff := time.Now().Add(-idempotencyClaimTimeout).Unix()
+		result = staleIdempotencyClaims(s.db.WithContext(ctx), claimCutoff).Delete(&IdempotencyKey{})
+		if result.Error != nil {
+			log.Printf("Failed to purge stale idempotency claims: %v", result.Error)
+		} else if result.RowsAffected > 0 {
+			log.Printf("Purged %d idempotency keys claimed by calls that never finished", result.RowsAffected)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
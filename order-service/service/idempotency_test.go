@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+func idempotentOrderRequest(userID, key string, quantity int32) *pb.CreateOrderRequest {
+	return &pb.CreateOrderRequest{
+		UserId:         userID,
+		Items:          []*pb.OrderItem{{ProductId: "p1", Quantity: quantity}},
+		IdempotencyKey: key,
+	}
+}
+
+func TestIdempotentCreateOrder(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	ctx := context.Background()
+
+	first, err := s.CreateOrder(ctx, idempotentOrderRequest("u1", "k1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	retried, err := s.CreateOrder(ctx, idempotentOrderRequest("u1", "k1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.OrderId != first.OrderId || retried.TotalAmount.MinorUnits != first.TotalAmount.MinorUnits {
+		t.Errorf("retry returned order %s for %d, want %s for %d", retried.OrderId, retried.TotalAmount.MinorUnits, first.OrderId, first.TotalAmount.MinorUnits)
+	}
+
+	if _, err := s.CreateOrder(ctx, idempotentOrderRequest("u1", "k1", 3)); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("reusing the key for another request: error %v, want AlreadyExists", err)
+	}
+
+	// Keys are per user
+	other, err := s.CreateOrder(ctx, idempotentOrderRequest("u2", "k1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.OrderId == first.OrderId {
+		t.Error("another user's request with the same key got the first user's order")
+	}
+
+	if got := products.stockOf("p1"); got != 6 {
+		t.Errorf("stock = %d, want 6 after two orders", got)
+	}
+}
+
+func TestIdempotencyKeyClaims(t *testing.T) {
+	ctx := context.Background()
+	claim := func(t *testing.T, s *OrderService, req *pb.CreateOrderRequest, age time.Duration, orderID string) {
+		fingerprint, err := requestFingerprint(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := &IdempotencyKey{UserID: req.UserId, Key: req.IdempotencyKey, Fingerprint: fingerprint, OrderID: orderID, CreatedAt: time.Now().Add(-age).Unix()}
+		if err := s.db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		age      time.Duration
+		saga     string // state of the checkout saga the claim started, none if empty
+		wantCode codes.Code
+	}{
+		{"in progress", time.Minute, "", codes.Aborted},
+		{"in progress with its saga", time.Minute, sagaStarted, codes.Aborted},
+		{"abandoned before its saga", 2 * idempotencyClaimTimeout, "", codes.OK},
+		{"abandoned with its saga still running", 2 * idempotencyClaimTimeout, sagaStarted, codes.Aborted},
+		{"abandoned with its saga compensating", 2 * idempotencyClaimTimeout, sagaCompensating, codes.Aborted},
+		{"abandoned with its saga rolled back", 2 * idempotencyClaimTimeout, sagaCompensated, codes.OK},
+	}
+	for _, tt := range tests {
+		s, products := newCheckoutTestService(t)
+		products.add("p1", 10, 1000)
+		req := idempotentOrderRequest("u1", "k1", 1)
+
+		orderID := ""
+		if tt.saga != "" {
+			orderID = "claimed"
+			saga, err := s.startSaga(s.db, orderID, sagaKindCheckout, &Order{ID: orderID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.setSagaState(s.db, saga, tt.saga); err != nil {
+				t.Fatal(err)
+			}
+		}
+		claim(t, s, req, tt.age, orderID)
+
+		resp, err := s.CreateOrder(ctx, req)
+		if status.Code(err) != tt.wantCode {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantCode)
+			continue
+		}
+		if err != nil {
+			if got := products.stockOf("p1"); got != 10 {
+				t.Errorf("%s: stock = %d, want 10", tt.name, got)
+			}
+			continue
+		}
+
+		// The order taking over the claim is what retries get from now on
+		retried, err := s.CreateOrder(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if retried.OrderId != resp.OrderId {
+			t.Errorf("%s: retry returned order %s, want %s", tt.name, retried.OrderId, resp.OrderId)
+		}
+	}
+}
+
+func TestIdempotencyKeyReleasedOnFailure(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.failInventory["p1"] = 1
+	req := idempotentOrderRequest("u1", "k1", 1)
+
+	if _, err := s.CreateOrder(context.Background(), req); err == nil {
+		t.Fatal("checkout succeeded although its stock could not be taken")
+	}
+	order, err := s.CreateOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("retry after a failed checkout: %v", err)
+	}
+	if order.OrderId == "" || products.stockOf("p1") != 9 {
+		t.Errorf("retry placed order %q with stock %d left, want an order with 9 left", order.OrderId, products.stockOf("p1"))
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SchedulerLease gives one replica the right to run a background job until
+// ExpiresAt. A replica that stops renewing its lease loses it once it expires.
+type SchedulerLease struct {
+	Name      string `gorm:"primaryKey;type:varchar(100)"`
+	Holder    string `gorm:"not null;type:varchar(255)"`
+	ExpiresAt int64  `gorm:"not null"` // unix milliseconds
+	UpdatedAt int64  `gorm:"autoUpdateTime"`
+}
+
+// replicaID identifies this process as a lease holder
+var replicaID = generateID()
+
+// acquireLease takes or renews the lease on name for duration. It reports false
+// while another replica holds an unexpired lease. Taking and renewing are one
+// statement, so two replicas can never both succeed.
+func (s *OrderService) acquireLease(ctx context.Context, name string, duration time.Duration) (bool, error) {
+	now := time.Now()
+	lease := &SchedulerLease{Name: name, Holder: replicaID, ExpiresAt: now.Add(duration).UnixMilli()}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Or(
+			clause.Expr{SQL: "scheduler_leases.holder = ?", Vars: []interface{}{replicaID}},
+			clause.Expr{SQL: "scheduler_leases.expires_at < ?", Vars: []interface{}{now.UnixMilli()}},
+		)}},
+	}).Create(lease)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %v", name, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// releaseLease gives up a lease held by this replica, so another can take over at once
+func (s *OrderService) releaseLease(name string) {
+	result := s.db.Model(&SchedulerLease{}).
+		Where("name = ? AND holder = ?", name, replicaID).
+		Update("expires_at", int64(0))
+	if result.Error != nil {
+		log.Printf("Failed to release lease %s: %v", name, result.Error)
+	}
+}
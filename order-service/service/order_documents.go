@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"order-service/documents"
+	pb "order-service/order-service/proto"
+)
+
+// Document types of GetOrderDocument
+const (
+	DocumentInvoice     = "invoice"
+	DocumentPackingSlip = "packing_slip"
+)
+
+// Invoice is the invoice of an order. It is issued when the order is paid, with the
+// next number of the year; numbers are handed out in the transaction that moves the
+// order to processing, so a rolled back payment leaves no gap.
+type Invoice struct {
+	OrderID  string `gorm:"primaryKey;type:varchar(255)"`
+	Number   string `gorm:"not null;type:varchar(50);uniqueIndex"` // e.g. INV-2024-000042
+	Year     int    `gorm:"not null;uniqueIndex:idx_invoices_year_sequence"`
+	Sequence int    `gorm:"not null;uniqueIndex:idx_invoices_year_sequence"`
+	IssuedAt int64  `gorm:"not null"`
+	// PDF is rendered on the first download and served unchanged afterwards, so
+	// later changes to the seller details do not alter issued invoices
+	PDF       []byte
+	CreatedAt int64 `gorm:"autoCreateTime"`
+}
+
+// InvoiceSequence is the last invoice number issued in a year. Its row is locked
+// while a number is taken.
+type InvoiceSequence struct {
+	Year       int `gorm:"primaryKey;autoIncrement:false"`
+	LastNumber int `gorm:"not null;default:0"`
+}
+
+// issueInvoice gives a locked order the next invoice number of the current year.
+// Orders that already have an invoice keep it.
+func issueInvoice(tx *gorm.DB, order *Order) (*Invoice, error) {
+	if existing, err := findInvoice(tx, order.ID); err != nil || existing != nil {
+		return existing, err
+	}
+
+	now := time.Now().UTC()
+	sequence := InvoiceSequence{Year: now.Year()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create invoice sequence: %v", err)
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("year = ?", sequence.Year).First(&sequence).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to lock invoice sequence: %v", err)
+	}
+	sequence.LastNumber++
+	if err := tx.Model(&sequence).Update("last_number", sequence.LastNumber).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update invoice sequence: %v", err)
+	}
+
+	invoice := &Invoice{
+		OrderID:  order.ID,
+		Number:   fmt.Sprintf("INV-%d-%06d", sequence.Year, sequence.LastNumber),
+		Year:     sequence.Year,
+		Sequence: sequence.LastNumber,
+		IssuedAt: now.Unix(),
+	}
+	if err := tx.Create(invoice).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create invoice: %v", err)
+	}
+	return invoice, nil
+}
+
+// findInvoice returns the invoice of an order, or nil if it has none
+func findInvoice(db *gorm.DB, orderID string) (*Invoice, error) {
+	var invoice Invoice
+	result := db.Where("order_id = ?", orderID).Limit(1).Find(&invoice)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &invoice, nil
+}
+
+// GetOrderDocument renders the invoice or a packing slip of an order as a PDF. The
+// gateway restricts invoices to the order owner and admins, packing slips to admins.
+func (s *OrderService) GetOrderDocument(ctx context.Context, req *pb.GetOrderDocumentRequest) (*pb.OrderDocument, error) {
+	switch req.Type {
+	case DocumentInvoice:
+		return s.invoiceDocument(ctx, req.OrderId)
+	case DocumentPackingSlip:
+		return s.packingSlipDocument(ctx, req.OrderId, req.ShipmentId)
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "invalid document type %q", req.Type)
+}
+
+func (s *OrderService) invoiceDocument(ctx context.Context, orderID string) (*pb.OrderDocument, error) {
+	invoice, err := findInvoice(s.db.WithContext(ctx), orderID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice != nil && len(invoice.PDF) > 0 {
+		return invoiceToProto(invoice), nil
+	}
+
+	// First download: issue the invoice of orders paid before invoices existed and
+	// render it, under the order's lock so concurrent downloads agree
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+
+		invoice, err = findInvoice(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if invoice == nil {
+			switch order.Status {
+			case StatusPending:
+				return status.Error(codes.FailedPrecondition, "the order has not been paid yet, invoices are issued on payment")
+			case StatusCancelled:
+				return status.Error(codes.FailedPrecondition, "the order was cancelled before it was invoiced")
+			}
+			if invoice, err = issueInvoice(tx, &order); err != nil {
+				return err
+			}
+		}
+		if len(invoice.PDF) > 0 {
+			return nil
+		}
+
+		invoice.PDF, err = documents.RenderInvoice(s.invoiceContent(&order, invoice))
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to render invoice %s: %v", invoice.Number, err)
+		}
+		if err := tx.Model(invoice).Update("pdf", invoice.PDF).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to store invoice %s: %v", invoice.Number, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invoiceToProto(invoice), nil
+}
+
+func invoiceToProto(invoice *Invoice) *pb.OrderDocument {
+	return &pb.OrderDocument{
+		OrderId:       invoice.OrderID,
+		Type:          DocumentInvoice,
+		FileName:      invoice.Number + ".pdf",
+		ContentType:   "application/pdf",
+		Content:       invoice.PDF,
+		InvoiceNumber: invoice.Number,
+	}
+}
+
+// invoiceContent lays out an order, with its items, discounts and taxes loaded, as an invoice
+func (s *OrderService) invoiceContent(order *Order, invoice *Invoice) *documents.Invoice {
+	content := &documents.Invoice{
+		Number:           invoice.Number,
+		IssuedAt:         invoice.IssuedAt,
+		OrderID:          order.ID,
+		OrderDate:        order.CreatedAt,
+		Seller:           *s.seller,
+		BuyerEmail:       order.UserEmail,
+		BillTo:           documents.Address(order.ShippingAddress),
+		Currency:         order.Currency,
+		Subtotal:         order.subtotal(),
+		Discount:         order.DiscountAmount,
+		ShippingMethod:   order.ShippingMethod,
+		Shipping:         order.ShippingAmount,
+		Tax:              order.TaxAmount,
+		PricesIncludeTax: order.PricesIncludeTax,
+		Total:            order.TotalAmount,
+	}
+	for _, item := range order.Items {
+		description := item.ProductName
+		if description == "" {
+			description = item.ProductID
+		}
+		content.Lines = append(content.Lines, documents.InvoiceLine{
+			Description: description,
+			ProductID:   item.ProductID,
+			Quantity:    item.Quantity,
+			UnitPrice:   item.UnitPrice,
+			Total:       item.LineTotal,
+			Discount:    item.Discount,
+			Tax:         item.TaxAmount,
+		})
+	}
+	for _, discount := range order.Discounts {
+		if discount.Code != "" {
+			content.DiscountCodes = append(content.DiscountCodes, discount.Code)
+		}
+	}
+	for _, t := range order.Taxes {
+		content.Taxes = append(content.Taxes, documents.InvoiceTax{
+			Name:    t.Name,
+			Rate:    t.Rate,
+			Taxable: t.TaxableAmount,
+			Amount:  t.Amount,
+		})
+	}
+	return content
+}
+
+// packingSlipDocument renders the packing slip of one shipment, or of the items of an
+// order being fulfilled that are not shipped yet if shipmentID is empty
+func (s *OrderService) packingSlipDocument(ctx context.Context, orderID, shipmentID string) (*pb.OrderDocument, error) {
+	var order Order
+	result := withOrderDetails(s.db.WithContext(ctx)).Where("id = ?", orderID).First(&order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	slip := &documents.PackingSlip{
+		OrderID:        order.ID,
+		OrderDate:      order.CreatedAt,
+		Seller:         *s.seller,
+		CustomerEmail:  order.UserEmail,
+		ShipTo:         documents.Address(order.ShippingAddress),
+		ShippingMethod: order.ShippingMethod,
+	}
+	ordered := make(map[string]int32)
+	names := make(map[string]string)
+	for _, item := range order.Items {
+		ordered[item.ProductID] += item.Quantity
+		if names[item.ProductID] == "" {
+			names[item.ProductID] = item.ProductName
+		}
+	}
+	addItem := func(productID string, quantity int32) {
+		description := names[productID]
+		if description == "" {
+			description = productID
+		}
+		slip.Items = append(slip.Items, documents.PackingItem{
+			ProductID:   productID,
+			Description: description,
+			Ordered:     ordered[productID],
+			Quantity:    quantity,
+		})
+	}
+
+	fileName := "packing-slip-" + order.ID + ".pdf"
+	if shipmentID != "" {
+		var shipment Shipment
+		result := s.db.WithContext(ctx).Preload("Items", orderItemsByID).Where("id = ? AND order_id = ?", shipmentID, order.ID).First(&shipment)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return nil, status.Error(codes.NotFound, "shipment not found")
+			}
+			return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		slip.ShipmentID = shipment.ID
+		slip.Carrier = shipment.Carrier
+		slip.TrackingNumber = shipment.TrackingNumber
+		for _, item := range shipment.Items {
+			addItem(item.ProductID, item.Quantity)
+		}
+		fileName = "packing-slip-" + order.ID + "-" + shipment.ID + ".pdf"
+	} else {
+		if order.Status != StatusProcessing && order.Status != StatusPartiallyShipped {
+			return nil, status.Errorf(codes.FailedPrecondition, "cannot pack an order with status %s", order.Status)
+		}
+		remaining, err := unshippedQuantities(s.db.WithContext(ctx), &order)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range order.Items {
+			if remaining[item.ProductID] > 0 {
+				addItem(item.ProductID, remaining[item.ProductID])
+				remaining[item.ProductID] = 0
+			}
+		}
+		if len(slip.Items) == 0 {
+			return nil, status.Error(codes.FailedPrecondition, "every item of the order has already been shipped")
+		}
+	}
+
+	content, err := documents.RenderPackingSlip(slip)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to render packing slip: %v", err)
+	}
+	return &pb.OrderDocument{
+		OrderId:     order.ID,
+		Type:        DocumentPackingSlip,
+		FileName:    fileName,
+		ContentType: "application/pdf",
+		Content:     content,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestInvoiceNumbersRunPerYear(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	year := time.Now().UTC().Year()
+
+	// Last year's invoices have their own sequence
+	if err := s.db.Create(&InvoiceSequence{Year: year - 1, LastNumber: 41}).Error; err != nil {
+		t.Fatal(err)
+	}
+	old := &Invoice{OrderID: "old", Number: fmt.Sprintf("INV-%d-000041", year-1), Year: year - 1, Sequence: 41, IssuedAt: 1}
+	if err := s.db.Create(old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(orderID string) *Invoice {
+		var invoice *Invoice
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			invoice, err = issueInvoice(tx, &Order{ID: orderID})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return invoice
+	}
+
+	first := issue("o1")
+
+	// A rolled back payment does not use up a number
+	rollback := errors.New("payment rolled back")
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := issueInvoice(tx, &Order{ID: "o2"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("rolled back transaction: %v", err)
+	}
+
+	second := issue("o3")
+	again := issue("o1")
+	tests := []struct {
+		invoice *Invoice
+		want    string
+	}{
+		{first, fmt.Sprintf("INV-%d-000001", year)},
+		{second, fmt.Sprintf("INV-%d-000002", year)},
+		{again, fmt.Sprintf("INV-%d-000001", year)},
+	}
+	for _, tt := range tests {
+		if tt.invoice.Number != tt.want || tt.invoice.Year != year {
+			t.Errorf("invoice of %s is %s of %d, want %s", tt.invoice.OrderID, tt.invoice.Number, tt.invoice.Year, tt.want)
+		}
+	}
+
+	if invoice, err := findInvoice(s.db, "o2"); err != nil || invoice != nil {
+		t.Errorf("rolled back order has invoice %v (error %v), want none", invoice, err)
+	}
+	var sequences []InvoiceSequence
+	if err := s.db.Order("year").Find(&sequences).Error; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sequences) != fmt.Sprint([]InvoiceSequence{{year - 1, 41}, {year, 2}}) {
+		t.Errorf("sequences %v, want 41 last year and 2 this year", sequences)
+	}
+}
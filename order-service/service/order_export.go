@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+	"shared/money"
+)
+
+// Export formats of ExportOrders
+const (
+	exportCSV   = "csv"
+	exportJSONL = "jsonl"
+)
+
+// exportChunkSize is roughly how many bytes ExportOrders sends per message
+const exportChunkSize = 64 * 1024
+
+// exportColumns is the header row of CSV exports. Every row is one order line;
+// orders without lines get one row with the line columns empty.
+var exportColumns = []string{
+	"order_id", "created_at", "status", "user_id", "user_email", "currency",
+	"subtotal", "discount", "discount_codes", "shipping_method", "shipping", "tax",
+	"prices_include_tax", "total", "invoice_number",
+	"shipping_country", "shipping_region", "shipping_postal_code", "shipping_city",
+	"line_product_id", "line_product_name", "line_quantity", "line_unit_price",
+	"line_total", "line_discount", "line_tax_category", "line_tax",
+}
+
+// exportedOrder is one order of a JSON Lines export. Amounts are in minor units of Currency.
+type exportedOrder struct {
+	OrderID          string          `json:"order_id"`
+	CreatedAt        string          `json:"created_at"` // RFC 3339, UTC
+	Status           string          `json:"status"`
+	UserID           string          `json:"user_id"`
+	UserEmail        string          `json:"user_email"`
+	Currency         string          `json:"currency"`
+	SubtotalMinor    int64           `json:"subtotal_minor"`
+	DiscountMinor    int64           `json:"discount_minor"`
+	DiscountCodes    []string        `json:"discount_codes"`
+	ShippingMethod   string          `json:"shipping_method"`
+	ShippingMinor    int64           `json:"shipping_minor"`
+	TaxMinor         int64           `json:"tax_minor"`
+	PricesIncludeTax bool            `json:"prices_include_tax"`
+	TotalMinor       int64           `json:"total_minor"`
+	InvoiceNumber    string          `json:"invoice_number"`
+	ShippingAddress  exportedAddress `json:"shipping_address"`
+	Lines            []exportedLine  `json:"lines"`
+}
+
+type exportedAddress struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+type exportedLine struct {
+	ProductID      string `json:"product_id"`
+	ProductName    string `json:"product_name"`
+	Quantity       int32  `json:"quantity"`
+	UnitPriceMinor int64  `json:"unit_price_minor"`
+	LineTotalMinor int64  `json:"line_total_minor"`
+	DiscountMinor  int64  `json:"discount_minor"`
+	TaxCategory    string `json:"tax_category"`
+	TaxMinor       int64  `json:"tax_minor"`
+}
+
+// ExportOrders streams the orders matching the filter, oldest first, as a CSV or
+// JSON Lines file. Orders are read in pages and sent in chunks, so neither side
+// holds the whole export in memory. The gateway restricts it to admins.
+func (s *OrderService) ExportOrders(req *pb.ExportOrdersRequest, stream pb.OrderService_ExportOrdersServer) error {
+	filter := orderFilter{
+		Statuses:    req.Statuses,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+	}
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = exportCSV
+	}
+	if format != exportCSV && format != exportJSONL {
+		return status.Errorf(codes.InvalidArgument, "invalid format %q, use %s or %s", req.Format, exportCSV, exportJSONL)
+	}
+
+	ctx := stream.Context()
+	out := &chunkWriter{stream: stream}
+	csvOut := csv.NewWriter(out)
+	jsonOut := json.NewEncoder(out)
+	if format == exportCSV {
+		csvOut.Write(exportColumns)
+	}
+
+	exported := 0
+	pageToken := ""
+	for {
+		query := filter.apply(s.db.WithContext(ctx).Model(&Order{}))
+		orders, nextToken, err := findOrderPage(query, orderPage{Sort: sortOldest, PageSize: maxPageSize, PageToken: pageToken})
+		if err != nil {
+			return err
+		}
+
+		invoiceNumbers, err := s.invoiceNumbers(ctx, orders)
+		if err != nil {
+			return err
+		}
+		for i := range orders {
+			order := exportOrder(&orders[i], invoiceNumbers[orders[i].ID])
+			if format == exportJSONL {
+				err = jsonOut.Encode(order)
+			} else {
+				err = writeExportRows(csvOut, order)
+			}
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to write order %s: %v", order.OrderID, err)
+			}
+		}
+		exported += len(orders)
+
+		csvOut.Flush()
+		if err := csvOut.Error(); err != nil {
+			return err
+		}
+		if nextToken == "" {
+			break
+		}
+		pageToken = nextToken
+	}
+	if err := out.flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d orders as %s", exported, format)
+	return nil
+}
+
+// invoiceNumbers returns the invoice numbers of the orders that have one, by order ID
+func (s *OrderService) invoiceNumbers(ctx context.Context, orders []Order) (map[string]string, error) {
+	numbers := make(map[string]string)
+	if len(orders) == 0 {
+		return numbers, nil
+	}
+	ids := make([]string, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+	}
+
+	var invoices []Invoice
+	result := s.db.WithContext(ctx).Select("order_id", "number").Where("order_id IN ?", ids).Find(&invoices)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	for _, invoice := range invoices {
+		numbers[invoice.OrderID] = invoice.Number
+	}
+	return numbers, nil
+}
+
+// exportOrder converts an order with its items, discounts and taxes loaded
+func exportOrder(order *Order, invoiceNumber string) *exportedOrder {
+	exported := &exportedOrder{
+		OrderID:          order.ID,
+		CreatedAt:        time.Unix(order.CreatedAt, 0).UTC().Format(time.RFC3339),
+		Status:           order.Status,
+		UserID:           order.UserID,
+		UserEmail:        order.UserEmail,
+		Currency:         order.Currency,
+		SubtotalMinor:    order.subtotal(),
+		DiscountMinor:    order.DiscountAmount,
+		DiscountCodes:    []string{},
+		ShippingMethod:   order.ShippingMethod,
+		ShippingMinor:    order.ShippingAmount,
+		TaxMinor:         order.TaxAmount,
+		PricesIncludeTax: order.PricesIncludeTax,
+		TotalMinor:       order.TotalAmount,
+		InvoiceNumber:    invoiceNumber,
+		ShippingAddress:  exportedAddress(order.ShippingAddress),
+		Lines:            []exportedLine{},
+	}
+	for _, discount := range order.Discounts {
+		if discount.Code != "" {
+			exported.DiscountCodes = append(exported.DiscountCodes, discount.Code)
+		}
+	}
+	for _, item := range order.Items {
+		exported.Lines = append(exported.Lines, exportedLine{
+			ProductID:      item.ProductID,
+			ProductName:    item.ProductName,
+			Quantity:       item.Quantity,
+			UnitPriceMinor: item.UnitPrice,
+			LineTotalMinor: item.LineTotal,
+			DiscountMinor:  item.Discount,
+			TaxCategory:    item.TaxCategory,
+			TaxMinor:       item.TaxAmount,
+		})
+	}
+	return exported
+}
+
+// writeExportRows writes the CSV rows of an order, with amounts as decimals
+func writeExportRows(w *csv.Writer, order *exportedOrder) error {
+	amount := func(minor int64) string {
+		if !money.IsValid(order.Currency) {
+			return strconv.FormatInt(minor, 10)
+		}
+		return money.Money{Minor: minor, Currency: order.Currency}.Decimal()
+	}
+	address := order.ShippingAddress
+	head := []string{
+		order.OrderID, order.CreatedAt, order.Status, csvText(order.UserID), csvText(order.UserEmail), order.Currency,
+		amount(order.SubtotalMinor), amount(order.DiscountMinor), csvText(strings.Join(order.DiscountCodes, " ")),
+		csvText(order.ShippingMethod), amount(order.ShippingMinor), amount(order.TaxMinor),
+		strconv.FormatBool(order.PricesIncludeTax), amount(order.TotalMinor), order.InvoiceNumber,
+		csvText(address.Country), csvText(address.Region), csvText(address.PostalCode), csvText(address.City),
+	}
+	if len(order.Lines) == 0 {
+		return w.Write(append(head, make([]string, len(exportColumns)-len(head))...))
+	}
+	for _, line := range order.Lines {
+		row := append(head[:len(head):len(head)],
+			csvText(line.ProductID), csvText(line.ProductName), strconv.Itoa(int(line.Quantity)), amount(line.UnitPriceMinor),
+			amount(line.LineTotalMinor), amount(line.DiscountMinor), csvText(line.TaxCategory), amount(line.TaxMinor),
+		)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvText keeps spreadsheets from running customer-supplied text as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// chunkWriter sends what is written to it to an export stream, in chunks of about
+// exportChunkSize bytes
+type chunkWriter struct {
+	stream pb.OrderService_ExportOrdersServer
+	buf    []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= exportChunkSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush sends what has been written since the last chunk
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.stream.Send(&pb.ExportChunk{Data: w.buf})
+	w.buf = nil
+	return err
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+// exportStream collects the chunks of an export
+type exportStream struct {
+	grpc.ServerStream
+	chunks [][]byte
+}
+
+func (s *exportStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *exportStream) Send(chunk *pb.ExportChunk) error {
+	s.chunks = append(s.chunks, chunk.Data)
+	return nil
+}
+
+func (s *exportStream) data() []byte {
+	return bytes.Join(s.chunks, nil)
+}
+
+// createExportTestOrders creates n orders of two lines each, a second apart, with
+// every third one cancelled and every other one invoiced
+func createExportTestOrders(t *testing.T, s *OrderService, n int) {
+	var orders []Order
+	var invoices []Invoice
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("o%04d", i)
+		order := Order{
+			ID: id, UserID: "u1", UserEmail: "u1@example.com", Currency: "USD", Status: StatusProcessing,
+			SubtotalAmount: 3050, TotalAmount: 3050, CreatedAt: int64(1700000000 + i),
+			Items: []OrderItem{
+				{ProductID: "p1", ProductName: strings.Repeat("Long product name ", 10), UnitPrice: 1000, Quantity: 1, LineTotal: 1000},
+				{ProductID: "p2", ProductName: "Widget", UnitPrice: 1025, Quantity: 2, LineTotal: 2050},
+			},
+		}
+		if i%3 == 0 {
+			order.Status = StatusCancelled
+		}
+		if i == 0 {
+			order.UserEmail = "=HYPERLINK(\"http://example.com\")"
+		}
+		orders = append(orders, order)
+		if i%2 == 0 {
+			invoices = append(invoices, Invoice{OrderID: id, Number: fmt.Sprintf("INV-2023-%06d", i+1), Year: 2023, Sequence: i + 1, IssuedAt: 1})
+		}
+	}
+	if err := s.db.CreateInBatches(orders, 50).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.CreateInBatches(invoices, 50).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportOrdersCSV(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	const orders = 2*maxPageSize + 50
+	createExportTestOrders(t, s, orders)
+
+	stream := &exportStream{}
+	if err := s.ExportOrders(&pb.ExportOrdersRequest{}, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	// The export is sent as it is written, not in one piece at the end
+	if len(stream.chunks) < 2 {
+		t.Errorf("export sent in %d chunks, want several", len(stream.chunks))
+	}
+	for i, chunk := range stream.chunks[:len(stream.chunks)-1] {
+		if len(chunk) < exportChunkSize {
+			t.Errorf("chunk %d has %d bytes, want at least %d", i, len(chunk), exportChunkSize)
+		}
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(stream.data())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+2*orders {
+		t.Fatalf("export has %d rows, want a header and %d", len(rows), 2*orders)
+	}
+	if strings.Join(rows[0], ",") != strings.Join(exportColumns, ",") {
+		t.Errorf("header %v, want %v", rows[0], exportColumns)
+	}
+	column := make(map[string]int)
+	for i, name := range exportColumns {
+		column[name] = i
+	}
+
+	// Every order once, oldest first, across the pages the export reads
+	for i := 0; i < orders; i++ {
+		for line, row := range rows[1+2*i : 3+2*i] {
+			if want := fmt.Sprintf("o%04d", i); row[column["order_id"]] != want {
+				t.Fatalf("row %d is of order %s, want %s", 1+2*i+line, row[column["order_id"]], want)
+			}
+		}
+	}
+
+	first, second := rows[1], rows[2]
+	wantFirst := map[string]string{
+		"created_at":      "2023-11-14T22:13:20Z",
+		"status":          StatusCancelled,
+		"user_email":      "'=HYPERLINK(\"http://example.com\")",
+		"total":           "30.50",
+		"invoice_number":  "INV-2023-000001",
+		"line_product_id": "p1",
+		"line_unit_price": "10.00",
+	}
+	for name, want := range wantFirst {
+		if got := first[column[name]]; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if second[column["line_product_id"]] != "p2" || second[column["line_quantity"]] != "2" || second[column["line_total"]] != "20.50" {
+		t.Errorf("second line %v", second)
+	}
+	if got := rows[3][column["invoice_number"]]; got != "" {
+		t.Errorf("order without invoice exported with invoice number %q", got)
+	}
+}
+
+func TestExportOrdersJSONL(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	createExportTestOrders(t, s, 30)
+
+	stream := &exportStream{}
+	req := &pb.ExportOrdersRequest{Format: "JSONL", Statuses: []string{StatusCancelled}, CreatedFrom: 1700000003}
+	if err := s.ExportOrders(req, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(stream.data()))
+	for scanner.Scan() {
+		var order exportedOrder
+		if err := json.Unmarshal(scanner.Bytes(), &order); err != nil {
+			t.Fatal(err)
+		}
+		if len(order.Lines) != 2 || order.TotalMinor != 3050 || order.Status != StatusCancelled {
+			t.Errorf("order %s exported as %+v", order.OrderID, order)
+		}
+		ids = append(ids, order.OrderID)
+	}
+	if want := "[o0003 o0006 o0009 o0012 o0015 o0018 o0021 o0024 o0027]"; fmt.Sprint(ids) != want {
+		t.Errorf("exported %v, want %s", ids, want)
+	}
+}
+
+func TestExportOrdersInvalid(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	for _, req := range []*pb.ExportOrdersRequest{
+		{Format: "xlsx"},
+		{Statuses: []string{"lost"}},
+		{CreatedFrom: 200, CreatedTo: 100},
+	} {
+		stream := &exportStream{}
+		if err := s.ExportOrders(req, stream); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("export of %v: error %v, want InvalidArgument", req, err)
+		}
+		if len(stream.chunks) != 0 {
+			t.Errorf("export of %v sent %d chunks", req, len(stream.chunks))
+		}
+	}
+}
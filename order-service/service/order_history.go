@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+)
+
+// OrderStatusHistory is one status change of an order. Rows are only ever inserted.
+type OrderStatusHistory struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrderID     string `gorm:"not null;type:varchar(255);index"`
+	OldStatus   string `gorm:"type:varchar(50)"` // empty when the order was created
+	NewStatus   string `gorm:"not null;type:varchar(50)"`
+	ActorUserID string `gorm:"type:varchar(255)"`
+	ActorRole   string `gorm:"type:varchar(50)"`
+	Reason      string `gorm:"type:text"`
+	CreatedAt   int64  `gorm:"autoCreateTime"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
+// statusActor is who made a status change, recorded in the status history
+type statusActor struct {
+	UserID string
+	Role   string
+}
+
+// systemActor is used for changes made by the service itself, e.g. background jobs
+var systemActor = statusActor{Role: "system"}
+
+// recordStatusChange appends an entry to the order's status history using tx
+func recordStatusChange(tx *gorm.DB, orderID, oldStatus, newStatus string, actor statusActor, reason string) error {
+	entry := &OrderStatusHistory{
+		OrderID:     orderID,
+		OldStatus:   oldStatus,
+		NewStatus:   newStatus,
+		ActorUserID: actor.UserID,
+		ActorRole:   actor.Role,
+		Reason:      reason,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return status.Errorf(codes.Internal, "failed to record status history: %v", err)
+	}
+	return nil
+}
+
+// GetOrderTimeline returns the status history of an order, oldest first. The gateway
+// restricts it to the order owner and admins.
+func (s *OrderService) GetOrderTimeline(ctx context.Context, req *pb.GetOrderTimelineRequest) (*pb.OrderTimelineResponse, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&Order{}).Where("id = ?", req.OrderId).Count(&count).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	if count == 0 {
+		return nil, status.Error(codes.NotFound, "order not found")
+	}
+
+	var entries []OrderStatusHistory
+	result := s.db.WithContext(ctx).Where("order_id = ?", req.OrderId).Order("id").Find(&entries)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	resp := &pb.OrderTimelineResponse{OrderId: req.OrderId}
+	for i := range entries {
+		resp.Changes = append(resp.Changes, statusChangeToProto(&entries[i]))
+	}
+	return resp, nil
+}
+
+func statusChangeToProto(entry *OrderStatusHistory) *pb.OrderStatusChange {
+	return &pb.OrderStatusChange{
+		OldStatus:   entry.OldStatus,
+		NewStatus:   entry.NewStatus,
+		ActorUserId: entry.ActorUserID,
+		ActorRole:   entry.ActorRole,
+		Reason:      entry.Reason,
+		CreatedAt:   entry.CreatedAt,
+	}
+}
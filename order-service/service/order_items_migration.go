@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+	productpb "order-service/proto/product"
+	"shared/money"
+)
+
+// MigrateLegacyOrderItems moves the items of orders created before order_items existed
+// out of Order.ItemsJSON. Those orders never recorded unit prices, so the price is
+// derived from TotalAmount for single-line orders and taken from the product's current
+// price otherwise. The product name is always the current one. It must run after
+// MigrateLegacyAmounts. Orders whose ItemsJSON cannot be read are logged and left as
+// they are for manual repair; every start tries them again.
+func (s *OrderService) MigrateLegacyOrderItems(ctx context.Context) error {
+	var orders []Order
+	result := s.db.WithContext(ctx).Where("items_json IS NOT NULL AND items_json <> ''").Find(&orders)
+	if result.Error != nil {
+		return fmt.Errorf("failed to load legacy orders: %v", result.Error)
+	}
+
+	migrated := 0
+	for i := range orders {
+		var legacyItems []*pb.OrderItem
+		if err := json.Unmarshal([]byte(orders[i].ItemsJSON), &legacyItems); err != nil {
+			log.Printf("Skipping order %s, its legacy items need repair: %v", orders[i].ID, err)
+			continue
+		}
+		if err := s.migrateLegacyOrder(ctx, &orders[i], legacyItems); err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Printf("Migrated items of %d legacy orders to order_items", migrated)
+	}
+	if skipped := len(orders) - migrated; skipped > 0 {
+		log.Printf("Left %d legacy orders with unreadable items_json for manual repair", skipped)
+	}
+	return nil
+}
+
+func (s *OrderService) migrateLegacyOrder(ctx context.Context, order *Order, legacyItems []*pb.OrderItem) error {
+	var items []OrderItem
+	for _, legacy := range legacyItems {
+		item := OrderItem{
+			OrderID:   order.ID,
+			ProductID: legacy.ProductId,
+			Quantity:  legacy.Quantity,
+		}
+
+		product, err := s.productClient.GetProduct(ctx, &productpb.GetProductRequest{ProductId: legacy.ProductId, Currency: order.Currency})
+		if err != nil {
+			log.Printf("Order %s: no product details for %s, leaving name and price empty: %v", order.ID, legacy.ProductId, err)
+		} else {
+			item.ProductName = product.Name
+			if price, err := productPrice(product, order.Currency); err == nil {
+				item.UnitPrice = price
+			}
+		}
+
+		if len(legacyItems) == 1 && legacy.Quantity > 0 {
+			item.UnitPrice = money.MulRat(order.TotalAmount, big.NewRat(1, int64(legacy.Quantity)))
+		}
+		item.LineTotal = item.UnitPrice * int64(item.Quantity)
+		items = append(items, item)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Clearing ItemsJSON first makes the migration safe when several replicas start together
+		result := tx.Model(&Order{}).Where("id = ? AND items_json <> ''", order.ID).UpdateColumn("items_json", "")
+		if result.Error != nil {
+			return fmt.Errorf("failed to clear legacy items of order %s: %v", order.ID, result.Error)
+		}
+		if result.RowsAffected == 0 || len(items) == 0 {
+			return nil
+		}
+
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to create items for order %s: %v", order.ID, err)
+		}
+		return nil
+	})
+}
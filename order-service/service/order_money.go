@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+	productpb "order-service/proto/product"
+	"shared/money"
+)
+
+func moneyToProto(minor int64, currency string) *pb.Money {
+	return &pb.Money{MinorUnits: minor, Currency: currency}
+}
+
+// exchangeRates fetches the current exchange rates from the product service, which
+// owns them
+func (s *OrderService) exchangeRates(ctx context.Context) (*money.Rates, error) {
+	resp, err := s.productClient.ListExchangeRates(ctx, &productpb.ListExchangeRatesRequest{})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to get exchange rates: %v", err)
+	}
+
+	rates := &money.Rates{Base: resp.BaseCurrency, PerBase: make(map[string]*big.Rat)}
+	for _, rate := range resp.Rates {
+		r, err := money.ParseRat(rate.Rate)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "exchange rate for %s: %v", rate.Currency, err)
+		}
+		rates.PerBase[rate.Currency] = r
+	}
+	return rates, nil
+}
+
+// orderCurrency validates the currency an order is placed in. Empty means the base
+// currency. Other currencies need an exchange rate, for shipping and fixed discounts.
+func orderCurrency(requested string, rates *money.Rates) (string, error) {
+	currency := money.Normalize(requested)
+	if currency == "" {
+		return rates.Base, nil
+	}
+	if !money.IsValid(currency) {
+		return "", status.Errorf(codes.InvalidArgument, "unsupported currency %q", requested)
+	}
+	if _, err := rates.Convert(0, rates.Base, currency); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "orders cannot be placed in %s: %v", currency, err)
+	}
+	return currency, nil
+}
+
+// productPrice returns the unit price of a product fetched in currency
+func productPrice(product *productpb.ProductResponse, currency string) (int64, error) {
+	if product.Price == nil || product.Price.Currency != currency {
+		return 0, status.Errorf(codes.FailedPrecondition, "product %s has no price in %s", product.ProductId, currency)
+	}
+	return product.Price.MinorUnits, nil
+}
+
+// legacyAmountColumns maps the decimal columns used before amounts were stored in
+// minor units to the columns that replaced them, per table
+var legacyAmountColumns = []struct {
+	model    interface{}
+	from, to string
+}{
+	{&Order{}, "subtotal_amount", "subtotal_minor"},
+	{&Order{}, "discount_amount", "discount_minor"},
+	{&Order{}, "tax_amount", "tax_minor"},
+	{&Order{}, "shipping_amount", "shipping_minor"},
+	{&Order{}, "total_amount", "total_minor"},
+	{&OrderItem{}, "unit_price", "unit_price_minor"},
+	{&OrderItem{}, "line_total", "line_total_minor"},
+	{&OrderItem{}, "discount", "discount_minor"},
+	{&OrderItem{}, "tax_amount", "tax_minor"},
+	{&OrderDiscount{}, "amount", "amount_minor"},
+	{&OrderTax{}, "taxable_amount", "taxable_minor"},
+	{&OrderTax{}, "amount", "amount_minor"},
+	{&Promotion{}, "min_order_value", "min_order_minor"},
+	{&ReturnRequest{}, "refund_amount", "refund_minor"},
+	{&ReturnItem{}, "unit_price", "unit_price_minor"},
+}
+
+// MigrateLegacyAmounts moves amounts from the decimal columns used before Money to
+// minor units of currency, the only currency orders were placed in then, and drops
+// the old columns. Each column is moved once, so it is safe to run on every start.
+func MigrateLegacyAmounts(db *gorm.DB, currency string) error {
+	scale := math.Pow10(money.Exponent(currency))
+	return db.Transaction(func(tx *gorm.DB) error {
+		migrated := 0
+
+		// Fixed discounts were kept in the value column, along with percentages.
+		// min_order_value goes in the same migration, so its presence marks promotions not moved yet.
+		if tx.Migrator().HasColumn(&Promotion{}, "min_order_value") {
+			if err := tx.Exec("UPDATE promotions SET amount_minor = ROUND(value * ?), value = 0 WHERE type = ?", scale, PromotionFixed).Error; err != nil {
+				return fmt.Errorf("failed to migrate fixed promotions: %v", err)
+			}
+		}
+		// Percentages were kept in the same decimal column, in percent
+		if tx.Migrator().HasColumn(&Promotion{}, "value") {
+			if err := tx.Exec("UPDATE promotions SET percent_bp = ROUND(value * 100) WHERE type = ?", PromotionPercentage).Error; err != nil {
+				return fmt.Errorf("failed to migrate percentage promotions: %v", err)
+			}
+			if err := tx.Migrator().DropColumn(&Promotion{}, "value"); err != nil {
+				return fmt.Errorf("failed to drop promotions.value: %v", err)
+			}
+			migrated++
+		}
+
+		for _, column := range legacyAmountColumns {
+			if !tx.Migrator().HasColumn(column.model, column.from) {
+				continue
+			}
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(column.model); err != nil {
+				return err
+			}
+			table := stmt.Schema.Table
+			sql := fmt.Sprintf("UPDATE %s SET %s = ROUND(%s * ?)", table, column.to, column.from)
+			if err := tx.Exec(sql, scale).Error; err != nil {
+				return fmt.Errorf("failed to migrate %s.%s: %v", table, column.from, err)
+			}
+			if err := tx.Migrator().DropColumn(column.model, column.from); err != nil {
+				return fmt.Errorf("failed to drop %s.%s: %v", table, column.from, err)
+			}
+			migrated++
+		}
+
+		for _, model := range []interface{}{&Order{}, &Promotion{}, &ReturnRequest{}} {
+			if err := tx.Model(model).Where("currency = ''").UpdateColumn("currency", currency).Error; err != nil {
+				return fmt.Errorf("failed to set currency: %v", err)
+			}
+		}
+
+		if migrated > 0 {
+			log.Printf("Migrated %d amount columns to minor units of %s", migrated, currency)
+		}
+		return nil
+	})
+}
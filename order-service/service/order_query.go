@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Page sizes for order listings
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Sort orders accepted by order listings
+const (
+	sortNewest = "newest"
+	sortOldest = "oldest"
+)
+
+// orderCursor is the position after the last order of a page. It is handed to
+// clients as an opaque page token.
+type orderCursor struct {
+	CreatedAt int64  `json:"c"`
+	ID        string `json:"i"`
+	Sort      string `json:"s"`
+}
+
+func encodeOrderCursor(c orderCursor) string {
+	body, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func decodeOrderCursor(token string) (*orderCursor, error) {
+	body, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	var c orderCursor
+	if err := json.Unmarshal(body, &c); err != nil || c.ID == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	return &c, nil
+}
+
+// orderFilter holds the filters shared by every order listing
+type orderFilter struct {
+	Statuses    []string
+	CreatedFrom int64 // inclusive, unix seconds
+	CreatedTo   int64 // exclusive, unix seconds
+}
+
+func (f orderFilter) validate() error {
+	for _, st := range f.Statuses {
+		if !isValidStatus(st) {
+			return status.Errorf(codes.InvalidArgument, "invalid order status %q", st)
+		}
+	}
+	if f.CreatedFrom > 0 && f.CreatedTo > 0 && f.CreatedFrom >= f.CreatedTo {
+		return status.Error(codes.InvalidArgument, "created_from must be before created_to")
+	}
+	return nil
+}
+
+func (f orderFilter) apply(query *gorm.DB) *gorm.DB {
+	if len(f.Statuses) > 0 {
+		query = query.Where("orders.status IN ?", f.Statuses)
+	}
+	if f.CreatedFrom > 0 {
+		query = query.Where("orders.created_at >= ?", f.CreatedFrom)
+	}
+	if f.CreatedTo > 0 {
+		query = query.Where("orders.created_at < ?", f.CreatedTo)
+	}
+	return query
+}
+
+// orderPage describes which page of a listing to load
+type orderPage struct {
+	Sort      string
+	PageSize  int32
+	PageToken string
+}
+
+// normalize fills in defaults and checks the page against its token
+func (p *orderPage) normalize() (*orderCursor, error) {
+	if p.Sort == "" {
+		p.Sort = sortNewest
+	}
+	if p.Sort != sortNewest && p.Sort != sortOldest {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sort %q, use %s or %s", p.Sort, sortNewest, sortOldest)
+	}
+	if p.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	if p.PageSize == 0 {
+		p.PageSize = defaultPageSize
+	}
+	if p.PageSize > maxPageSize {
+		p.PageSize = maxPageSize
+	}
+
+	if p.PageToken == "" {
+		return nil, nil
+	}
+	cursor, err := decodeOrderCursor(p.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Sort != p.Sort {
+		return nil, status.Error(codes.InvalidArgument, "page token was issued for a different sort order")
+	}
+	return cursor, nil
+}
+
+// findOrderPage runs query as a keyset-paginated listing on (created_at, id) and
+// returns the page with its items loaded plus the token of the next page, if any
+func findOrderPage(query *gorm.DB, page orderPage) ([]Order, string, error) {
+	cursor, err := page.normalize()
+	if err != nil {
+		return nil, "", err
+	}
+
+	direction := "DESC"
+	comparison := "<"
+	if page.Sort == sortOldest {
+		direction = "ASC"
+		comparison = ">"
+	}
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("(orders.created_at, orders.id) %s (?, ?)", comparison), cursor.CreatedAt, cursor.ID)
+	}
+
+	var orders []Order
+	result := withOrderDetails(query).
+		Order(fmt.Sprintf("orders.created_at %s, orders.id %s", direction, direction)).
+		Limit(int(page.PageSize) + 1).
+		Find(&orders)
+	if result.Error != nil {
+		return nil, "", status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	// One extra row tells whether there is a next page
+	nextToken := ""
+	if len(orders) > int(page.PageSize) {
+		orders = orders[:page.PageSize]
+		last := orders[len(orders)-1]
+		nextToken = encodeOrderCursor(orderCursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: page.Sort})
+	}
+	return orders, nextToken, nil
+}
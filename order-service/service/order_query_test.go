@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+// listAllOrders pages through the user's orders and returns their IDs in order
+func listAllOrders(t *testing.T, s *OrderService, req *pb.ListOrdersRequest) []string {
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatal("pagination does not end")
+		}
+		resp, err := s.ListOrders(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Orders) > int(req.PageSize) {
+			t.Fatalf("page of %d orders, want at most %d", len(resp.Orders), req.PageSize)
+		}
+		for _, order := range resp.Orders {
+			ids = append(ids, order.OrderId)
+		}
+		if resp.NextPageToken == "" {
+			return ids
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+func TestListOrdersPagesThroughEqualTimes(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+
+	// Seven orders placed within the same second and one before and after them
+	orders := []struct {
+		id        string
+		createdAt int64
+		status    string
+	}{
+		{"early", 100, StatusPending},
+		{"same-g", 200, StatusPending},
+		{"same-c", 200, StatusCancelled},
+		{"same-a", 200, StatusPending},
+		{"same-e", 200, StatusPending},
+		{"same-b", 200, StatusCancelled},
+		{"same-f", 200, StatusPending},
+		{"same-d", 200, StatusPending},
+		{"late", 300, StatusPending},
+	}
+	for _, o := range orders {
+		order := &Order{ID: o.id, UserID: "u1", Currency: "USD", Status: o.status, CreatedAt: o.createdAt}
+		if err := s.db.Create(order).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.db.Create(&Order{ID: "other", UserID: "u2", Currency: "USD", Status: StatusPending, CreatedAt: 200}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sort     string
+		statuses []string
+		want     []string
+	}{
+		{sortNewest, nil, []string{"late", "same-g", "same-f", "same-e", "same-d", "same-c", "same-b", "same-a", "early"}},
+		{sortOldest, nil, []string{"early", "same-a", "same-b", "same-c", "same-d", "same-e", "same-f", "same-g", "late"}},
+		{sortNewest, []string{StatusPending}, []string{"late", "same-g", "same-f", "same-e", "same-d", "same-a", "early"}},
+	}
+	for _, tt := range tests {
+		for _, pageSize := range []int32{1, 2, 3} {
+			got := listAllOrders(t, s, &pb.ListOrdersRequest{UserId: "u1", Sort: tt.sort, Statuses: tt.statuses, PageSize: pageSize})
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s %v in pages of %d: %v, want %v", tt.sort, tt.statuses, pageSize, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestListOrdersPageTokens(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	for i := 0; i < 3; i++ {
+		if err := s.db.Create(&Order{ID: fmt.Sprintf("o%d", i), UserID: "u1", Currency: "USD", Status: StatusPending, CreatedAt: 100}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := s.ListOrders(context.Background(), &pb.ListOrdersRequest{UserId: "u1", PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NextPageToken == "" {
+		t.Fatal("first page has no next page token")
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.ListOrdersRequest
+	}{
+		{"other sort", &pb.ListOrdersRequest{UserId: "u1", PageSize: 1, Sort: sortOldest, PageToken: resp.NextPageToken}},
+		{"garbage", &pb.ListOrdersRequest{UserId: "u1", PageSize: 1, PageToken: "not-a-token"}},
+		{"no order ID", &pb.ListOrdersRequest{UserId: "u1", PageSize: 1, PageToken: encodeOrderCursor(orderCursor{CreatedAt: 100, Sort: sortNewest})}},
+		{"negative page size", &pb.ListOrdersRequest{UserId: "u1", PageSize: -1}},
+		{"unknown sort", &pb.ListOrdersRequest{UserId: "u1", Sort: "cheapest"}},
+	}
+	for _, tt := range tests {
+		if _, err := s.ListOrders(context.Background(), tt.req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: error %v, want InvalidArgument", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+	userpb "order-service/proto/user"
+	"shared/money"
+)
+
+// SearchOrders lists orders across all customers. The gateway restricts it to admins.
+func (s *OrderService) SearchOrders(ctx context.Context, req *pb.SearchOrdersRequest) (*pb.SearchOrdersResponse, error) {
+	filter := orderFilter{
+		Statuses:    req.Statuses,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	// Amounts only compare within a currency, so bounds imply one
+	currency := money.Normalize(req.Currency)
+	for _, bound := range []*pb.Money{req.MinAmount, req.MaxAmount} {
+		if bound == nil {
+			continue
+		}
+		if bound.MinorUnits < 0 {
+			return nil, status.Error(codes.InvalidArgument, "amounts must not be negative")
+		}
+		c := money.Normalize(bound.Currency)
+		if c == "" {
+			return nil, status.Error(codes.InvalidArgument, "amounts need a currency")
+		}
+		if currency != "" && c != currency {
+			return nil, status.Error(codes.InvalidArgument, "amounts must be in the currency searched for")
+		}
+		currency = c
+	}
+	if req.MinAmount != nil && req.MaxAmount != nil && req.MinAmount.MinorUnits > req.MaxAmount.MinorUnits {
+		return nil, status.Error(codes.InvalidArgument, "min_amount must not exceed max_amount")
+	}
+
+	query := filter.apply(s.db.WithContext(ctx).Model(&Order{}))
+	if req.UserId != "" {
+		query = query.Where("orders.user_id = ?", req.UserId)
+	}
+	if req.Email != "" {
+		query = query.Where("orders.user_email ILIKE ?", "%"+escapeLike(req.Email)+"%")
+	}
+	if req.ProductId != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM order_items WHERE order_items.order_id = orders.id AND order_items.product_id = ?)", req.ProductId)
+	}
+	if currency != "" {
+		query = query.Where("orders.currency = ?", currency)
+	}
+	if req.MinAmount != nil {
+		query = query.Where("orders.total_minor >= ?", req.MinAmount.MinorUnits)
+	}
+	if req.MaxAmount != nil {
+		query = query.Where("orders.total_minor <= ?", req.MaxAmount.MinorUnits)
+	}
+
+	// Totals cover the whole filtered set, not just this page
+	var totals []struct {
+		Currency string
+		Count    int64
+		Amount   int64
+	}
+	result := query.Session(&gorm.Session{}).
+		Select("orders.currency AS currency, COUNT(*) AS count, COALESCE(SUM(orders.total_minor), 0) AS amount").
+		Group("orders.currency").
+		Order("orders.currency").
+		Scan(&totals)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	dbOrders, nextToken, err := findOrderPage(query, orderPage{Sort: req.Sort, PageSize: req.PageSize, PageToken: req.PageToken})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.SearchOrdersResponse{NextPageToken: nextToken}
+	for _, total := range totals {
+		resp.TotalCount += total.Count
+		resp.Totals = append(resp.Totals, moneyToProto(total.Amount, total.Currency))
+	}
+	for i := range dbOrders {
+		order, err := orderToResponse(&dbOrders[i])
+		if err != nil {
+			return nil, err
+		}
+		resp.Orders = append(resp.Orders, order)
+	}
+	return resp, nil
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied search term
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// BackfillOrderEmails fills in the customer email of orders placed before it was
+// recorded, so that they can be found by email
+func (s *OrderService) BackfillOrderEmails(ctx context.Context) error {
+	var userIDs []string
+	result := s.db.WithContext(ctx).Model(&Order{}).
+		Where("user_email IS NULL OR user_email = ''").
+		Distinct().
+		Pluck("user_id", &userIDs)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for _, userID := range userIDs {
+		user, err := s.userClient.GetUser(ctx, &userpb.GetUserRequest{UserId: userID})
+		if err != nil {
+			log.Printf("No email for orders of user %s: %v", userID, err)
+			continue
+		}
+		result := s.db.WithContext(ctx).Model(&Order{}).
+			Where("user_id = ? AND (user_email IS NULL OR user_email = '')", userID).
+			UpdateColumn("user_email", user.Email)
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
@@ -4,38 +4,88 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"log"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	pb "order-service/order-service/proto"
 	productpb "order-service/proto/product"
 	userpb "order-service/proto/user"
-	"order-service/messaging"
+	"order-service/documents"
+	"order-service/events"
+	"shared/messaging"
+	eventspb "shared/proto"
+	"order-service/shipping"
+	"order-service/tax"
 	"gorm.io/gorm"
 )
 
 type Order struct {
-	ID          string  `gorm:"primaryKey;type:varchar(255)"`
-	UserID      string  `gorm:"not null;type:varchar(255);index"`
-	ItemsJSON   string  `gorm:"type:text"` // Store items as JSON
-	TotalAmount float64 `gorm:"not null;type:decimal(10,2)"`
-	Status      string  `gorm:"not null;type:varchar(50);default:'pending'"`
-	CreatedAt   int64   `gorm:"autoCreateTime"`
-	UpdatedAt   int64   `gorm:"autoUpdateTime"`
+	ID        string      `gorm:"primaryKey;type:varchar(255)"`
+	UserID    string      `gorm:"not null;type:varchar(255);index"`
+	UserEmail string      `gorm:"type:varchar(255);index"` // Snapshot of the customer's email at purchase time
+	ItemsJSON string      `gorm:"type:text"`               // Legacy JSON items, moved to order_items by MigrateLegacyOrderItems
+	Items     []OrderItem `gorm:"foreignKey:OrderID"`
+	// Every amount of the order is in minor units of Currency
+	Currency string `gorm:"not null;type:varchar(3);default:''"`
+	// SubtotalAmount is the sum of the line totals; it is 0 on orders placed before promotions
+	SubtotalAmount int64           `gorm:"column:subtotal_minor;not null;default:0"`
+	DiscountAmount int64           `gorm:"column:discount_minor;not null;default:0"`
+	Discounts      []OrderDiscount `gorm:"foreignKey:OrderID"`
+	TaxAmount      int64           `gorm:"column:tax_minor;not null;default:0"`
+	// PricesIncludeTax is set when TaxAmount is part of the line totals rather than added to them
+	PricesIncludeTax bool       `gorm:"not null;default:false"`
+	Taxes            []OrderTax `gorm:"foreignKey:OrderID"`
+	ShippingAddress  Address    `gorm:"embedded;embeddedPrefix:shipping_"`
+	ShippingMethod   string     `gorm:"type:varchar(50)"`
+	ShippingAmount   int64      `gorm:"column:shipping_minor;not null;default:0"`
+	TotalAmount      int64      `gorm:"column:total_minor;not null;default:0"`
+	Status           string     `gorm:"not null;type:varchar(50);default:'pending'"`
+	CancelReason     string     `gorm:"type:text"`
+	CancelledAt      int64
+	// Key the order was created with, see IdempotencyKey
+	IdempotencyKey string `gorm:"type:varchar(255)"`
+	CreatedAt      int64  `gorm:"autoCreateTime;index"`
+	UpdatedAt      int64  `gorm:"autoUpdateTime"`
+}
+
+// OrderItem is one line of an order, with the product details captured at purchase
+// time. Amounts are in minor units of the order's currency.
+type OrderItem struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrderID     string `gorm:"not null;type:varchar(255);index"`
+	ProductID   string `gorm:"not null;type:varchar(255);index"`
+	ProductName string `gorm:"type:varchar(255)"`
+	UnitPrice   int64  `gorm:"column:unit_price_minor;not null;default:0"`
+	Quantity    int32  `gorm:"not null"`
+	LineTotal   int64  `gorm:"column:line_total_minor;not null;default:0"`
+	Discount    int64  `gorm:"column:discount_minor;not null;default:0"` // Share of the order's discounts
+	TaxCategory string `gorm:"type:varchar(50)"`
+	TaxAmount   int64  `gorm:"column:tax_minor;not null;default:0"` // Tax on LineTotal - Discount
 }
 
 type OrderService struct {
 	pb.UnimplementedOrderServiceServer
-	db            *gorm.DB
-	userClient    userpb.UserServiceClient
-	productClient productpb.ProductServiceClient
-	messageBroker *messaging.MessageBroker
+	db                 *gorm.DB
+	userClient         userpb.UserServiceClient
+	productClient      productpb.ProductServiceClient
+	messageBroker      messaging.Broker
+	outboxRelay        *messaging.OutboxRelay
+	taxCalculator      *tax.Calculator
+	shippingCalculator *shipping.Calculator
+	seller             *documents.Seller
+	watches            *orderWatches
 }
 
-func NewOrderService(db *gorm.DB, userServiceURL, productServiceURL, rabbitMQURL string) (*OrderService, error) {
+// orderEventsExchange is the topic exchange all order events are published to
+const orderEventsExchange = "order_events"
+
+func NewOrderService(db *gorm.DB, userServiceURL, productServiceURL, rabbitMQURL string, taxCalculator *tax.Calculator, shippingCalculator *shipping.Calculator, seller *documents.Seller) (*OrderService, error) {
     userConn, err := grpc.Dial(userServiceURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
     if err != nil {
         return nil, fmt.Errorf("failed to connect to user service: %v", err)
@@ -47,7 +97,7 @@ func NewOrderService(db *gorm.DB, userServiceURL, productServiceURL, rabbitMQURL
         return nil, fmt.Errorf("failed to connect to product service: %v", err)
     }
 
-	mb, err := messaging.NewMessageBroker(rabbitMQURL)
+	mb, err := messaging.NewMessageBroker(rabbitMQURL, orderEventsExchange)
 	if err != nil {
 		userConn.Close()
 		productConn.Close()
@@ -55,24 +105,68 @@ func NewOrderService(db *gorm.DB, userServiceURL, productServiceURL, rabbitMQURL
 	}
 
 	return &OrderService{
-		db:            db,
-		userClient:    userpb.NewUserServiceClient(userConn),
-		productClient: productpb.NewProductServiceClient(productConn),
-		messageBroker: mb,
+		db:                 db,
+		userClient:         userpb.NewUserServiceClient(userConn),
+		productClient:      productpb.NewProductServiceClient(productConn),
+		messageBroker:      mb,
+		outboxRelay:        messaging.NewOutboxRelay(db, events.OutboxTable, mb),
+		taxCalculator:      taxCalculator,
+		shippingCalculator: shippingCalculator,
+		seller:             seller,
+		watches:            newOrderWatches(),
 	}, nil
 }
 
 func (s *OrderService) CreateOrder(ctx context.Context, req *pb.CreateOrderRequest) (*pb.OrderResponse, error) {
+	if req.IdempotencyKey == "" {
+		return s.createOrder(ctx, req)
+	}
+
+	// A retried request gets the response of the first one
+	replay, err := s.claimIdempotencyKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if replay != nil {
+		return replay, nil
+	}
+
+	resp, err := s.createOrder(ctx, req)
+	if err != nil {
+		s.releaseIdempotencyKey(req.UserId, req.IdempotencyKey)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *OrderService) createOrder(ctx context.Context, req *pb.CreateOrderRequest) (*pb.OrderResponse, error) {
+	shippingAddress, err := addressFromProto(req.ShippingAddress)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify user exists
-	_, err := s.userClient.GetUser(ctx, &userpb.GetUserRequest{UserId: req.UserId})
+	user, err := s.userClient.GetUser(ctx, &userpb.GetUserRequest{UserId: req.UserId})
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %v", err)
 	}
 
-	// Calculate total and verify inventory
-	var totalAmount float64
+	rates, err := s.exchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := orderCurrency(req.Currency, rates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot prices, calculate subtotal and verify inventory
+	var subtotal int64
+	var lines []OrderItem
+	var parcel []shipping.Item
+	var changes []inventoryChange
 	for _, item := range req.Items {
-		product, err := s.productClient.GetProduct(ctx, &productpb.GetProductRequest{ProductId: item.ProductId})
+		product, err := s.productClient.GetProduct(ctx, &productpb.GetProductRequest{ProductId: item.ProductId, Currency: currency})
 		if err != nil {
 			return nil, fmt.Errorf("product not found: %v", err)
 		}
@@ -81,109 +175,255 @@ func (s *OrderService) CreateOrder(ctx context.Context, req *pb.CreateOrderReque
 			return nil, fmt.Errorf("insufficient stock for product %s", item.ProductId)
 		}
 
-		totalAmount += product.Price * float64(item.Quantity)
-
-		// Update inventory
-		_, err = s.productClient.UpdateInventory(ctx, &productpb.UpdateInventoryRequest{
-			ProductId:      item.ProductId,
-			QuantityChange: -item.Quantity,
-		})
+		unitPrice, err := productPrice(product, currency)
 		if err != nil {
-			return nil, fmt.Errorf("failed to update inventory: %v", err)
+			return nil, err
 		}
+		lineTotal := unitPrice * int64(item.Quantity)
+		subtotal += lineTotal
+		lines = append(lines, OrderItem{
+			ProductID:   item.ProductId,
+			ProductName: product.Name,
+			UnitPrice:   unitPrice,
+			Quantity:    item.Quantity,
+			LineTotal:   lineTotal,
+			TaxCategory: product.TaxCategory,
+		})
+		parcel = append(parcel, shippingItem(product, item.Quantity))
+		changes = append(changes, inventoryChange{ProductID: item.ProductId, QuantityChange: -item.Quantity})
 	}
 
-	// Convert items to JSON
-	itemsJSON, err := json.Marshal(req.Items)
+	discounts, err := applyPromotions(s.db.WithContext(ctx), req.UserId, req.CouponCodes, lines, currency, rates)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize items: %v", err)
+		return nil, err
+	}
+	var discountAmount int64
+	for _, discount := range discounts {
+		discountAmount += discount.Amount
 	}
 
 	orderID := generateID()
 	order := &Order{
-		ID:          orderID,
-		UserID:      req.UserId,
-		ItemsJSON:   string(itemsJSON),
-		TotalAmount: totalAmount,
-		Status:      "pending",
+		ID:              orderID,
+		UserID:          req.UserId,
+		UserEmail:       user.Email,
+		Items:           lines,
+		Currency:        currency,
+		SubtotalAmount:  subtotal,
+		DiscountAmount:  discountAmount,
+		Discounts:       discounts,
+		ShippingAddress: shippingAddress,
+		Status:          StatusPending,
+		IdempotencyKey:  req.IdempotencyKey,
+	}
+	if err := s.priceShipping(order, req.ShippingMethod, parcel, rates); err != nil {
+		return nil, err
+	}
+	applyTax(s.taxCalculator, order)
+	order.TotalAmount = subtotal - discountAmount + order.ShippingAmount
+	if !order.PricesIncludeTax {
+		order.TotalAmount += order.TaxAmount
 	}
 
-	if err := s.db.Create(order).Error; err != nil {
-		return nil, fmt.Errorf("failed to create order: %v", err)
+	// Take the stock as a saga so that a failure part way gives it back
+	if req.IdempotencyKey != "" {
+		if err := s.markIdempotencyKeyOrder(ctx, req.UserId, req.IdempotencyKey, orderID); err != nil {
+			return nil, err
+		}
+	}
+	saga, err := s.startSaga(s.db, orderID, sagaKindCheckout, order)
+	if err != nil {
+		return nil, err
 	}
 
-	// Publish order created event
-	event := map[string]interface{}{
-		"order_id":     orderID,
-		"user_id":      req.UserId,
-		"total_amount": totalAmount,
-		"status":       "pending",
+	if err := s.applyInventoryChanges(ctx, saga, changes); err != nil {
+		if cerr := s.compensateSaga(saga, err); cerr != nil {
+			log.Printf("Saga %s left for recovery: %v", saga.ID, cerr)
+		}
+		return nil, err
 	}
-	if err := s.messageBroker.PublishEvent("order_events", "order.created", event); err != nil {
-		// Log error but don't fail the order creation
-		// In production, you might want to retry or use a transaction
-		fmt.Printf("Warning: failed to publish order event: %v\n", err)
+
+	if err := s.completeCheckout(saga, order); err != nil {
+		if cerr := s.compensateSaga(saga, err); cerr != nil {
+			log.Printf("Saga %s left for recovery: %v", saga.ID, cerr)
+		}
+		return nil, err
 	}
 
-	return &pb.OrderResponse{
-		OrderId:     orderID,
-		UserId:      order.UserID,
-		Items:       req.Items,
-		TotalAmount: order.TotalAmount,
-		Status:      order.Status,
-	}, nil
+	s.outboxRelay.Wake()
+
+	return orderToResponse(order)
+}
+
+// orderCreatedEvent builds the order.created event payload
+func orderCreatedEvent(order *Order) *eventspb.OrderCreatedEvent {
+	event := &eventspb.OrderCreatedEvent{
+		OrderId:       order.ID,
+		UserId:        order.UserID,
+		Currency:      order.Currency,
+		TotalMinor:    order.TotalAmount,
+		DiscountMinor: order.DiscountAmount,
+		TaxMinor:      order.TaxAmount,
+		ShippingMinor: order.ShippingAmount,
+		Status:        order.Status,
+		UserEmail:     order.UserEmail,
+	}
+	for _, item := range order.Items {
+		event.Items = append(event.Items, &eventspb.EventItem{
+			ProductId:      item.ProductID,
+			Quantity:       item.Quantity,
+			ProductName:    item.ProductName,
+			UnitPriceMinor: item.UnitPrice,
+			LineTotalMinor: item.LineTotal,
+		})
+	}
+	return event
+}
+
+// enqueueOrderEvent writes an order event to the outbox as part of tx
+func enqueueOrderEvent(tx *gorm.DB, routingKey string, event proto.Message) error {
+	return events.Enqueue(tx, orderEventsExchange, routingKey, event)
+}
+
+// RunOutboxRelay publishes events written to the outbox until ctx is cancelled
+func (s *OrderService) RunOutboxRelay(ctx context.Context) {
+	s.outboxRelay.Run(ctx)
 }
 
 func (s *OrderService) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.OrderResponse, error) {
 	var order Order
-	result := s.db.Where("id = ?", req.OrderId).First(&order)
+	result := withOrderDetails(s.db).Where("id = ?", req.OrderId).First(&order)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("order not found")
+			return nil, status.Error(codes.NotFound, "order not found")
 		}
 		return nil, fmt.Errorf("database error: %v", result.Error)
 	}
 
-	// Deserialize items from JSON
-	var items []*pb.OrderItem
-	if err := json.Unmarshal([]byte(order.ItemsJSON), &items); err != nil {
-		return nil, fmt.Errorf("failed to deserialize items: %v", err)
-	}
-
-	return &pb.OrderResponse{
-		OrderId:     order.ID,
-		UserId:      order.UserID,
-		Items:       items,
-		TotalAmount: order.TotalAmount,
-		Status:      order.Status,
-	}, nil
+	return orderToResponse(&order)
 }
 
 func (s *OrderService) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
-	var dbOrders []Order
-	result := s.db.Where("user_id = ?", req.UserId).Find(&dbOrders)
-	if result.Error != nil {
-		return nil, fmt.Errorf("database error: %v", result.Error)
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user ID required")
+	}
+
+	filter := orderFilter{
+		Statuses:    req.Statuses,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	query := filter.apply(s.db.WithContext(ctx).Where("orders.user_id = ?", req.UserId))
+	dbOrders, nextToken, err := findOrderPage(query, orderPage{Sort: req.Sort, PageSize: req.PageSize, PageToken: req.PageToken})
+	if err != nil {
+		return nil, err
 	}
 
 	var orders []*pb.OrderResponse
-	for _, o := range dbOrders {
-		// Deserialize items from JSON
-		var items []*pb.OrderItem
-		if err := json.Unmarshal([]byte(o.ItemsJSON), &items); err != nil {
-			return nil, fmt.Errorf("failed to deserialize items for order %s: %v", o.ID, err)
+	for i := range dbOrders {
+		resp, err := orderToResponse(&dbOrders[i])
+		if err != nil {
+			return nil, err
 		}
+		orders = append(orders, resp)
+	}
 
-		orders = append(orders, &pb.OrderResponse{
-			OrderId:     o.ID,
-			UserId:      o.UserID,
-			Items:       items,
-			TotalAmount: o.TotalAmount,
-			Status:      o.Status,
+	return &pb.ListOrdersResponse{Orders: orders, NextPageToken: nextToken}, nil
+}
+
+// orderToResponse converts a stored order, with its items loaded, into its protobuf representation
+func orderToResponse(order *Order) (*pb.OrderResponse, error) {
+	var items []*pb.OrderItem
+	for _, item := range order.Items {
+		items = append(items, &pb.OrderItem{
+			ProductId:   item.ProductID,
+			Quantity:    item.Quantity,
+			ProductName: item.ProductName,
+			UnitPrice:   moneyToProto(item.UnitPrice, order.Currency),
+			LineTotal:   moneyToProto(item.LineTotal, order.Currency),
+			Discount:    moneyToProto(item.Discount, order.Currency),
+			TaxCategory: item.TaxCategory,
+			TaxAmount:   moneyToProto(item.TaxAmount, order.Currency),
 		})
 	}
 
-	return &pb.ListOrdersResponse{Orders: orders}, nil
+	var taxes []*pb.OrderTax
+	for _, t := range order.Taxes {
+		taxes = append(taxes, &pb.OrderTax{
+			Name:          t.Name,
+			Rate:          t.Rate,
+			TaxableAmount: moneyToProto(t.TaxableAmount, order.Currency),
+			Amount:        moneyToProto(t.Amount, order.Currency),
+		})
+	}
+
+	var discounts []*pb.OrderDiscount
+	for _, discount := range order.Discounts {
+		discounts = append(discounts, &pb.OrderDiscount{
+			Code:        discount.Code,
+			Description: discount.Description,
+			Amount:      moneyToProto(discount.Amount, order.Currency),
+		})
+	}
+
+	return &pb.OrderResponse{
+		OrderId:          order.ID,
+		UserId:           order.UserID,
+		UserEmail:        order.UserEmail,
+		Items:            items,
+		Currency:         order.Currency,
+		TotalAmount:      moneyToProto(order.TotalAmount, order.Currency),
+		SubtotalAmount:   moneyToProto(order.subtotal(), order.Currency),
+		DiscountAmount:   moneyToProto(order.DiscountAmount, order.Currency),
+		Discounts:        discounts,
+		TaxAmount:        moneyToProto(order.TaxAmount, order.Currency),
+		PricesIncludeTax: order.PricesIncludeTax,
+		Taxes:            taxes,
+		ShippingAddress:  addressToProto(order.ShippingAddress),
+		ShippingMethod:   order.ShippingMethod,
+		ShippingAmount:   moneyToProto(order.ShippingAmount, order.Currency),
+		Status:           order.Status,
+		CancelReason:     order.CancelReason,
+		CreatedAt:        order.CreatedAt,
+		UpdatedAt:        order.UpdatedAt,
+	}, nil
+}
+
+// subtotal is the sum of the line totals. Orders placed before promotions did not
+// store it; their total had the discount taken off and nothing added.
+func (o *Order) subtotal() int64 {
+	if o.SubtotalAmount == 0 {
+		return o.TotalAmount + o.DiscountAmount
+	}
+	return o.SubtotalAmount
+}
+
+// withOrderDetails preloads everything orderToResponse needs besides the order row
+func withOrderDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items", orderItemsByID).Preload("Discounts", orderItemsByID).Preload("Taxes", orderItemsByID)
+}
+
+// orderItemsByID keeps preloaded order items in the order they were created
+func orderItemsByID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+// loadOrderItems loads the items, discounts and taxes of an order fetched without Preload, e.g. under a row lock
+func loadOrderItems(db *gorm.DB, order *Order) error {
+	if err := db.Where("order_id = ?", order.ID).Order("id").Find(&order.Items).Error; err != nil {
+		return fmt.Errorf("failed to load order items: %v", err)
+	}
+	if err := db.Where("order_id = ?", order.ID).Order("id").Find(&order.Discounts).Error; err != nil {
+		return fmt.Errorf("failed to load order discounts: %v", err)
+	}
+	if err := db.Where("order_id = ?", order.ID).Order("id").Find(&order.Taxes).Error; err != nil {
+		return fmt.Errorf("failed to load order taxes: %v", err)
+	}
+	return nil
 }
 
 func generateID() string {
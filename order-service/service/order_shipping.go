@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+	productpb "order-service/proto/product"
+	"order-service/shipping"
+	"shared/money"
+)
+
+// shippingItem describes one order line as part of the parcel
+func shippingItem(product *productpb.ProductResponse, quantity int32) shipping.Item {
+	return shipping.Item{
+		Quantity:    quantity,
+		WeightGrams: product.WeightGrams,
+		LengthCm:    product.LengthCm,
+		WidthCm:     product.WidthCm,
+		HeightCm:    product.HeightCm,
+	}
+}
+
+// priceShipping prices the chosen shipping method, or the default one if method is
+// empty, and stores it on the order. Free shipping thresholds compare the subtotal
+// before discounts, so that coupons never take away free shipping the customer was quoted.
+func (s *OrderService) priceShipping(order *Order, method string, parcel []shipping.Item, rates *money.Rates) error {
+	if method == "" {
+		method = s.shippingCalculator.DefaultMethod()
+		if method == "" {
+			return nil
+		}
+	}
+
+	subtotal := money.Money{Minor: order.SubtotalAmount, Currency: order.Currency}
+	quote, err := s.shippingCalculator.Price(method, order.ShippingAddress.Country, parcel, subtotal, rates)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	order.ShippingMethod = quote.Method
+	order.ShippingAmount = quote.Price.Minor
+	return nil
+}
+
+// GetShippingQuotes prices every shipping method available for the items and address
+func (s *OrderService) GetShippingQuotes(ctx context.Context, req *pb.GetShippingQuotesRequest) (*pb.ShippingQuotesResponse, error) {
+	if len(req.Items) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one item required")
+	}
+	address, err := addressFromProto(req.ShippingAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := s.exchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := orderCurrency(req.Currency, rates)
+	if err != nil {
+		return nil, err
+	}
+
+	subtotal := money.Money{Currency: currency}
+	var parcel []shipping.Item
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "quantity for product %s must be positive", item.ProductId)
+		}
+		product, err := s.productClient.GetProduct(ctx, &productpb.GetProductRequest{ProductId: item.ProductId, Currency: currency})
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "product not found: %v", err)
+		}
+		unitPrice, err := productPrice(product, currency)
+		if err != nil {
+			return nil, err
+		}
+		subtotal.Minor += unitPrice * int64(item.Quantity)
+		parcel = append(parcel, shippingItem(product, item.Quantity))
+	}
+
+	resp := &pb.ShippingQuotesResponse{}
+	for _, quote := range s.shippingCalculator.Quotes(address.Country, parcel, subtotal, rates) {
+		resp.Quotes = append(resp.Quotes, &pb.ShippingQuote{
+			Method:  quote.Method,
+			Name:    quote.Name,
+			Amount:  moneyToProto(quote.Price.Minor, quote.Price.Currency),
+			MinDays: quote.MinDays,
+			MaxDays: quote.MaxDays,
+		})
+	}
+	return resp, nil
+}
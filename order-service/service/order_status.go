@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+	eventspb "shared/proto"
+)
+
+// Order statuses stored in Order.Status
+const (
+	StatusPending          = "pending"
+	StatusProcessing       = "processing"
+	StatusPartiallyShipped = "partially_shipped" // some, not all, items are in shipments
+	StatusShipped          = "shipped"
+	StatusDelivered        = "delivered"
+	StatusCancelled        = "cancelled"
+)
+
+// orderTransitions lists, for every status, the statuses an order may move to next.
+// Statuses without an entry (delivered, cancelled) are terminal.
+var orderTransitions = map[string][]string{
+	StatusPending:          {StatusProcessing, StatusCancelled},
+	StatusProcessing:       {StatusPartiallyShipped, StatusShipped, StatusCancelled},
+	StatusPartiallyShipped: {StatusShipped},
+	StatusShipped:          {StatusDelivered},
+}
+
+func isValidStatus(s string) bool {
+	switch s {
+	case StatusPending, StatusProcessing, StatusPartiallyShipped, StatusShipped, StatusDelivered, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// isTerminalStatus reports whether an order in status s can no longer change status
+func isTerminalStatus(s string) bool {
+	return len(orderTransitions[s]) == 0
+}
+
+// canTransition reports whether an order in status from may move to status to
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *OrderService) UpdateOrderStatus(ctx context.Context, req *pb.UpdateOrderStatusRequest) (*pb.OrderResponse, error) {
+	if !isValidStatus(req.Status) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid order status %q", req.Status)
+	}
+
+	// The shipping statuses follow from the shipments, see CreateShipment and
+	// MarkShipmentDelivered
+	if req.Status == StatusPartiallyShipped || req.Status == StatusShipped {
+		return nil, status.Errorf(codes.FailedPrecondition, "an order becomes %s by creating shipments", req.Status)
+	}
+	if req.Status == StatusDelivered {
+		return nil, status.Error(codes.FailedPrecondition, "an order becomes delivered when all of its shipments are marked delivered")
+	}
+
+	// Orders are paid, and invoiced, through payment-service, see markOrderPaid
+	if req.Status == StatusProcessing {
+		return nil, status.Error(codes.FailedPrecondition, "an order becomes processing when its payment succeeds")
+	}
+
+	actor := statusActor{UserID: req.ActorUserId, Role: req.ActorRole}
+
+	// Cancelling gives the stock back, whoever does it
+	if req.Status == StatusCancelled {
+		order, err := s.cancelOrder(ctx, req.OrderId, req.Reason, actor, nil)
+		if err != nil {
+			return nil, err
+		}
+		return orderToResponse(order)
+	}
+
+	var order Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, req.OrderId, &order); err != nil {
+			return err
+		}
+		return changeStatus(tx, &order, req.Status, actor, req.Reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	return orderToResponse(&order)
+}
+
+// lockOrder loads an order and its items, holding a row lock on the order until tx ends
+func lockOrder(tx *gorm.DB, orderID string, order *Order) error {
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", orderID).First(order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return status.Error(codes.NotFound, "order not found")
+		}
+		return status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	if err := loadOrderItems(tx, order); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
+// changeStatus moves a locked order to newStatus if the state machine allows it,
+// records the change in the status history and queues the order.status_changed
+// event in the same transaction.
+func changeStatus(tx *gorm.DB, order *Order, newStatus string, actor statusActor, reason string) error {
+	oldStatus := order.Status
+	if !canTransition(oldStatus, newStatus) {
+		return status.Errorf(codes.FailedPrecondition, "cannot change order status from %s to %s", oldStatus, newStatus)
+	}
+
+	order.Status = newStatus
+	if err := tx.Model(order).Update("status", order.Status).Error; err != nil {
+		return status.Errorf(codes.Internal, "failed to update order status: %v", err)
+	}
+	if err := recordStatusChange(tx, order.ID, oldStatus, order.Status, actor, reason); err != nil {
+		return err
+	}
+
+	event := &eventspb.OrderStatusChangedEvent{
+		OrderId:   order.ID,
+		UserId:    order.UserID,
+		OldStatus: oldStatus,
+		NewStatus: order.Status,
+		UserEmail: order.UserEmail,
+		Reason:    reason,
+	}
+	if err := enqueueOrderEvent(tx, "order.status_changed", event); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
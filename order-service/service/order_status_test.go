@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{StatusPending, StatusProcessing}:          true,
+		{StatusPending, StatusCancelled}:           true,
+		{StatusProcessing, StatusPartiallyShipped}: true,
+		{StatusProcessing, StatusShipped}:          true,
+		{StatusProcessing, StatusCancelled}:        true,
+		{StatusPartiallyShipped, StatusShipped}:    true,
+		{StatusShipped, StatusDelivered}:           true,
+	}
+	statuses := []string{StatusPending, StatusProcessing, StatusPartiallyShipped, StatusShipped, StatusDelivered, StatusCancelled}
+
+	// Every pair not listed is refused, including staying in the same status
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if canTransition("unknown", StatusProcessing) || canTransition(StatusPending, "unknown") {
+		t.Error("canTransition allows an unknown status")
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	tests := []struct {
+		status   string
+		valid    bool
+		terminal bool
+	}{
+		{StatusPending, true, false},
+		{StatusProcessing, true, false},
+		{StatusPartiallyShipped, true, false},
+		{StatusShipped, true, false},
+		{StatusDelivered, true, true},
+		{StatusCancelled, true, true},
+		{"", false, true},
+		{"Pending", false, true},
+		{"returned", false, true},
+	}
+	for _, tt := range tests {
+		if got := isValidStatus(tt.status); got != tt.valid {
+			t.Errorf("isValidStatus(%q) = %v, want %v", tt.status, got, tt.valid)
+		}
+		if got := isTerminalStatus(tt.status); got != tt.terminal {
+			t.Errorf("isTerminalStatus(%q) = %v, want %v", tt.status, got, tt.terminal)
+		}
+	}
+}
+
+func TestUpdateOrderStatusLeavesPaymentToPaymentService(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	order := &Order{ID: "o1", UserID: "u1", Currency: "USD", TotalAmount: 1999, Status: StatusPending}
+	if err := s.db.Create(order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.UpdateOrderStatus(context.Background(), &pb.UpdateOrderStatusRequest{OrderId: "o1", Status: StatusProcessing, ActorRole: "admin"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("moving an unpaid order to processing: error %v, want FailedPrecondition", err)
+	}
+	if err := s.db.First(order, "id = ?", "o1").Error; err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := findInvoice(s.db, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusPending || invoice != nil {
+		t.Errorf("order %s with invoice %v, want pending without one", order.Status, invoice)
+	}
+}
+
+func TestUpdateOrderStatusLeavesDeliveryToShipments(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	order := &Order{ID: "o1", UserID: "u1", Currency: "USD", TotalAmount: 1999, Status: StatusShipped}
+	if err := s.db.Create(order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.UpdateOrderStatus(context.Background(), &pb.UpdateOrderStatusRequest{OrderId: "o1", Status: StatusDelivered, ActorRole: "admin"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("moving a shipped order to delivered: error %v, want FailedPrecondition", err)
+	}
+	if err := s.db.First(order, "id = ?", "o1").Error; err != nil {
+		t.Fatal(err)
+	}
+	var history int64
+	if err := s.db.Model(&OrderStatusHistory{}).Where("order_id = ?", "o1").Count(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusShipped || history != 0 {
+		t.Errorf("order %s with %d status changes, want shipped without any", order.Status, history)
+	}
+}
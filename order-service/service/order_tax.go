@@ -0,0 +1,100 @@
+package service
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+	"order-service/tax"
+)
+
+// Address is a postal address stored inline on the row that owns it
+type Address struct {
+	Line1      string `gorm:"type:varchar(255)"`
+	Line2      string `gorm:"type:varchar(255)"`
+	City       string `gorm:"type:varchar(255)"`
+	Region     string `gorm:"type:varchar(100)"`
+	PostalCode string `gorm:"type:varchar(50)"`
+	Country    string `gorm:"type:varchar(2)"`
+}
+
+// OrderTax is the total of one tax at one rate over an order, in minor units of its currency
+type OrderTax struct {
+	ID            uint    `gorm:"primaryKey"`
+	OrderID       string  `gorm:"not null;type:varchar(255);index"`
+	Name          string  `gorm:"not null;type:varchar(100)"`
+	Rate          float64 `gorm:"not null;type:decimal(6,4)"`
+	TaxableAmount int64   `gorm:"column:taxable_minor;not null;default:0"`
+	Amount        int64   `gorm:"column:amount_minor;not null;default:0"`
+}
+
+// addressFromProto validates a shipping address. A missing address is allowed and
+// means no tax is charged.
+func addressFromProto(address *pb.Address) (Address, error) {
+	if address == nil {
+		return Address{}, nil
+	}
+
+	result := Address{
+		Line1:      strings.TrimSpace(address.Line1),
+		Line2:      strings.TrimSpace(address.Line2),
+		City:       strings.TrimSpace(address.City),
+		Region:     strings.ToUpper(strings.TrimSpace(address.Region)),
+		PostalCode: strings.TrimSpace(address.PostalCode),
+		Country:    strings.ToUpper(strings.TrimSpace(address.Country)),
+	}
+	if result != (Address{}) && len(result.Country) != 2 {
+		return Address{}, status.Error(codes.InvalidArgument, "shipping address needs a two-letter country code")
+	}
+	return result, nil
+}
+
+func addressToProto(address Address) *pb.Address {
+	if address == (Address{}) {
+		return nil
+	}
+	return &pb.Address{
+		Line1:      address.Line1,
+		Line2:      address.Line2,
+		City:       address.City,
+		Region:     address.Region,
+		PostalCode: address.PostalCode,
+		Country:    address.Country,
+	}
+}
+
+// applyTax computes the tax on the order's lines, after discounts, for its shipping
+// address. It sets the tax of every line and the order's tax totals, but not TotalAmount.
+func applyTax(calculator *tax.Calculator, order *Order) {
+	lines := make([]tax.Line, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = tax.Line{Category: item.TaxCategory, Amount: item.LineTotal - item.Discount}
+	}
+
+	result := calculator.Calculate(order.ShippingAddress.Country, order.ShippingAddress.Region, lines)
+	for i := range order.Items {
+		order.Items[i].TaxAmount = result.Lines[i].Tax
+	}
+	order.TaxAmount = result.Tax
+	order.PricesIncludeTax = result.PricesIncludeTax
+	order.Taxes = nil
+	for _, amount := range result.Breakdown {
+		order.Taxes = append(order.Taxes, OrderTax{
+			Name:          amount.Name,
+			Rate:          amount.Rate,
+			TaxableAmount: amount.Taxable,
+			Amount:        amount.Tax,
+		})
+	}
+}
+
+// amountPaid is what the customer paid for an order line, after discounts and with tax
+func amountPaid(order *Order, item *OrderItem) int64 {
+	paid := item.LineTotal - item.Discount
+	if !order.PricesIncludeTax {
+		paid += item.TaxAmount
+	}
+	return paid
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+)
+
+// orderWatchBuffer is how many status changes a WatchOrder stream may fall behind
+// before it is dropped
+const orderWatchBuffer = 16
+
+// orderWatcher is one WatchOrder stream
+type orderWatcher struct {
+	orderID string
+	lastID  uint                    // last history entry handed over, guarded by orderWatches.mu
+	changes chan OrderStatusHistory // closed when the stream falls behind
+}
+
+// orderWatches hands new status history entries to the WatchOrder streams of this
+// replica. The entries are found by polling the history table, so changes made on
+// any replica reach every stream. An order's entries are inserted under its row
+// lock and so commit in ID order; reading past the last ID handed over skips none.
+type orderWatches struct {
+	mu       sync.Mutex
+	watchers map[string]map[*orderWatcher]struct{} // by order ID
+}
+
+func newOrderWatches() *orderWatches {
+	return &orderWatches{watchers: make(map[string]map[*orderWatcher]struct{})}
+}
+
+// add starts watching an order for the history entries after lastID
+func (w *orderWatches) add(orderID string, lastID uint) *orderWatcher {
+	watcher := &orderWatcher{orderID: orderID, lastID: lastID, changes: make(chan OrderStatusHistory, orderWatchBuffer)}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watchers[orderID] == nil {
+		w.watchers[orderID] = make(map[*orderWatcher]struct{})
+	}
+	w.watchers[orderID][watcher] = struct{}{}
+	return watcher
+}
+
+// remove stops a watcher, if it was not dropped already
+func (w *orderWatches) remove(watcher *orderWatcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.forget(watcher)
+}
+
+func (w *orderWatches) forget(watcher *orderWatcher) {
+	delete(w.watchers[watcher.orderID], watcher)
+	if len(w.watchers[watcher.orderID]) == 0 {
+		delete(w.watchers, watcher.orderID)
+	}
+}
+
+// poll reads the history entries added since the last poll and hands them to the
+// watchers of their order. A watcher whose buffer is full is dropped.
+func (w *orderWatches) poll(ctx context.Context, db *gorm.DB) error {
+	w.mu.Lock()
+	var orderIDs []string
+	var after uint
+	first := true
+	for orderID, watchers := range w.watchers {
+		orderIDs = append(orderIDs, orderID)
+		for watcher := range watchers {
+			if first || watcher.lastID < after {
+				after = watcher.lastID
+				first = false
+			}
+		}
+	}
+	w.mu.Unlock()
+	if len(orderIDs) == 0 {
+		return nil
+	}
+
+	var entries []OrderStatusHistory
+	result := db.WithContext(ctx).Where("order_id IN ? AND id > ?", orderIDs, after).Order("id").Find(&entries)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, entry := range entries {
+		for watcher := range w.watchers[entry.OrderID] {
+			if entry.ID <= watcher.lastID {
+				continue
+			}
+			select {
+			case watcher.changes <- entry:
+				watcher.lastID = entry.ID
+			default:
+				close(watcher.changes)
+				w.forget(watcher)
+			}
+		}
+	}
+	return nil
+}
+
+// WatchOrder streams the status changes of an order as they happen, starting with
+// its current status, and ends once the order reaches a terminal status. The
+// gateway restricts it to the order owner and admins.
+func (s *OrderService) WatchOrder(req *pb.WatchOrderRequest, stream pb.OrderService_WatchOrderServer) error {
+	ctx := stream.Context()
+	var order Order
+	result := s.db.WithContext(ctx).Select("id", "status", "updated_at").Where("id = ?", req.OrderId).First(&order)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return status.Error(codes.NotFound, "order not found")
+		}
+		return status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	// The current status is the latest history entry. Orders placed before the
+	// history was recorded have none and get one made up from the order.
+	var latest OrderStatusHistory
+	result = s.db.WithContext(ctx).Where("order_id = ?", order.ID).Order("id DESC").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		latest = OrderStatusHistory{OrderID: order.ID, NewStatus: order.Status, CreatedAt: order.UpdatedAt}
+	}
+	if err := stream.Send(statusChangeToProto(&latest)); err != nil {
+		return err
+	}
+	if isTerminalStatus(latest.NewStatus) {
+		return nil
+	}
+
+	watcher := s.watches.add(order.ID, latest.ID)
+	defer s.watches.remove(watcher)
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case entry, ok := <-watcher.changes:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "the stream fell behind, watch the order again")
+			}
+			if err := stream.Send(statusChangeToProto(&entry)); err != nil {
+				return err
+			}
+			if isTerminalStatus(entry.NewStatus) {
+				return nil
+			}
+		}
+	}
+}
+
+// RunOrderWatches looks for status changes of watched orders every interval until
+// ctx is cancelled. Nothing is queried while no order is watched.
+func (s *OrderService) RunOrderWatches(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.watches.poll(ctx, s.db); err != nil {
+			log.Printf("Failed to poll watched orders: %v", err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "order-service/order-service/proto"
+)
+
+// watchStream is a WatchOrder stream that hands on what it is sent
+type watchStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	changes chan *pb.OrderStatusChange
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(change *pb.OrderStatusChange) error {
+	s.changes <- change
+	return nil
+}
+
+// startWatch runs WatchOrder in the background. The returned channel gets its result.
+func startWatch(ctx context.Context, s *OrderService, orderID string) (*watchStream, <-chan error) {
+	stream := &watchStream{ctx: ctx, changes: make(chan *pb.OrderStatusChange, 32)}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.WatchOrder(&pb.WatchOrderRequest{OrderId: orderID}, stream)
+	}()
+	return stream, done
+}
+
+func nextChange(t *testing.T, stream *watchStream) *pb.OrderStatusChange {
+	t.Helper()
+	select {
+	case change := <-stream.changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status change sent")
+		return nil
+	}
+}
+
+func watchResult(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchOrder did not return")
+		return nil
+	}
+}
+
+// waitForWatchers waits until n streams watch orderID
+func waitForWatchers(t *testing.T, s *OrderService, orderID string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.watches.mu.Lock()
+		count := len(s.watches.watchers[orderID])
+		s.watches.mu.Unlock()
+		if count == n {
+			return
+		}
+	}
+	t.Fatalf("%s is not watched by %d streams", orderID, n)
+}
+
+func changeTestOrderStatus(t *testing.T, s *OrderService, orderID, newStatus string) {
+	t.Helper()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+		return changeStatus(tx, &order, newStatus, statusActor{Role: "admin", UserID: "admin1"}, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchOrderFanOut(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	for _, id := range []string{"o1", "o2"} {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&Order{ID: id, UserID: "u1", Currency: "USD", Status: StatusPending}).Error; err != nil {
+				return err
+			}
+			return recordStatusChange(tx, id, "", StatusPending, statusActor{UserID: "u1", Role: "customer"}, "")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, firstDone := startWatch(ctx, s, "o1")
+	second, secondDone := startWatch(ctx, s, "o1")
+	otherCtx, cancelOther := context.WithCancel(ctx)
+	other, otherDone := startWatch(otherCtx, s, "o2")
+
+	// Every stream starts with the current status
+	for _, stream := range []*watchStream{first, second, other} {
+		if change := nextChange(t, stream); change.NewStatus != StatusPending || change.OldStatus != "" {
+			t.Errorf("first change %v, want the creation as pending", change)
+		}
+	}
+	waitForWatchers(t, s, "o1", 2)
+	waitForWatchers(t, s, "o2", 1)
+
+	// One poll hands each change to every stream of its order, and only to those
+	changeTestOrderStatus(t, s, "o1", StatusProcessing)
+	changeTestOrderStatus(t, s, "o1", StatusCancelled)
+	if err := s.watches.poll(ctx, s.db); err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []*watchStream{first, second} {
+		if change := nextChange(t, stream); change.OldStatus != StatusPending || change.NewStatus != StatusProcessing || change.ActorRole != "admin" {
+			t.Errorf("second change %v, want pending to processing by admin", change)
+		}
+		if change := nextChange(t, stream); change.NewStatus != StatusCancelled {
+			t.Errorf("third change %v, want cancelled", change)
+		}
+	}
+	select {
+	case change := <-other.changes:
+		t.Errorf("stream of o2 got %v", change)
+	default:
+	}
+
+	// Cancelled is terminal, so the streams of o1 end and stop watching
+	for _, done := range []<-chan error{firstDone, secondDone} {
+		if err := watchResult(t, done); err != nil {
+			t.Errorf("WatchOrder after cancellation: %v", err)
+		}
+	}
+	waitForWatchers(t, s, "o1", 0)
+
+	cancelOther()
+	if err := watchResult(t, otherDone); status.Code(err) != codes.Canceled {
+		t.Errorf("WatchOrder after the client left: error %v, want Canceled", err)
+	}
+	waitForWatchers(t, s, "o2", 0)
+}
+
+func TestWatchOrderTerminal(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	if err := s.db.Create(&Order{ID: "o1", UserID: "u1", Currency: "USD", Status: StatusDelivered, UpdatedAt: 100}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// An order without history gets its current status and nothing to wait for
+	stream, done := startWatch(context.Background(), s, "o1")
+	if change := nextChange(t, stream); change.NewStatus != StatusDelivered || change.CreatedAt != 100 {
+		t.Errorf("change %v, want delivered at 100", change)
+	}
+	if err := watchResult(t, done); err != nil {
+		t.Errorf("WatchOrder of a delivered order: %v", err)
+	}
+
+	_, done = startWatch(context.Background(), s, "missing")
+	if err := watchResult(t, done); status.Code(err) != codes.NotFound {
+		t.Errorf("WatchOrder of a missing order: error %v, want NotFound", err)
+	}
+}
+
+func TestOrderWatchesDropSlowWatchers(t *testing.T) {
+	s, _ := newPaymentTestService(t)
+	if err := s.db.Create(&Order{ID: "o1", UserID: "u1", Currency: "USD", Status: StatusPending}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= orderWatchBuffer; i++ {
+		entry := &OrderStatusHistory{OrderID: "o1", OldStatus: StatusPending, NewStatus: StatusPending}
+		if err := s.db.Create(entry).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slow := s.watches.add("o1", 0)
+	late := s.watches.add("o1", orderWatchBuffer)
+	if err := s.watches.poll(context.Background(), s.db); err != nil {
+		t.Fatal(err)
+	}
+
+	received := 0
+	for range slow.changes {
+		received++
+	}
+	if received != orderWatchBuffer {
+		t.Errorf("slow watcher got %d changes before it was dropped, want %d", received, orderWatchBuffer)
+	}
+	if entry := <-late.changes; entry.ID != orderWatchBuffer+1 {
+		t.Errorf("watcher got entry %d, want %d", entry.ID, orderWatchBuffer+1)
+	}
+	s.watches.mu.Lock()
+	_, slowWatched := s.watches.watchers["o1"][slow]
+	_, lateWatched := s.watches.watchers["o1"][late]
+	s.watches.mu.Unlock()
+	if slowWatched || !lateWatched {
+		t.Errorf("slow watcher still watched %v, other watcher watched %v; want only the other", slowWatched, lateWatched)
+	}
+}
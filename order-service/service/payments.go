@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"order-service/events"
+	"shared/messaging"
+	eventspb "shared/proto"
+)
+
+// paymentEventsExchange is the topic exchange payment-service publishes to
+const paymentEventsExchange = "payment_events"
+
+// paymentEventsQueue receives the payment outcomes order-service acts on
+const paymentEventsQueue = "order-service.payments"
+
+// errPaymentIgnored stops a payment outcome from changing an order, after the reason was logged
+var errPaymentIgnored = errors.New("payment outcome ignored")
+
+// ConsumePaymentEvents starts moving paid orders to processing and cancelling
+// orders whose payment failed
+func (s *OrderService) ConsumePaymentEvents(ctx context.Context) error {
+	queue := &messaging.Queue{Name: paymentEventsQueue, Exchange: paymentEventsExchange}
+	events.Handle(queue, "payment.succeeded", s.handlePaymentSucceeded)
+	events.Handle(queue, "payment.failed", s.handlePaymentFailed)
+	return s.messageBroker.Consume(ctx, queue)
+}
+
+// handlePaymentSucceeded and handlePaymentFailed apply a payment outcome to its order.
+// Outcomes for orders that already left pending are ignored, so redelivered events
+// change nothing.
+func (s *OrderService) handlePaymentSucceeded(ctx context.Context, env *messaging.Envelope, event *eventspb.PaymentSettledEvent) error {
+	if event.OrderId == "" {
+		return messaging.Permanent(fmt.Errorf("order ID missing"))
+	}
+	return ignoreSettledPayment(env.Type, event, s.markOrderPaid(ctx, event))
+}
+
+func (s *OrderService) handlePaymentFailed(ctx context.Context, env *messaging.Envelope, event *eventspb.PaymentSettledEvent) error {
+	if event.OrderId == "" {
+		return messaging.Permanent(fmt.Errorf("order ID missing"))
+	}
+	reason := "payment failed"
+	if event.Reason != "" {
+		reason += ": " + event.Reason
+	}
+	_, err := s.cancelOrder(ctx, event.OrderId, reason, systemActor, func(order *Order) error {
+		if order.Status != StatusPending {
+			log.Printf("Ignoring failed payment %s for order %s in status %s", event.PaymentId, order.ID, order.Status)
+			return errPaymentIgnored
+		}
+		return nil
+	})
+	return ignoreSettledPayment(env.Type, event, err)
+}
+
+// ignoreSettledPayment turns the errors of payment events that should not change
+// their order into success, so that they are not retried
+func ignoreSettledPayment(eventType string, event *eventspb.PaymentSettledEvent, err error) error {
+	switch {
+	case err == errPaymentIgnored:
+		return nil
+	case status.Code(err) == codes.NotFound:
+		log.Printf("Ignoring %s for unknown order %s", eventType, event.OrderId)
+		return nil
+	}
+	return err
+}
+
+// markOrderPaid moves a pending order to processing once its total has been paid and
+// issues its invoice.
+// A payment that does not match the order cancels it instead. payment-service pays
+// back the payments of cancelled orders when it sees order.cancelled, including
+// those that arrive after their order was cancelled.
+func (s *OrderService) markOrderPaid(ctx context.Context, event *eventspb.PaymentSettledEvent) error {
+	var saga *OrderSaga
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, event.OrderId, &order); err != nil {
+			return err
+		}
+		if order.Status != StatusPending {
+			if order.Status == StatusCancelled {
+				log.Printf("Order %s was paid after it was cancelled, payment-service refunds payment %s", order.ID, event.PaymentId)
+			} else {
+				log.Printf("Ignoring payment %s for order %s in status %s", event.PaymentId, order.ID, order.Status)
+			}
+			return errPaymentIgnored
+		}
+		if event.Currency != order.Currency || event.AmountMinor != order.TotalAmount {
+			log.Printf("Payment %s of %d %s does not match order %s total of %d %s, cancelling the order",
+				event.PaymentId, event.AmountMinor, event.Currency, order.ID, order.TotalAmount, order.Currency)
+			var err error
+			saga, err = s.cancelLocked(tx, &order, "payment "+event.PaymentId+" did not match the order total", systemActor)
+			return err
+		}
+		if err := changeStatus(tx, &order, StatusProcessing, systemActor, "payment "+event.PaymentId+" succeeded"); err != nil {
+			return err
+		}
+		_, err := issueInvoice(tx, &order)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.outboxRelay.Wake()
+
+	if saga != nil {
+		s.runRestock(saga)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"order-service/events"
+	"shared/messaging"
+	eventspb "shared/proto"
+)
+
+// newPaymentTestService returns an OrderService on an empty sqlite database that
+// consumes payment events from a MemoryBroker
+func newPaymentTestService(t *testing.T) (*OrderService, *messaging.MemoryBroker) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "orders.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	broker := messaging.NewMemoryBroker()
+	s := &OrderService{
+		db:            db,
+		messageBroker: broker,
+		outboxRelay:   messaging.NewOutboxRelay(db, events.OutboxTable, broker),
+		watches:       newOrderWatches(),
+	}
+	if err := s.ConsumePaymentEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, broker
+}
+
+func TestPaymentEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		orderStatus string // no order if empty
+		eventType   string
+		event       *eventspb.PaymentSettledEvent
+		wantStatus  string
+		wantEvents  []string // routing keys written to the outbox
+		wantInvoice bool
+		wantDead    bool
+	}{
+		{
+			name: "paid", orderStatus: StatusPending, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "USD", AmountMinor: 1999},
+			wantStatus: StatusProcessing, wantEvents: []string{"order.status_changed"}, wantInvoice: true,
+		},
+		{
+			name: "redelivered after paid", orderStatus: StatusProcessing, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "USD", AmountMinor: 1999},
+			wantStatus: StatusProcessing,
+		},
+		{
+			name: "paid after cancellation", orderStatus: StatusCancelled, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "USD", AmountMinor: 1999},
+			wantStatus: StatusCancelled,
+		},
+		{
+			name: "amount does not match", orderStatus: StatusPending, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "USD", AmountMinor: 999},
+			wantStatus: StatusCancelled, wantEvents: []string{"order.status_changed", "order.cancelled"},
+		},
+		{
+			name: "currency does not match", orderStatus: StatusPending, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "EUR", AmountMinor: 1999},
+			wantStatus: StatusCancelled, wantEvents: []string{"order.status_changed", "order.cancelled"},
+		},
+		{
+			name: "unknown order", eventType: "payment.succeeded",
+			event: &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Currency: "USD", AmountMinor: 1999},
+		},
+		{
+			name: "no order ID", orderStatus: StatusPending, eventType: "payment.succeeded",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", Currency: "USD", AmountMinor: 1999},
+			wantStatus: StatusPending, wantDead: true,
+		},
+		{
+			name: "declined", orderStatus: StatusPending, eventType: "payment.failed",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Status: "failed", Reason: "card_declined"},
+			wantStatus: StatusCancelled, wantEvents: []string{"order.status_changed", "order.cancelled"},
+		},
+		{
+			name: "declined after paid", orderStatus: StatusProcessing, eventType: "payment.failed",
+			event:      &eventspb.PaymentSettledEvent{PaymentId: "p1", OrderId: "o1", Status: "failed", Reason: "card_declined"},
+			wantStatus: StatusProcessing,
+		},
+	}
+
+	for _, tt := range tests {
+		s, broker := newPaymentTestService(t)
+		if tt.orderStatus != "" {
+			order := &Order{ID: "o1", UserID: "u1", Currency: "USD", TotalAmount: 1999, Status: tt.orderStatus}
+			if err := s.db.Create(order).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		env, err := events.New(context.Background(), tt.eventType, tt.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := broker.PublishEvent(paymentEventsExchange, tt.eventType, env); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		broker.Drain()
+
+		if gotDead := len(broker.DeadLetters(paymentEventsQueue)) > 0; gotDead != tt.wantDead {
+			t.Errorf("%s: dead-lettered %v, want %v", tt.name, gotDead, tt.wantDead)
+		}
+		if tt.orderStatus != "" {
+			var order Order
+			if err := s.db.First(&order, "id = ?", "o1").Error; err != nil {
+				t.Fatal(err)
+			}
+			if order.Status != tt.wantStatus {
+				t.Errorf("%s: order %s, want %s", tt.name, order.Status, tt.wantStatus)
+			}
+			invoice, err := findInvoice(s.db, "o1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotInvoice := invoice != nil; gotInvoice != tt.wantInvoice {
+				t.Errorf("%s: invoiced %v, want %v", tt.name, gotInvoice, tt.wantInvoice)
+			}
+		}
+
+		var outbox []messaging.OutboxEvent
+		if err := s.db.Order("id").Find(&outbox).Error; err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, row := range outbox {
+			got = append(got, row.RoutingKey)
+		}
+		if len(got) != len(tt.wantEvents) {
+			t.Errorf("%s: outbox has %v, want %v", tt.name, got, tt.wantEvents)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.wantEvents[i] {
+				t.Errorf("%s: outbox has %v, want %v", tt.name, got, tt.wantEvents)
+				break
+			}
+		}
+	}
+}
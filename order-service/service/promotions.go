@@ -0,0 +1,570 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+	"shared/money"
+)
+
+// Promotion types stored in Promotion.Type
+const (
+	PromotionPercentage = "percentage"
+	PromotionFixed      = "fixed"
+	PromotionBuyXGetY   = "buy_x_get_y"
+)
+
+// maxCouponCodes caps how many codes one order can use
+const maxCouponCodes = 5
+
+// Promotion is a coupon code customers can apply at checkout, see the Promotion proto message.
+// Amount and MinOrderValue are in minor units of Currency.
+type Promotion struct {
+	ID             string `gorm:"primaryKey;type:varchar(255)"`
+	Code           string `gorm:"not null;type:varchar(100);uniqueIndex"` // Stored upper case
+	Description    string `gorm:"type:text"`
+	Type           string `gorm:"not null;type:varchar(50)"`
+	PercentBP      int64  `gorm:"column:percent_bp;not null;default:0"` // Percentage off, in basis points
+	Currency       string `gorm:"not null;type:varchar(3);default:''"`
+	Amount         int64  `gorm:"column:amount_minor;not null;default:0"` // Fixed discount
+	ProductID      string `gorm:"type:varchar(255)"`
+	BuyQuantity    int32
+	GetQuantity    int32
+	MinOrderValue  int64 `gorm:"column:min_order_minor;not null;default:0"`
+	MaxUses        int32
+	MaxUsesPerUser int32
+	StartsAt       int64
+	EndsAt         int64
+	Active         bool  `gorm:"not null;default:true"`
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	UpdatedAt      int64 `gorm:"autoUpdateTime"`
+}
+
+// OrderDiscount is a promotion applied to an order. The rows double as the
+// promotion's redemptions: every one on a non-cancelled order counts as a use.
+type OrderDiscount struct {
+	ID          uint   `gorm:"primaryKey"`
+	OrderID     string `gorm:"not null;type:varchar(255);index"`
+	PromotionID string `gorm:"not null;type:varchar(255);index"`
+	Code        string `gorm:"not null;type:varchar(100)"`
+	Description string `gorm:"type:text"`
+	Amount      int64  `gorm:"column:amount_minor;not null;default:0"` // In the order's currency
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ========== CHECKOUT ==========
+
+// applyPromotions looks up the coupon codes and works out the discounts for the
+// order lines. It sets each line's Discount and returns one OrderDiscount per code.
+// Item discounts (buy X get Y) are applied before order discounts, and the total
+// never goes below zero. Fixed amounts and minimum order values are converted to
+// currency with rates. Usage limits are checked again by redeemPromotions.
+func applyPromotions(db *gorm.DB, userID string, couponCodes []string, lines []OrderItem, currency string, rates *money.Rates) ([]OrderDiscount, error) {
+	if len(couponCodes) == 0 {
+		return nil, nil
+	}
+	if len(couponCodes) > maxCouponCodes {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d coupon codes can be used on one order", maxCouponCodes)
+	}
+
+	seen := make(map[string]bool)
+	var promotions []Promotion
+	for _, raw := range couponCodes {
+		code := normalizeCouponCode(raw)
+		if code == "" {
+			return nil, status.Error(codes.InvalidArgument, "coupon code must not be empty")
+		}
+		if seen[code] {
+			return nil, status.Errorf(codes.InvalidArgument, "coupon code %s is given more than once", code)
+		}
+		seen[code] = true
+
+		var promotion Promotion
+		if err := db.Where("code = ?", code).First(&promotion).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown coupon code %s", code)
+			}
+			return nil, status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		promotions = append(promotions, promotion)
+	}
+
+	var subtotal int64
+	for _, line := range lines {
+		subtotal += line.LineTotal
+	}
+
+	now := time.Now().Unix()
+	for i := range promotions {
+		promotion := &promotions[i]
+		if err := checkPromotionUsable(db, promotion, userID, now); err != nil {
+			return nil, err
+		}
+		minimum, err := rates.Convert(promotion.MinOrderValue, promotion.Currency, currency)
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "coupon code %s cannot be used in %s: %v", promotion.Code, currency, err)
+		}
+		if subtotal < minimum {
+			return nil, status.Errorf(codes.FailedPrecondition, "coupon code %s needs an order of at least %s", promotion.Code, money.Money{Minor: minimum, Currency: currency})
+		}
+	}
+
+	// Item discounts first, so that percentages apply to what is left to pay
+	sort.SliceStable(promotions, func(i, j int) bool {
+		return promotions[i].Type == PromotionBuyXGetY && promotions[j].Type != PromotionBuyXGetY
+	})
+
+	var discounts []OrderDiscount
+	for i := range promotions {
+		promotion := &promotions[i]
+		var amount int64
+		switch promotion.Type {
+		case PromotionBuyXGetY:
+			amount = applyBuyXGetY(promotion, lines)
+		case PromotionFixed:
+			fixed, err := rates.Convert(promotion.Amount, promotion.Currency, currency)
+			if err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "coupon code %s cannot be used in %s: %v", promotion.Code, currency, err)
+			}
+			amount = applyOrderDiscount(lines, func(remaining int64) int64 { return fixed })
+		default:
+			amount = applyOrderDiscount(lines, func(remaining int64) int64 { return money.BasisPoints(remaining, promotion.PercentBP) })
+		}
+		if amount <= 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "coupon code %s does not apply to this order", promotion.Code)
+		}
+		discounts = append(discounts, OrderDiscount{
+			PromotionID: promotion.ID,
+			Code:        promotion.Code,
+			Description: promotion.Description,
+			Amount:      amount,
+		})
+	}
+	return discounts, nil
+}
+
+// checkPromotionUsable returns a FailedPrecondition error if the promotion cannot be
+// used by userID at time now
+func checkPromotionUsable(db *gorm.DB, promotion *Promotion, userID string, now int64) error {
+	if !promotion.Active || (promotion.StartsAt != 0 && now < promotion.StartsAt) || (promotion.EndsAt != 0 && now >= promotion.EndsAt) {
+		return status.Errorf(codes.FailedPrecondition, "coupon code %s is not valid at this time", promotion.Code)
+	}
+
+	if promotion.MaxUses > 0 {
+		used, err := promotionUses(db, promotion.ID, "")
+		if err != nil {
+			return err
+		}
+		if used >= int64(promotion.MaxUses) {
+			return status.Errorf(codes.FailedPrecondition, "coupon code %s has been used up", promotion.Code)
+		}
+	}
+	if promotion.MaxUsesPerUser > 0 {
+		used, err := promotionUses(db, promotion.ID, userID)
+		if err != nil {
+			return err
+		}
+		if used >= int64(promotion.MaxUsesPerUser) {
+			return status.Errorf(codes.FailedPrecondition, "you have already used coupon code %s", promotion.Code)
+		}
+	}
+	return nil
+}
+
+// promotionUses counts the non-cancelled orders that used a promotion, only those
+// of userID if it is set
+func promotionUses(db *gorm.DB, promotionID, userID string) (int64, error) {
+	query := db.Model(&OrderDiscount{}).
+		Joins("JOIN orders ON orders.id = order_discounts.order_id").
+		Where("order_discounts.promotion_id = ? AND orders.status <> ?", promotionID, StatusCancelled)
+	if userID != "" {
+		query = query.Where("orders.user_id = ?", userID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	return count, nil
+}
+
+// applyBuyXGetY makes get_quantity of every buy_quantity + get_quantity units of the
+// promotion's product free and returns the discount
+func applyBuyXGetY(promotion *Promotion, lines []OrderItem) int64 {
+	var quantity int32
+	for _, line := range lines {
+		if line.ProductID == promotion.ProductID {
+			quantity += line.Quantity
+		}
+	}
+	free := quantity / (promotion.BuyQuantity + promotion.GetQuantity) * promotion.GetQuantity
+	if free == 0 {
+		return 0
+	}
+
+	// Take the free units off the lines of the product in order
+	var total int64
+	for i := range lines {
+		line := &lines[i]
+		if line.ProductID != promotion.ProductID || free == 0 {
+			continue
+		}
+		units := line.Quantity
+		if units > free {
+			units = free
+		}
+		amount := line.UnitPrice * int64(units)
+		if left := line.LineTotal - line.Discount; amount > left {
+			amount = left
+		}
+		line.Discount += amount
+		total += amount
+		free -= units
+	}
+	return total
+}
+
+// applyOrderDiscount takes discount(remaining) off what is left to pay, at most all
+// of it, spreading it over the lines in proportion to their remaining amount so that
+// the shares add up exactly
+func applyOrderDiscount(lines []OrderItem, discount func(remaining int64) int64) int64 {
+	weights := make([]int64, len(lines))
+	var remaining int64
+	for i, line := range lines {
+		weights[i] = line.LineTotal - line.Discount
+		if weights[i] > 0 {
+			remaining += weights[i]
+		}
+	}
+	if remaining == 0 {
+		return 0
+	}
+
+	total := discount(remaining)
+	if total > remaining {
+		total = remaining
+	}
+	for i, share := range money.Allocate(total, weights) {
+		lines[i].Discount += share
+	}
+	return total
+}
+
+// redeemPromotions checks the usage limits of the order's promotions again in tx,
+// holding a lock on each promotion so concurrent checkouts cannot exceed them.
+// It must run before the order is inserted.
+func redeemPromotions(tx *gorm.DB, order *Order) error {
+	if len(order.Discounts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(order.Discounts))
+	for _, discount := range order.Discounts {
+		ids = append(ids, discount.PromotionID)
+	}
+	var promotions []Promotion
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Order("id").Find(&promotions)
+	if result.Error != nil {
+		return status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	if len(promotions) != len(ids) {
+		return status.Error(codes.FailedPrecondition, "a coupon code was removed during checkout")
+	}
+
+	now := time.Now().Unix()
+	for i := range promotions {
+		if err := checkPromotionUsable(tx, &promotions[i], order.UserID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ========== ADMIN ==========
+
+func (s *OrderService) CreatePromotion(ctx context.Context, req *pb.Promotion) (*pb.Promotion, error) {
+	rates, err := s.exchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	promotion := &Promotion{ID: generateID()}
+	if err := setPromotionFields(promotion, req, rates.Base); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Create(promotion).Error; err != nil {
+		if s.promotionCodeTaken(ctx, promotion.Code, "") {
+			return nil, status.Errorf(codes.AlreadyExists, "coupon code %s already exists", promotion.Code)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to create promotion: %v", err)
+	}
+	return promotionToResponse(promotion, 0), nil
+}
+
+func (s *OrderService) GetPromotion(ctx context.Context, req *pb.GetPromotionRequest) (*pb.Promotion, error) {
+	promotion, err := s.findPromotion(ctx, req.PromotionId)
+	if err != nil {
+		return nil, err
+	}
+	used, err := promotionUses(s.db.WithContext(ctx), promotion.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	return promotionToResponse(promotion, used), nil
+}
+
+func (s *OrderService) ListPromotions(ctx context.Context, req *pb.ListPromotionsRequest) (*pb.ListPromotionsResponse, error) {
+	query := s.db.WithContext(ctx)
+	if req.ActiveOnly {
+		query = query.Where("active = ?", true)
+	}
+
+	var promotions []Promotion
+	if err := query.Order("created_at DESC, id").Find(&promotions).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	resp := &pb.ListPromotionsResponse{}
+	for i := range promotions {
+		used, err := promotionUses(s.db.WithContext(ctx), promotions[i].ID, "")
+		if err != nil {
+			return nil, err
+		}
+		resp.Promotions = append(resp.Promotions, promotionToResponse(&promotions[i], used))
+	}
+	return resp, nil
+}
+
+// UpdatePromotion replaces every editable field of a promotion. Once an order has used
+// it, only its end date, usage limits and active flag can change, so that it keeps the
+// terms those orders got.
+func (s *OrderService) UpdatePromotion(ctx context.Context, req *pb.Promotion) (*pb.Promotion, error) {
+	rates, err := s.exchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotion Promotion
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", req.PromotionId).First(&promotion)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "promotion not found")
+			}
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+
+		before := promotion
+		if err := setPromotionFields(&promotion, req, rates.Base); err != nil {
+			return err
+		}
+
+		var used int64
+		if err := tx.Model(&OrderDiscount{}).Where("promotion_id = ?", promotion.ID).Count(&used).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if used > 0 && promotionTermsChanged(before, promotion) {
+			return status.Error(codes.FailedPrecondition, "promotion has been used, only ends_at, max_uses, max_uses_per_user and active can change")
+		}
+
+		if err := tx.Save(&promotion).Error; err != nil {
+			if s.promotionCodeTaken(ctx, promotion.Code, promotion.ID) {
+				return status.Errorf(codes.AlreadyExists, "coupon code %s already exists", promotion.Code)
+			}
+			return status.Errorf(codes.Internal, "failed to update promotion: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := promotionUses(s.db.WithContext(ctx), promotion.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	return promotionToResponse(&promotion, used), nil
+}
+
+// promotionTermsChanged reports whether a and b differ in more than the fields a used
+// promotion may still change
+func promotionTermsChanged(a, b Promotion) bool {
+	for _, p := range []*Promotion{&a, &b} {
+		p.EndsAt, p.MaxUses, p.MaxUsesPerUser, p.Active = 0, 0, 0, false
+	}
+	return a != b
+}
+
+// DeletePromotion deletes a promotion no order has used. Used promotions are kept for
+// their usage counts and should be deactivated instead.
+func (s *OrderService) DeletePromotion(ctx context.Context, req *pb.DeletePromotionRequest) (*pb.DeletePromotionResponse, error) {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var promotion Promotion
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", req.PromotionId).First(&promotion)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "promotion not found")
+			}
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+
+		var used int64
+		if err := tx.Model(&OrderDiscount{}).Where("promotion_id = ?", promotion.ID).Count(&used).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if used > 0 {
+			return status.Error(codes.FailedPrecondition, "promotion has been used, deactivate it instead")
+		}
+
+		if err := tx.Delete(&promotion).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to delete promotion: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DeletePromotionResponse{Success: true}, nil
+}
+
+func (s *OrderService) findPromotion(ctx context.Context, id string) (*Promotion, error) {
+	var promotion Promotion
+	result := s.db.WithContext(ctx).Where("id = ?", id).First(&promotion)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "promotion not found")
+		}
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	return &promotion, nil
+}
+
+// promotionCodeTaken reports whether another promotion than exceptID uses code
+func (s *OrderService) promotionCodeTaken(ctx context.Context, code, exceptID string) bool {
+	var count int64
+	s.db.WithContext(ctx).Model(&Promotion{}).Where("code = ? AND id <> ?", code, exceptID).Count(&count)
+	return count > 0
+}
+
+// setPromotionFields validates req and copies its editable fields to promotion.
+// Amounts without a currency are in baseCurrency.
+func setPromotionFields(promotion *Promotion, req *pb.Promotion, baseCurrency string) error {
+	code := normalizeCouponCode(req.Code)
+	if code == "" {
+		return status.Error(codes.InvalidArgument, "code required")
+	}
+
+	// The fixed amount and the minimum order value share one currency
+	var amount, minOrderValue int64
+	currency := ""
+	for _, m := range []*pb.Money{req.Amount, req.MinOrderValue} {
+		if m == nil {
+			continue
+		}
+		c := money.Normalize(m.Currency)
+		if c == "" {
+			c = baseCurrency
+		}
+		if !money.IsValid(c) {
+			return status.Errorf(codes.InvalidArgument, "unsupported currency %q", m.Currency)
+		}
+		if currency != "" && c != currency {
+			return status.Error(codes.InvalidArgument, "amount and min_order_value must be in the same currency")
+		}
+		currency = c
+	}
+	if currency == "" {
+		currency = baseCurrency
+	}
+	if req.Amount != nil {
+		amount = req.Amount.MinorUnits
+	}
+	if req.MinOrderValue != nil {
+		minOrderValue = req.MinOrderValue.MinorUnits
+	}
+
+	var percentBP int64
+	switch req.Type {
+	case PromotionPercentage:
+		bp := new(big.Rat).Mul(money.RatFromFloat(req.Value), big.NewRat(100, 1))
+		if !bp.IsInt() || bp.Sign() <= 0 || bp.Cmp(big.NewRat(10000, 1)) > 0 {
+			return status.Error(codes.InvalidArgument, "percentage must be between 0 and 100 with at most two decimals")
+		}
+		percentBP = bp.Num().Int64()
+	case PromotionFixed:
+		if amount <= 0 {
+			return status.Error(codes.InvalidArgument, "fixed discount must be positive")
+		}
+	case PromotionBuyXGetY:
+		if req.ProductId == "" {
+			return status.Error(codes.InvalidArgument, "product ID required for buy_x_get_y")
+		}
+		if req.BuyQuantity <= 0 || req.GetQuantity <= 0 {
+			return status.Error(codes.InvalidArgument, "buy_quantity and get_quantity must be positive")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid promotion type %q", req.Type)
+	}
+
+	if minOrderValue < 0 || req.MaxUses < 0 || req.MaxUsesPerUser < 0 {
+		return status.Error(codes.InvalidArgument, "limits must not be negative")
+	}
+	if req.StartsAt != 0 && req.EndsAt != 0 && req.EndsAt <= req.StartsAt {
+		return status.Error(codes.InvalidArgument, "ends_at must be after starts_at")
+	}
+
+	promotion.Code = code
+	promotion.Description = req.Description
+	promotion.Type = req.Type
+	promotion.PercentBP = percentBP
+	promotion.Currency = currency
+	promotion.Amount = 0
+	if req.Type == PromotionFixed {
+		promotion.Amount = amount
+	}
+	promotion.ProductID = req.ProductId
+	promotion.BuyQuantity = req.BuyQuantity
+	promotion.GetQuantity = req.GetQuantity
+	promotion.MinOrderValue = minOrderValue
+	promotion.MaxUses = req.MaxUses
+	promotion.MaxUsesPerUser = req.MaxUsesPerUser
+	promotion.StartsAt = req.StartsAt
+	promotion.EndsAt = req.EndsAt
+	promotion.Active = req.Active
+	return nil
+}
+
+func promotionToResponse(promotion *Promotion, used int64) *pb.Promotion {
+	return &pb.Promotion{
+		PromotionId:    promotion.ID,
+		Code:           promotion.Code,
+		Description:    promotion.Description,
+		Type:           promotion.Type,
+		Value:          float64(promotion.PercentBP) / 100,
+		Amount:         moneyToProto(promotion.Amount, promotion.Currency),
+		ProductId:      promotion.ProductID,
+		BuyQuantity:    promotion.BuyQuantity,
+		GetQuantity:    promotion.GetQuantity,
+		MinOrderValue:  moneyToProto(promotion.MinOrderValue, promotion.Currency),
+		MaxUses:        promotion.MaxUses,
+		MaxUsesPerUser: promotion.MaxUsesPerUser,
+		StartsAt:       promotion.StartsAt,
+		EndsAt:         promotion.EndsAt,
+		Active:         promotion.Active,
+		TimesUsed:      int32(used),
+		CreatedAt:      promotion.CreatedAt,
+		UpdatedAt:      promotion.UpdatedAt,
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+func createTestPromotion(t *testing.T, s *OrderService, req *pb.Promotion) *pb.Promotion {
+	req.Active = true
+	promotion, err := s.CreatePromotion(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return promotion
+}
+
+func orderWithCoupons(s *OrderService, userID string, codes ...string) (*pb.OrderResponse, error) {
+	return s.CreateOrder(context.Background(), &pb.CreateOrderRequest{
+		UserId:      userID,
+		Items:       []*pb.OrderItem{{ProductId: "p1", Quantity: 3}, {ProductId: "p2", Quantity: 1}},
+		CouponCodes: codes,
+	})
+}
+
+func TestPromotionStacking(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	createTestPromotion(t, s, &pb.Promotion{Code: "b2g1", Type: PromotionBuyXGetY, ProductId: "p1", BuyQuantity: 2, GetQuantity: 1})
+	createTestPromotion(t, s, &pb.Promotion{Code: "pct12", Type: PromotionPercentage, Value: 12.5})
+	createTestPromotion(t, s, &pb.Promotion{Code: "fixed5", Type: PromotionFixed, Amount: &pb.Money{MinorUnits: 500}})
+
+	// 5000 in total: one p1 is free, 12.5% comes off the other 4000, then 5.00
+	order, err := orderWithCoupons(s, "u1", "pct12", "fixed5", "B2G1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"B2G1": 1000, "PCT12": 500, "FIXED5": 500}
+	if len(order.Discounts) != len(want) {
+		t.Fatalf("discounts %v, want %v", order.Discounts, want)
+	}
+	for _, discount := range order.Discounts {
+		if discount.Amount.MinorUnits != want[discount.Code] {
+			t.Errorf("discount %s = %d, want %d", discount.Code, discount.Amount.MinorUnits, want[discount.Code])
+		}
+	}
+	if order.DiscountAmount.MinorUnits != 2000 || order.TotalAmount.MinorUnits != 3000 {
+		t.Errorf("discount %d and total %d, want 2000 and 3000", order.DiscountAmount.MinorUnits, order.TotalAmount.MinorUnits)
+	}
+}
+
+func TestPromotionRedemptionLimits(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 100, 1000)
+	products.add("p2", 100, 2000)
+	createTestPromotion(t, s, &pb.Promotion{Code: "ONCE", Type: PromotionPercentage, Value: 10, MaxUses: 2, MaxUsesPerUser: 1})
+
+	first, err := orderWithCoupons(s, "u1", "ONCE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orderWithCoupons(s, "u1", "ONCE"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("second use by the same user: error %v, want FailedPrecondition", err)
+	}
+	if _, err := orderWithCoupons(s, "u2", "ONCE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orderWithCoupons(s, "u3", "ONCE"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("use beyond max_uses: error %v, want FailedPrecondition", err)
+	}
+	if got := products.stockOf("p1"); got != 94 {
+		t.Errorf("stock of p1 = %d, want 94 after two orders", got)
+	}
+
+	// A cancelled order gives its use back
+	if _, err := s.CancelOrder(context.Background(), &pb.CancelOrderRequest{OrderId: first.OrderId, ActorUserId: "u1", ActorRole: "customer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orderWithCoupons(s, "u3", "ONCE"); err != nil {
+		t.Errorf("use after a cancellation: %v", err)
+	}
+}
+
+func TestUpdateUsedPromotion(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	req := &pb.Promotion{Code: "TEN", Type: PromotionPercentage, Value: 10}
+	req.PromotionId = createTestPromotion(t, s, req).PromotionId
+
+	// Anything can change until the promotion is used
+	req.Value = 15
+	if _, err := s.UpdatePromotion(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orderWithCoupons(s, "u1", "TEN"); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Value = 20
+	if _, err := s.UpdatePromotion(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("changing the percentage of a used promotion: error %v, want FailedPrecondition", err)
+	}
+	req.Value = 15
+	req.Code = "TWENTY"
+	if _, err := s.UpdatePromotion(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("changing the code of a used promotion: error %v, want FailedPrecondition", err)
+	}
+
+	req.Code = "TEN"
+	req.EndsAt = time.Now().Add(time.Hour).Unix()
+	req.MaxUses = 10
+	req.MaxUsesPerUser = 2
+	req.Active = false
+	updated, err := s.UpdatePromotion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("changing the limits of a used promotion: %v", err)
+	}
+	if updated.Value != 15 || updated.EndsAt != req.EndsAt || updated.MaxUses != 10 || updated.MaxUsesPerUser != 2 || updated.Active || updated.TimesUsed != 1 {
+		t.Errorf("updated promotion %v", updated)
+	}
+}
+
+func TestPromotionPercentage(t *testing.T) {
+	s, _ := newCheckoutTestService(t)
+
+	for _, value := range []float64{0, -5, 100.01, 12.345} {
+		_, err := s.CreatePromotion(context.Background(), &pb.Promotion{Code: "BAD", Type: PromotionPercentage, Value: value})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("percentage %v: error %v, want InvalidArgument", value, err)
+		}
+	}
+
+	promotion := createTestPromotion(t, s, &pb.Promotion{Code: "SMALL", Type: PromotionPercentage, Value: 0.07})
+	var stored Promotion
+	if err := s.db.First(&stored, "id = ?", promotion.PromotionId).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.PercentBP != 7 || promotion.Value != 0.07 {
+		t.Errorf("0.07%% stored as %d basis points and returned as %v", stored.PercentBP, promotion.Value)
+	}
+}
+
+func TestMigrateLegacyPromotionValues(t *testing.T) {
+	s, _ := newCheckoutTestService(t)
+	if err := s.db.Exec("ALTER TABLE promotions ADD COLUMN \"value\" decimal(10,2) NOT NULL DEFAULT 0").Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct {
+		id, kind string
+		value    float64
+	}{{"pct", PromotionPercentage, 12.5}, {"fixed", PromotionFixed, 0}} {
+		if err := s.db.Exec("INSERT INTO promotions (id, code, type, value, amount_minor, active) VALUES (?, ?, ?, ?, 500, true)", p.id, p.id, p.kind, p.value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MigrateLegacyAmounts(s.db, "USD"); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.Migrator().HasColumn(&Promotion{}, "value") {
+		t.Error("promotions.value was not dropped")
+	}
+	var pct, fixed Promotion
+	if err := s.db.First(&pct, "id = ?", "pct").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.First(&fixed, "id = ?", "fixed").Error; err != nil {
+		t.Fatal(err)
+	}
+	if pct.PercentBP != 1250 || fixed.PercentBP != 0 || fixed.Amount != 500 {
+		t.Errorf("migrated to %d basis points and %d basis points with %d off", pct.PercentBP, fixed.PercentBP, fixed.Amount)
+	}
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"math/big"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+	"shared/money"
+	eventspb "shared/proto"
+)
+
+// Return statuses stored in ReturnRequest.Status
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnReceived  = "received"
+	ReturnRefunded  = "refunded"
+	ReturnRejected  = "rejected"
+)
+
+// returnTransitions lists, for every return status, the statuses it may move to next.
+// A return can be rejected until it is received; receiving it puts the goods back in
+// stock, so from then on it can only be refunded.
+var returnTransitions = map[string][]string{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived, ReturnRejected},
+	ReturnReceived:  {ReturnRefunded},
+}
+
+// maxReturnsListed caps ListReturns
+const maxReturnsListed = 100
+
+// ReturnRequest is a customer's request to send back part of a delivered order.
+// Amounts are in minor units of the order's currency.
+type ReturnRequest struct {
+	ID           string       `gorm:"primaryKey;type:varchar(255)"`
+	OrderID      string       `gorm:"not null;type:varchar(255);index"`
+	UserID       string       `gorm:"not null;type:varchar(255);index"`
+	Status       string       `gorm:"not null;type:varchar(50);index"`
+	Reason       string       `gorm:"type:text"`
+	Note         string       `gorm:"type:text"` // Latest note from the admin handling the return
+	Currency     string       `gorm:"not null;type:varchar(3);default:''"`
+	RefundAmount int64        `gorm:"column:refund_minor;not null;default:0"`
+	Items        []ReturnItem `gorm:"foreignKey:ReturnID"`
+	CreatedAt    int64        `gorm:"autoCreateTime"`
+	UpdatedAt    int64        `gorm:"autoUpdateTime"`
+}
+
+// ReturnItem is the quantity of one product sent back with a return
+type ReturnItem struct {
+	ID        uint   `gorm:"primaryKey"`
+	ReturnID  string `gorm:"not null;type:varchar(255);index"`
+	ProductID string `gorm:"not null;type:varchar(255)"`
+	Quantity  int32  `gorm:"not null"`
+	Reason    string `gorm:"type:text"`
+	UnitPrice int64  `gorm:"column:unit_price_minor;not null;default:0"` // Average price paid, from the order
+}
+
+func canTransitionReturn(from, to string) bool {
+	for _, next := range returnTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *OrderService) CreateReturn(ctx context.Context, req *pb.CreateReturnRequest) (*pb.ReturnResponse, error) {
+	if req.ActorUserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "actor user ID required")
+	}
+	if len(req.Items) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one item required")
+	}
+
+	var ret *ReturnRequest
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, req.OrderId, &order); err != nil {
+			return err
+		}
+		if req.ActorRole != "admin" && order.UserID != req.ActorUserId {
+			return status.Error(codes.PermissionDenied, "only the order owner or an admin can return items of this order")
+		}
+		if order.Status != StatusDelivered {
+			return status.Errorf(codes.FailedPrecondition, "only delivered orders can be returned (status %s)", order.Status)
+		}
+
+		products, err := returnableProducts(tx, &order)
+		if err != nil {
+			return err
+		}
+
+		ret = &ReturnRequest{
+			ID:       generateID(),
+			OrderID:  order.ID,
+			UserID:   order.UserID,
+			Status:   ReturnRequested,
+			Reason:   req.Reason,
+			Currency: order.Currency,
+		}
+		for _, item := range req.Items {
+			if item.Quantity <= 0 {
+				return status.Errorf(codes.InvalidArgument, "quantity for product %s must be positive", item.ProductId)
+			}
+			product := products[item.ProductId]
+			if product == nil || item.Quantity > product.available() {
+				available := int32(0)
+				if product != nil {
+					available = product.available()
+				}
+				return status.Errorf(codes.FailedPrecondition, "only %d of product %s can still be returned", available, item.ProductId)
+			}
+
+			ret.Items = append(ret.Items, ReturnItem{
+				ProductID: item.ProductId,
+				Quantity:  item.Quantity,
+				Reason:    item.Reason,
+				UnitPrice: product.unitPrice(),
+			})
+			ret.RefundAmount += product.refund(item.Quantity)
+			product.Returned += item.Quantity
+		}
+
+		if err := tx.Create(ret).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to create return: %v", err)
+		}
+		return enqueueReturnEvent(tx, ret)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	return returnToResponse(ret), nil
+}
+
+// returnableProduct is what was bought and paid of one product of an order, and
+// how much of it is in returns that were not rejected
+type returnableProduct struct {
+	Quantity int32
+	Returned int32
+	Paid     int64 // after discounts and with tax
+}
+
+func (p *returnableProduct) available() int32 {
+	return p.Quantity - p.Returned
+}
+
+// unitPrice is the average price paid per unit
+func (p *returnableProduct) unitPrice() int64 {
+	return money.MulRat(p.Paid, big.NewRat(1, int64(p.Quantity)))
+}
+
+// refund is the part of Paid for quantity more units. It is worked out from the
+// units returned before and after, so that the refunds of all units add up to Paid.
+func (p *returnableProduct) refund(quantity int32) int64 {
+	before := money.MulRat(p.Paid, big.NewRat(int64(p.Returned), int64(p.Quantity)))
+	after := money.MulRat(p.Paid, big.NewRat(int64(p.Returned+quantity), int64(p.Quantity)))
+	return after - before
+}
+
+// returnableProducts returns, per product of the order, what can still be returned
+func returnableProducts(tx *gorm.DB, order *Order) (map[string]*returnableProduct, error) {
+	products := make(map[string]*returnableProduct)
+	for i := range order.Items {
+		item := &order.Items[i]
+		if item.Quantity <= 0 {
+			continue
+		}
+		product := products[item.ProductID]
+		if product == nil {
+			product = &returnableProduct{}
+			products[item.ProductID] = product
+		}
+		product.Quantity += item.Quantity
+		product.Paid += amountPaid(order, item)
+	}
+
+	var returned []struct {
+		ProductID string
+		Quantity  int32
+	}
+	result := tx.Model(&ReturnItem{}).
+		Select("return_items.product_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_id").
+		Where("return_requests.order_id = ? AND return_requests.status <> ?", order.ID, ReturnRejected).
+		Group("return_items.product_id").
+		Scan(&returned)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	for _, r := range returned {
+		if product := products[r.ProductID]; product != nil {
+			product.Returned += r.Quantity
+		}
+	}
+	return products, nil
+}
+
+func (s *OrderService) GetReturn(ctx context.Context, req *pb.GetReturnRequest) (*pb.ReturnResponse, error) {
+	var ret ReturnRequest
+	result := s.db.WithContext(ctx).Preload("Items", orderItemsByID).Where("id = ?", req.ReturnId).First(&ret)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "return not found")
+		}
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	return returnToResponse(&ret), nil
+}
+
+func (s *OrderService) ListReturns(ctx context.Context, req *pb.ListReturnsRequest) (*pb.ListReturnsResponse, error) {
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxReturnsListed {
+		limit = maxReturnsListed
+	}
+
+	query := s.db.WithContext(ctx).Preload("Items", orderItemsByID)
+	if req.OrderId != "" {
+		query = query.Where("order_id = ?", req.OrderId)
+	}
+	if req.UserId != "" {
+		query = query.Where("user_id = ?", req.UserId)
+	}
+	if len(req.Statuses) > 0 {
+		query = query.Where("status IN ?", req.Statuses)
+	}
+
+	var rets []ReturnRequest
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&rets).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	resp := &pb.ListReturnsResponse{}
+	for i := range rets {
+		resp.Returns = append(resp.Returns, returnToResponse(&rets[i]))
+	}
+	return resp, nil
+}
+
+// UpdateReturnStatus moves a return through its workflow. Receiving a return puts the
+// items back in stock. The gateway restricts it to admins.
+func (s *OrderService) UpdateReturnStatus(ctx context.Context, req *pb.UpdateReturnStatusRequest) (*pb.ReturnResponse, error) {
+	switch req.Status {
+	case ReturnApproved, ReturnReceived, ReturnRefunded, ReturnRejected:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid return status %q", req.Status)
+	}
+
+	var ret ReturnRequest
+	var saga *OrderSaga
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", req.ReturnId).First(&ret)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "return not found")
+			}
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+		if err := tx.Where("return_id = ?", ret.ID).Order("id").Find(&ret.Items).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+
+		if !canTransitionReturn(ret.Status, req.Status) {
+			return status.Errorf(codes.FailedPrecondition, "cannot change return status from %s to %s", ret.Status, req.Status)
+		}
+
+		ret.Status = req.Status
+		ret.Note = req.Note
+		if err := tx.Model(&ret).Updates(map[string]interface{}{"status": ret.Status, "note": ret.Note}).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to update return: %v", err)
+		}
+
+		if ret.Status == ReturnReceived {
+			var changes []inventoryChange
+			for _, item := range ret.Items {
+				changes = append(changes, inventoryChange{ProductID: item.ProductID, QuantityChange: item.Quantity})
+			}
+			var err error
+			if saga, err = s.startSaga(tx, ret.OrderID, sagaKindRestock, changes); err != nil {
+				return status.Errorf(codes.Internal, "%v", err)
+			}
+		}
+
+		return enqueueReturnEvent(tx, &ret)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	if saga != nil {
+		s.runRestock(saga)
+	}
+	return returnToResponse(&ret), nil
+}
+
+// enqueueReturnEvent queues return.<status> for the return's current status
+func enqueueReturnEvent(tx *gorm.DB, ret *ReturnRequest) error {
+	event := &eventspb.ReturnStatusChangedEvent{
+		ReturnId:    ret.ID,
+		OrderId:     ret.OrderID,
+		UserId:      ret.UserID,
+		Status:      ret.Status,
+		Currency:    ret.Currency,
+		RefundMinor: ret.RefundAmount,
+	}
+	for _, item := range ret.Items {
+		event.Items = append(event.Items, &eventspb.EventItem{ProductId: item.ProductID, Quantity: item.Quantity})
+	}
+	if err := enqueueOrderEvent(tx, "return."+ret.Status, event); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
+func returnToResponse(ret *ReturnRequest) *pb.ReturnResponse {
+	resp := &pb.ReturnResponse{
+		ReturnId:     ret.ID,
+		OrderId:      ret.OrderID,
+		UserId:       ret.UserID,
+		Status:       ret.Status,
+		Reason:       ret.Reason,
+		RefundAmount: moneyToProto(ret.RefundAmount, ret.Currency),
+		Note:         ret.Note,
+		CreatedAt:    ret.CreatedAt,
+		UpdatedAt:    ret.UpdatedAt,
+	}
+	for _, item := range ret.Items {
+		resp.Items = append(resp.Items, &pb.ReturnItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+			Reason:    item.Reason,
+			UnitPrice: moneyToProto(item.UnitPrice, ret.Currency),
+		})
+	}
+	return resp
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "order-service/order-service/proto"
+)
+
+func TestCanTransitionReturn(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{ReturnRequested, ReturnApproved}: true,
+		{ReturnRequested, ReturnRejected}: true,
+		{ReturnApproved, ReturnReceived}:  true,
+		{ReturnApproved, ReturnRejected}:  true,
+		{ReturnReceived, ReturnRefunded}:  true,
+	}
+	statuses := []string{ReturnRequested, ReturnApproved, ReturnReceived, ReturnRefunded, ReturnRejected}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransitionReturn(from, to); got != want {
+				t.Errorf("canTransitionReturn(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+// newReturnTestOrder creates a delivered order of two units of p1, of which the
+// product service has 10 left in stock, and a return of one of them
+func newReturnTestOrder(t *testing.T) (*OrderService, *fakeProducts, string) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 500)
+	order := &Order{
+		ID: "o1", UserID: "u1", Currency: "USD", Status: StatusDelivered, TotalAmount: 1000,
+		Items: []OrderItem{{ProductID: "p1", UnitPrice: 500, Quantity: 2, LineTotal: 1000}},
+	}
+	if err := s.db.Create(order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := s.CreateReturn(context.Background(), &pb.CreateReturnRequest{
+		OrderId: "o1", ActorUserId: "u1", ActorRole: "customer",
+		Items: []*pb.ReturnItem{{ProductId: "p1", Quantity: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, products, ret.ReturnId
+}
+
+func updateReturnStatus(s *OrderService, returnID string, statuses ...string) error {
+	for _, st := range statuses {
+		req := &pb.UpdateReturnStatusRequest{ReturnId: returnID, Status: st}
+		if _, err := s.UpdateReturnStatus(context.Background(), req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReturnReceivedRestocksAndCannotBeRejected(t *testing.T) {
+	s, products, returnID := newReturnTestOrder(t)
+
+	if err := updateReturnStatus(s, returnID, ReturnApproved, ReturnReceived); err != nil {
+		t.Fatal(err)
+	}
+	if got := products.stockOf("p1"); got != 11 {
+		t.Fatalf("stock after receiving the return = %d, want 11", got)
+	}
+
+	err := updateReturnStatus(s, returnID, ReturnRejected)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("rejecting a received return: error %v, want FailedPrecondition", err)
+	}
+	ret, err := s.GetReturn(context.Background(), &pb.GetReturnRequest{ReturnId: returnID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Status != ReturnReceived || products.stockOf("p1") != 11 {
+		t.Errorf("return %s with stock %d, want received with 11", ret.Status, products.stockOf("p1"))
+	}
+
+	if err := updateReturnStatus(s, returnID, ReturnRefunded); err != nil {
+		t.Fatal(err)
+	}
+	if got := products.stockOf("p1"); got != 11 {
+		t.Errorf("stock after refunding the return = %d, want 11", got)
+	}
+}
+
+func TestReturnRejectedBeforeReceiptLeavesStock(t *testing.T) {
+	s, products, returnID := newReturnTestOrder(t)
+
+	if err := updateReturnStatus(s, returnID, ReturnApproved, ReturnRejected); err != nil {
+		t.Fatal(err)
+	}
+	if got := products.stockOf("p1"); got != 10 {
+		t.Errorf("stock after rejecting the return = %d, want 10", got)
+	}
+
+	// The rejected unit can be returned again
+	if _, err := s.CreateReturn(context.Background(), &pb.CreateReturnRequest{
+		OrderId: "o1", ActorUserId: "u1", ActorRole: "customer",
+		Items: []*pb.ReturnItem{{ProductId: "p1", Quantity: 2}},
+	}); err != nil {
+		t.Errorf("returning both units after the rejection: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	productpb "order-service/proto/product"
+)
+
+// Saga kinds
+const (
+	sagaKindCheckout = "checkout" // take stock for a new order, compensated on failure
+	sagaKindRestock  = "restock"  // give back the stock of a cancelled order, retried until done
+)
+
+// Saga states
+const (
+	sagaStarted      = "started"
+	sagaCompleted    = "completed"
+	sagaCompensating = "compensating"
+	sagaCompensated  = "compensated"
+)
+
+// Saga step states
+const (
+	stepPending     = "pending" // inventory call issued, outcome not recorded yet
+	stepApplied     = "applied"
+	stepFailed      = "failed" // inventory call was refused, nothing to undo
+	stepCompensated = "compensated"
+	stepUnknown     = "unknown" // crashed while pending or the call's outcome is unknown, needs manual reconciliation
+)
+
+// sagaRecoveryGrace keeps recovery away from sagas that another replica may still be running
+const sagaRecoveryGrace = 2 * time.Minute
+
+// compensationTimeout bounds every compensating call, which must not depend on the caller's context
+const compensationTimeout = 30 * time.Second
+
+// OrderSaga is the persisted log of a multi-step inventory operation for one order
+type OrderSaga struct {
+	ID          string `gorm:"primaryKey;type:varchar(255)"`
+	OrderID     string `gorm:"not null;type:varchar(255);index"`
+	Kind        string `gorm:"not null;type:varchar(50)"`
+	State       string `gorm:"not null;type:varchar(50);index"`
+	PayloadJSON string `gorm:"type:text"` // checkout: the Order to create; restock: the inventory changes
+	LastError   string `gorm:"type:text"`
+	CreatedAt   int64  `gorm:"autoCreateTime"`
+	UpdatedAt   int64  `gorm:"autoUpdateTime"`
+}
+
+// OrderSagaStep records a single inventory change made on behalf of a saga
+type OrderSagaStep struct {
+	ID             uint   `gorm:"primaryKey"`
+	SagaID         string `gorm:"not null;type:varchar(255);index"`
+	Seq            int    `gorm:"not null"`
+	ProductID      string `gorm:"not null;type:varchar(255)"`
+	QuantityChange int32  `gorm:"not null"`
+	State          string `gorm:"not null;type:varchar(50)"`
+	CreatedAt      int64  `gorm:"autoCreateTime"`
+	UpdatedAt      int64  `gorm:"autoUpdateTime"`
+}
+
+// inventoryChange is one planned stock adjustment
+type inventoryChange struct {
+	ProductID      string `json:"product_id"`
+	QuantityChange int32  `json:"quantity_change"`
+}
+
+// restockChanges returns the inventory changes that give back the stock of an order
+func restockChanges(order *Order) []inventoryChange {
+	var changes []inventoryChange
+	for _, item := range order.Items {
+		changes = append(changes, inventoryChange{ProductID: item.ProductID, QuantityChange: item.Quantity})
+	}
+	return changes
+}
+
+// startSaga persists a new saga before any side effect happens. Pass the transaction
+// that commits the change the saga belongs to, if there is one.
+func (s *OrderService) startSaga(db *gorm.DB, orderID, kind string, payload interface{}) (*OrderSaga, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize saga payload: %v", err)
+	}
+
+	saga := &OrderSaga{
+		ID:          generateID(),
+		OrderID:     orderID,
+		Kind:        kind,
+		State:       sagaStarted,
+		PayloadJSON: string(payloadJSON),
+	}
+	if err := db.Create(saga).Error; err != nil {
+		return nil, fmt.Errorf("failed to start saga: %v", err)
+	}
+	return saga, nil
+}
+
+// applyInventoryChanges runs the changes in order, logging each one as a saga step.
+// It stops at the first failure and returns its error; already applied steps are left
+// for the caller to compensate. Steps recorded by an earlier run are only retried if
+// the product service refused them, so a saga can be resumed without applying a
+// change twice.
+func (s *OrderService) applyInventoryChanges(ctx context.Context, saga *OrderSaga, changes []inventoryChange) error {
+	var recorded []OrderSagaStep
+	if err := s.db.Where("saga_id = ?", saga.ID).Find(&recorded).Error; err != nil {
+		return fmt.Errorf("failed to load saga steps: %v", err)
+	}
+	existing := make(map[int]*OrderSagaStep, len(recorded))
+	for i := range recorded {
+		existing[recorded[i].Seq] = &recorded[i]
+	}
+
+	for i, change := range changes {
+		step, ok := existing[i]
+		if ok && step.State != stepFailed {
+			continue
+		}
+		if ok {
+			s.setStepState(step, stepPending)
+		} else {
+			step = &OrderSagaStep{
+				SagaID:         saga.ID,
+				Seq:            i,
+				ProductID:      change.ProductID,
+				QuantityChange: change.QuantityChange,
+				State:          stepPending,
+			}
+			if err := s.db.Create(step).Error; err != nil {
+				return fmt.Errorf("failed to record saga step: %v", err)
+			}
+		}
+
+		_, err := s.productClient.UpdateInventory(ctx, &productpb.UpdateInventoryRequest{
+			ProductId:      change.ProductID,
+			QuantityChange: change.QuantityChange,
+		})
+		if err != nil {
+			if inventoryChangeRefused(err) {
+				s.setStepState(step, stepFailed)
+			} else {
+				// The product service may have applied the change before the call failed
+				log.Printf("Saga %s: step %d (product %s, change %d) has an unknown outcome, reconcile stock manually: %v",
+					saga.ID, step.Seq, step.ProductID, step.QuantityChange, err)
+				s.setStepState(step, stepUnknown)
+			}
+			return fmt.Errorf("failed to update inventory for product %s: %v", change.ProductID, err)
+		}
+		s.setStepState(step, stepApplied)
+	}
+	return nil
+}
+
+// inventoryChangeRefused reports whether an UpdateInventory error means the change
+// was not applied. Other errors, such as timeouts and lost connections, may come
+// after the product service applied it, and UpdateInventory is not idempotent.
+func inventoryChangeRefused(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.OutOfRange:
+		return true
+	}
+	return false
+}
+
+// compensateSaga undoes every applied step of the saga in reverse order. If an undo
+// fails the saga stays in the compensating state so that recovery can retry it.
+func (s *OrderService) compensateSaga(saga *OrderSaga, cause error) error {
+	saga.State = sagaCompensating
+	if cause != nil {
+		saga.LastError = cause.Error()
+	}
+	if err := s.db.Model(saga).Updates(map[string]interface{}{"state": saga.State, "last_error": saga.LastError}).Error; err != nil {
+		return fmt.Errorf("failed to mark saga %s as compensating: %v", saga.ID, err)
+	}
+
+	var steps []OrderSagaStep
+	if err := s.db.Where("saga_id = ?", saga.ID).Order("seq desc").Find(&steps).Error; err != nil {
+		return fmt.Errorf("failed to load saga steps: %v", err)
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		switch step.State {
+		case stepApplied:
+			ctx, cancel := context.WithTimeout(context.Background(), compensationTimeout)
+			_, err := s.productClient.UpdateInventory(ctx, &productpb.UpdateInventoryRequest{
+				ProductId:      step.ProductID,
+				QuantityChange: -step.QuantityChange,
+			})
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to compensate inventory for product %s: %v", step.ProductID, err)
+			}
+			s.setStepState(step, stepCompensated)
+		case stepPending:
+			// The inventory call may or may not have gone through before the crash, and
+			// UpdateInventory is not idempotent, so neither retrying nor undoing is safe.
+			log.Printf("Saga %s: step %d (product %s, change %d) has an unknown outcome, reconcile stock manually",
+				saga.ID, step.Seq, step.ProductID, step.QuantityChange)
+			s.setStepState(step, stepUnknown)
+		}
+	}
+
+	if err := s.setSagaState(s.db, saga, sagaCompensated); err != nil {
+		return err
+	}
+
+	// A checkout that never produced an order frees its idempotency key for a retry
+	if saga.Kind == sagaKindCheckout {
+		var order Order
+		if err := json.Unmarshal([]byte(saga.PayloadJSON), &order); err == nil && order.IdempotencyKey != "" {
+			s.releaseIdempotencyKey(order.UserID, order.IdempotencyKey)
+		}
+	}
+	return nil
+}
+
+// sagaRecoveryLease names the scheduler lease of saga recovery
+const sagaRecoveryLease = "saga-recovery"
+
+// RecoverSagas finishes or rolls back sagas left behind by a crashed order-service.
+// A checkout whose inventory was fully taken is finished by creating its order and
+// other checkouts are compensated. Restocks are always driven forward. Only the
+// replica holding the recovery lease does this, and every saga is claimed before it
+// is touched, so no saga is resumed twice.
+func (s *OrderService) RecoverSagas(ctx context.Context, lease time.Duration) error {
+	held, err := s.acquireLease(ctx, sagaRecoveryLease, lease)
+	if err != nil || !held {
+		return err
+	}
+
+	cutoff := time.Now().Add(-sagaRecoveryGrace).Unix()
+	var sagas []OrderSaga
+	result := s.db.WithContext(ctx).
+		Where("state IN ? AND updated_at < ?", []string{sagaStarted, sagaCompensating}, cutoff).
+		Find(&sagas)
+	if result.Error != nil {
+		return fmt.Errorf("failed to load unfinished sagas: %v", result.Error)
+	}
+
+	for i := range sagas {
+		saga := &sagas[i]
+		// Renew the lease as recovery goes, every saga may take a while
+		if held, err := s.acquireLease(ctx, sagaRecoveryLease, lease); err != nil || !held {
+			return err
+		}
+		claimed, err := s.claimSaga(ctx, saga)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if err := s.recoverSaga(saga); err != nil {
+			log.Printf("Failed to recover saga %s: %v", saga.ID, err)
+			continue
+		}
+		log.Printf("Recovered %s saga %s: %s", saga.Kind, saga.ID, saga.State)
+	}
+	return nil
+}
+
+// claimSaga takes a saga for recovery by moving its updated_at to now, if it is
+// still as it was loaded. Anyone else who loaded it finds it changed and skips it,
+// and the grace period keeps it from being picked up again while it runs.
+func (s *OrderService) claimSaga(ctx context.Context, saga *OrderSaga) (bool, error) {
+	now := time.Now().Unix()
+	result := s.db.WithContext(ctx).Model(&OrderSaga{}).
+		Where("id = ? AND state = ? AND updated_at = ?", saga.ID, saga.State, saga.UpdatedAt).
+		Update("updated_at", now)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim saga %s: %v", saga.ID, result.Error)
+	}
+	saga.UpdatedAt = now
+	return result.RowsAffected > 0, nil
+}
+
+// RunSagaRecovery calls RecoverSagas every interval until ctx is cancelled. The
+// lease outlives a few intervals, as for the order expiry.
+func (s *OrderService) RunSagaRecovery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.releaseLease(sagaRecoveryLease)
+
+	for {
+		if err := s.RecoverSagas(ctx, 3*interval); err != nil {
+			log.Printf("Saga recovery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *OrderService) recoverSaga(saga *OrderSaga) error {
+	if saga.Kind == sagaKindRestock {
+		return s.resumeRestock(saga)
+	}
+	if saga.State == sagaCompensating {
+		return s.compensateSaga(saga, nil)
+	}
+
+	var order Order
+	if err := json.Unmarshal([]byte(saga.PayloadJSON), &order); err != nil {
+		return fmt.Errorf("failed to deserialize saga payload: %v", err)
+	}
+
+	// The order row was written but the saga was not marked completed
+	var count int64
+	if err := s.db.Model(&Order{}).Where("id = ?", order.ID).Count(&count).Error; err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if count > 0 {
+		return s.setSagaState(s.db, saga, sagaCompleted)
+	}
+
+	var applied int64
+	if err := s.db.Model(&OrderSagaStep{}).Where("saga_id = ? AND state = ?", saga.ID, stepApplied).Count(&applied).Error; err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if int(applied) != len(order.Items) {
+		return s.compensateSaga(saga, fmt.Errorf("order-service stopped during checkout"))
+	}
+
+	if err := s.completeCheckout(saga, &order); err != nil {
+		return s.compensateSaga(saga, err)
+	}
+	s.outboxRelay.Wake()
+	return nil
+}
+
+// completeCheckout writes the order, its order.created event and closes the saga in a single transaction
+func (s *OrderService) completeCheckout(saga *OrderSaga, order *Order) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := redeemPromotions(tx, order); err != nil {
+			return err
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return fmt.Errorf("failed to create order: %v", err)
+		}
+		if err := recordStatusChange(tx, order.ID, "", order.Status, statusActor{UserID: order.UserID, Role: "customer"}, ""); err != nil {
+			return err
+		}
+		if err := enqueueOrderEvent(tx, "order.created", orderCreatedEvent(order)); err != nil {
+			return err
+		}
+		if order.IdempotencyKey != "" {
+			if err := saveIdempotentResponse(tx, order); err != nil {
+				return err
+			}
+		}
+		return s.setSagaState(tx, saga, sagaCompleted)
+	})
+}
+
+// restockOrder records a restock saga for the order in tx. Once tx commits, run
+// the returned saga with runRestock; if that fails, recovery retries it.
+func (s *OrderService) restockOrder(tx *gorm.DB, order *Order) (*OrderSaga, error) {
+	return s.startSaga(tx, order.ID, sagaKindRestock, restockChanges(order))
+}
+
+// runRestock gives back the stock of a committed restock saga. Failures are logged
+// and left to saga recovery, they never fail the caller.
+func (s *OrderService) runRestock(saga *OrderSaga) {
+	if err := s.resumeRestock(saga); err != nil {
+		log.Printf("Restock saga %s for order %s left for recovery: %v", saga.ID, saga.OrderID, err)
+	}
+}
+
+func (s *OrderService) resumeRestock(saga *OrderSaga) error {
+	var changes []inventoryChange
+	if err := json.Unmarshal([]byte(saga.PayloadJSON), &changes); err != nil {
+		return fmt.Errorf("failed to deserialize saga payload: %v", err)
+	}
+
+	// A step left pending by a crash cannot be retried safely, see compensateSaga
+	var pending []OrderSagaStep
+	if err := s.db.Where("saga_id = ? AND state = ?", saga.ID, stepPending).Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to load saga steps: %v", err)
+	}
+	for i := range pending {
+		log.Printf("Saga %s: step %d (product %s, change %d) has an unknown outcome, reconcile stock manually",
+			saga.ID, pending[i].Seq, pending[i].ProductID, pending[i].QuantityChange)
+		s.setStepState(&pending[i], stepUnknown)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), compensationTimeout)
+	defer cancel()
+	if err := s.applyInventoryChanges(ctx, saga, changes); err != nil {
+		if uerr := s.db.Model(saga).Update("last_error", err.Error()).Error; uerr != nil {
+			log.Printf("Failed to record error for saga %s: %v", saga.ID, uerr)
+		}
+		return err
+	}
+	return s.setSagaState(s.db, saga, sagaCompleted)
+}
+
+func (s *OrderService) setSagaState(db *gorm.DB, saga *OrderSaga, state string) error {
+	saga.State = state
+	if err := db.Model(saga).Update("state", state).Error; err != nil {
+		return fmt.Errorf("failed to update saga %s: %v", saga.ID, err)
+	}
+	return nil
+}
+
+func (s *OrderService) setStepState(step *OrderSagaStep, state string) {
+	step.State = state
+	if err := s.db.Model(step).Update("state", state).Error; err != nil {
+		log.Printf("Failed to record state %s for saga %s step %d: %v", state, step.SagaID, step.Seq, err)
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "order-service/order-service/proto"
+)
+
+// sagaSteps returns the states of the saga's steps by seq
+func sagaSteps(t *testing.T, s *OrderService, sagaID string) []string {
+	var steps []OrderSagaStep
+	if err := s.db.Where("saga_id = ?", sagaID).Order("seq").Find(&steps).Error; err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, step := range steps {
+		states = append(states, step.State)
+	}
+	return states
+}
+
+func equalStates(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// leaveSaga makes a saga look as if its order-service stopped longer ago than the
+// recovery grace period
+func leaveSaga(t *testing.T, s *OrderService, saga *OrderSaga) {
+	old := time.Now().Add(-2 * sagaRecoveryGrace).Unix()
+	if err := s.db.Model(&OrderSaga{}).Where("id = ?", saga.ID).UpdateColumn("updated_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func findSaga(t *testing.T, s *OrderService, orderID string) *OrderSaga {
+	var saga OrderSaga
+	if err := s.db.Where("order_id = ?", orderID).First(&saga).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &saga
+}
+
+func TestCheckoutCompensatesTakenStock(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	products.add("p3", 10, 3000)
+	products.failInventory["p3"] = 0
+
+	_, err := s.CreateOrder(context.Background(), &pb.CreateOrderRequest{
+		UserId:         "u1",
+		Items:          []*pb.OrderItem{{ProductId: "p1", Quantity: 2}, {ProductId: "p2", Quantity: 1}, {ProductId: "p3", Quantity: 1}},
+		IdempotencyKey: "k1",
+	})
+	if err == nil {
+		t.Fatal("checkout succeeded although product p3 could not be taken")
+	}
+	for _, id := range []string{"p1", "p2", "p3"} {
+		if got := products.stockOf(id); got != 10 {
+			t.Errorf("stock of %s = %d, want 10", id, got)
+		}
+	}
+
+	var sagas []OrderSaga
+	if err := s.db.Find(&sagas).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sagas) != 1 || sagas[0].State != sagaCompensated || sagas[0].LastError == "" {
+		t.Fatalf("sagas %+v, want one compensated with its error", sagas)
+	}
+	if got, want := sagaSteps(t, s, sagas[0].ID), []string{stepCompensated, stepCompensated, stepFailed}; !equalStates(got, want) {
+		t.Errorf("steps %v, want %v", got, want)
+	}
+	var orders, keys int64
+	s.db.Model(&Order{}).Count(&orders)
+	s.db.Model(&IdempotencyKey{}).Count(&keys)
+	if orders != 0 || keys != 0 {
+		t.Errorf("%d orders and %d idempotency keys left, want none", orders, keys)
+	}
+}
+
+func TestRecoverSagas(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	ctx := context.Background()
+
+	newCheckout := func(orderID string) (*OrderSaga, *Order, []inventoryChange) {
+		order := &Order{
+			ID: orderID, UserID: "u1", Currency: "USD", Status: StatusPending, TotalAmount: 4000,
+			Items: []OrderItem{
+				{ProductID: "p1", UnitPrice: 1000, Quantity: 2, LineTotal: 2000},
+				{ProductID: "p2", UnitPrice: 2000, Quantity: 1, LineTotal: 2000},
+			},
+		}
+		saga, err := s.startSaga(s.db, orderID, sagaKindCheckout, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saga, order, []inventoryChange{{ProductID: "p1", QuantityChange: -2}, {ProductID: "p2", QuantityChange: -1}}
+	}
+
+	// Stopped after taking all the stock, before writing the order
+	complete, _, changes := newCheckout("complete")
+	if err := s.applyInventoryChanges(ctx, complete, changes); err != nil {
+		t.Fatal(err)
+	}
+	leaveSaga(t, s, complete)
+
+	// Stopped after taking part of the stock
+	partial, _, changes := newCheckout("partial")
+	if err := s.applyInventoryChanges(ctx, partial, changes[:1]); err != nil {
+		t.Fatal(err)
+	}
+	leaveSaga(t, s, partial)
+
+	// Stopped while waiting for the product service, the outcome is unknown
+	crashed, _, _ := newCheckout("crashed")
+	step := &OrderSagaStep{SagaID: crashed.ID, Seq: 0, ProductID: "p1", QuantityChange: -2, State: stepPending}
+	if err := s.db.Create(step).Error; err != nil {
+		t.Fatal(err)
+	}
+	leaveSaga(t, s, crashed)
+
+	// A restock that never ran
+	restock, err := s.startSaga(s.db, "cancelled", sagaKindRestock, []inventoryChange{{ProductID: "p2", QuantityChange: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaveSaga(t, s, restock)
+
+	// Still within the grace period, another replica may be running it
+	running, _, _ := newCheckout("running")
+
+	if got := products.stockOf("p1"); got != 6 {
+		t.Fatalf("stock of p1 before recovery = %d, want 6", got)
+	}
+	if err := s.RecoverSagas(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		saga      *OrderSaga
+		wantState string
+		wantSteps []string
+	}{
+		{complete, sagaCompleted, []string{stepApplied, stepApplied}},
+		{partial, sagaCompensated, []string{stepCompensated}},
+		{crashed, sagaCompensated, []string{stepUnknown}},
+		{restock, sagaCompleted, []string{stepApplied}},
+		{running, sagaStarted, nil},
+	}
+	for _, tt := range tests {
+		saga := findSaga(t, s, tt.saga.OrderID)
+		if saga.State != tt.wantState {
+			t.Errorf("saga of %s is %s, want %s", tt.saga.OrderID, saga.State, tt.wantState)
+		}
+		if got := sagaSteps(t, s, saga.ID); !equalStates(got, tt.wantSteps) {
+			t.Errorf("steps of %s are %v, want %v", tt.saga.OrderID, got, tt.wantSteps)
+		}
+	}
+
+	// Only the complete checkout keeps its stock and gets its order
+	if p1, p2 := products.stockOf("p1"), products.stockOf("p2"); p1 != 8 || p2 != 12 {
+		t.Errorf("stock %d of p1 and %d of p2, want 8 and 12", p1, p2)
+	}
+	var orders []Order
+	if err := s.db.Find(&orders).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != "complete" {
+		t.Errorf("orders %v, want only complete", orders)
+	}
+
+	// Recovering again changes nothing
+	if err := s.RecoverSagas(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if p1, p2 := products.stockOf("p1"), products.stockOf("p2"); p1 != 8 || p2 != 12 {
+		t.Errorf("stock after recovering again %d of p1 and %d of p2, want 8 and 12", p1, p2)
+	}
+}
+
+func TestRestockRetriedByRecovery(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 0, 1000)
+	products.add("p2", 0, 2000)
+	products.failInventory["p2"] = 1
+
+	saga, err := s.startSaga(s.db, "o1", sagaKindRestock, []inventoryChange{{ProductID: "p1", QuantityChange: 2}, {ProductID: "p2", QuantityChange: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.runRestock(saga)
+	if got := findSaga(t, s, "o1"); got.State != sagaStarted || got.LastError == "" {
+		t.Fatalf("restock after a failure is %s with error %q, want started with the error", got.State, got.LastError)
+	}
+
+	leaveSaga(t, s, saga)
+	if err := s.RecoverSagas(context.Background(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := findSaga(t, s, "o1"); got.State != sagaCompleted {
+		t.Errorf("restock after recovery is %s, want completed", got.State)
+	}
+	// p1 is not given back twice
+	if p1, p2 := products.stockOf("p1"), products.stockOf("p2"); p1 != 2 || p2 != 1 {
+		t.Errorf("stock %d of p1 and %d of p2, want 2 and 1", p1, p2)
+	}
+}
+
+func TestInventoryTimeoutLeftForReconciliation(t *testing.T) {
+	s, products := newCheckoutTestService(t)
+	products.add("p1", 10, 1000)
+	products.add("p2", 10, 2000)
+	products.add("p3", 10, 3000)
+	products.timeoutInventory["p2"] = 1
+
+	// The product service takes p2's stock, but the order service never hears back
+	_, err := s.CreateOrder(context.Background(), &pb.CreateOrderRequest{
+		UserId: "u1",
+		Items:  []*pb.OrderItem{{ProductId: "p1", Quantity: 2}, {ProductId: "p2", Quantity: 1}, {ProductId: "p3", Quantity: 1}},
+	})
+	if err == nil {
+		t.Fatal("checkout succeeded although taking p2 timed out")
+	}
+	var sagas []OrderSaga
+	if err := s.db.Find(&sagas).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sagas) != 1 || sagas[0].State != sagaCompensated {
+		t.Fatalf("sagas %+v, want one compensated", sagas)
+	}
+	if got, want := sagaSteps(t, s, sagas[0].ID), []string{stepCompensated, stepUnknown}; !equalStates(got, want) {
+		t.Errorf("steps %v, want %v", got, want)
+	}
+	// p2 is neither given back nor taken again, it waits for reconciliation
+	if p1, p2, p3 := products.stockOf("p1"), products.stockOf("p2"), products.stockOf("p3"); p1 != 10 || p2 != 9 || p3 != 10 {
+		t.Errorf("stock %d of p1, %d of p2 and %d of p3, want 10, 9 and 10", p1, p2, p3)
+	}
+
+	// A restock resumed by recovery does not give back stock twice either
+	products.add("p4", 0, 1000)
+	products.timeoutInventory["p4"] = 1
+	restock, err := s.startSaga(s.db, "o1", sagaKindRestock, []inventoryChange{{ProductID: "p4", QuantityChange: 3}, {ProductID: "p1", QuantityChange: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.runRestock(restock)
+	leaveSaga(t, s, restock)
+	if err := s.RecoverSagas(context.Background(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := findSaga(t, s, "o1"); got.State != sagaCompleted {
+		t.Errorf("restock after recovery is %s, want completed", got.State)
+	}
+	if got, want := sagaSteps(t, s, restock.ID), []string{stepUnknown, stepApplied}; !equalStates(got, want) {
+		t.Errorf("restock steps %v, want %v", got, want)
+	}
+	if p4, p1 := products.stockOf("p4"), products.stockOf("p1"); p4 != 3 || p1 != 11 {
+		t.Errorf("stock %d of p4 and %d of p1 after the restock, want 3 and 11", p4, p1)
+	}
+}
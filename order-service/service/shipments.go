@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	pb "order-service/order-service/proto"
+	eventspb "shared/proto"
+)
+
+// Shipment statuses stored in Shipment.Status
+const (
+	ShipmentShipped   = "shipped"
+	ShipmentDelivered = "delivered"
+)
+
+// Shipment is one package sent to the customer, covering some or all units of an order
+type Shipment struct {
+	ID             string         `gorm:"primaryKey;type:varchar(255)"`
+	OrderID        string         `gorm:"not null;type:varchar(255);index"`
+	Carrier        string         `gorm:"not null;type:varchar(100)"`
+	TrackingNumber string         `gorm:"type:varchar(255);index"`
+	TrackingURL    string         `gorm:"type:text"`
+	Status         string         `gorm:"not null;type:varchar(50)"`
+	Items          []ShipmentItem `gorm:"foreignKey:ShipmentID"`
+	ShippedAt      int64          `gorm:"not null"`
+	DeliveredAt    int64
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	UpdatedAt      int64 `gorm:"autoUpdateTime"`
+}
+
+// ShipmentItem is the quantity of one product packed in a shipment
+type ShipmentItem struct {
+	ID         uint   `gorm:"primaryKey"`
+	ShipmentID string `gorm:"not null;type:varchar(255);index"`
+	ProductID  string `gorm:"not null;type:varchar(255)"`
+	Quantity   int32  `gorm:"not null"`
+}
+
+// CreateShipment records a package for an order that is being fulfilled and rolls the
+// order status up to partially_shipped or shipped. The gateway restricts it to admins.
+func (s *OrderService) CreateShipment(ctx context.Context, req *pb.CreateShipmentRequest) (*pb.ShipmentResponse, error) {
+	if strings.TrimSpace(req.Carrier) == "" {
+		return nil, status.Error(codes.InvalidArgument, "carrier required")
+	}
+
+	var shipment *Shipment
+	var order Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, req.OrderId, &order); err != nil {
+			return err
+		}
+		if order.Status != StatusProcessing && order.Status != StatusPartiallyShipped {
+			return status.Errorf(codes.FailedPrecondition, "cannot ship an order with status %s", order.Status)
+		}
+
+		remaining, err := unshippedQuantities(tx, &order)
+		if err != nil {
+			return err
+		}
+
+		shipment = &Shipment{
+			ID:             generateID(),
+			OrderID:        order.ID,
+			Carrier:        req.Carrier,
+			TrackingNumber: req.TrackingNumber,
+			TrackingURL:    req.TrackingUrl,
+			Status:         ShipmentShipped,
+			ShippedAt:      time.Now().Unix(),
+		}
+
+		if len(req.Items) == 0 {
+			// Ship everything that has not been shipped yet, in order item order
+			for _, item := range order.Items {
+				if remaining[item.ProductID] > 0 {
+					shipment.Items = append(shipment.Items, ShipmentItem{ProductID: item.ProductID, Quantity: remaining[item.ProductID]})
+					remaining[item.ProductID] = 0
+				}
+			}
+		} else {
+			for _, item := range req.Items {
+				if item.Quantity <= 0 {
+					return status.Errorf(codes.InvalidArgument, "quantity for product %s must be positive", item.ProductId)
+				}
+				if item.Quantity > remaining[item.ProductId] {
+					return status.Errorf(codes.FailedPrecondition, "only %d of product %s are left to ship", remaining[item.ProductId], item.ProductId)
+				}
+				remaining[item.ProductId] -= item.Quantity
+				shipment.Items = append(shipment.Items, ShipmentItem{ProductID: item.ProductId, Quantity: item.Quantity})
+			}
+		}
+		if len(shipment.Items) == 0 {
+			return status.Error(codes.FailedPrecondition, "every item of the order has already been shipped")
+		}
+
+		if err := tx.Create(shipment).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to create shipment: %v", err)
+		}
+
+		fullyShipped := true
+		for _, quantity := range remaining {
+			if quantity > 0 {
+				fullyShipped = false
+				break
+			}
+		}
+		newStatus := StatusPartiallyShipped
+		if fullyShipped {
+			newStatus = StatusShipped
+		}
+		if newStatus != order.Status {
+			if err := changeStatus(tx, &order, newStatus, statusActor{UserID: req.ActorUserId, Role: req.ActorRole}, ""); err != nil {
+				return err
+			}
+		}
+
+		return enqueueShipmentEvent(tx, "order.shipped", &order, shipment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	return shipmentToResponse(shipment, order.Status), nil
+}
+
+// unshippedQuantities returns, per product of the order, how many units are not in a shipment yet
+func unshippedQuantities(tx *gorm.DB, order *Order) (map[string]int32, error) {
+	remaining := make(map[string]int32)
+	for _, item := range order.Items {
+		remaining[item.ProductID] += item.Quantity
+	}
+
+	var shipped []struct {
+		ProductID string
+		Quantity  int32
+	}
+	result := tx.Model(&ShipmentItem{}).
+		Select("shipment_items.product_id, SUM(shipment_items.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_items.shipment_id").
+		Where("shipments.order_id = ?", order.ID).
+		Group("shipment_items.product_id").
+		Scan(&shipped)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	for _, sh := range shipped {
+		remaining[sh.ProductID] -= sh.Quantity
+	}
+	return remaining, nil
+}
+
+func (s *OrderService) ListShipments(ctx context.Context, req *pb.ListShipmentsRequest) (*pb.ListShipmentsResponse, error) {
+	var order Order
+	if err := s.db.WithContext(ctx).Select("id", "status").Where("id = ?", req.OrderId).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	var shipments []Shipment
+	result := s.db.WithContext(ctx).Preload("Items", orderItemsByID).
+		Where("order_id = ?", req.OrderId).
+		Order("shipped_at, id").
+		Find(&shipments)
+	if result.Error != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+
+	resp := &pb.ListShipmentsResponse{}
+	for i := range shipments {
+		resp.Shipments = append(resp.Shipments, shipmentToResponse(&shipments[i], order.Status))
+	}
+	return resp, nil
+}
+
+// MarkShipmentDelivered records the delivery of a shipment. Once every unit of the
+// order has shipped and every shipment is delivered, the order becomes delivered.
+// The gateway restricts it to admins.
+func (s *OrderService) MarkShipmentDelivered(ctx context.Context, req *pb.MarkShipmentDeliveredRequest) (*pb.ShipmentResponse, error) {
+	var shipment Shipment
+	var order Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", req.ShipmentId).First(&shipment)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return status.Error(codes.NotFound, "shipment not found")
+			}
+			return status.Errorf(codes.Internal, "database error: %v", result.Error)
+		}
+
+		// Lock the order first so concurrent deliveries of its shipments roll up once
+		if err := lockOrder(tx, shipment.OrderID, &order); err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", shipment.ID).First(&shipment).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if err := tx.Where("shipment_id = ?", shipment.ID).Order("id").Find(&shipment.Items).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if shipment.Status == ShipmentDelivered {
+			return status.Error(codes.FailedPrecondition, "shipment is already delivered")
+		}
+
+		shipment.Status = ShipmentDelivered
+		shipment.DeliveredAt = time.Now().Unix()
+		updates := map[string]interface{}{"status": shipment.Status, "delivered_at": shipment.DeliveredAt}
+		if err := tx.Model(&shipment).Updates(updates).Error; err != nil {
+			return status.Errorf(codes.Internal, "failed to update shipment: %v", err)
+		}
+
+		if order.Status != StatusShipped {
+			return nil
+		}
+		var undelivered int64
+		if err := tx.Model(&Shipment{}).Where("order_id = ? AND status <> ?", order.ID, ShipmentDelivered).Count(&undelivered).Error; err != nil {
+			return status.Errorf(codes.Internal, "database error: %v", err)
+		}
+		if undelivered == 0 {
+			return changeStatus(tx, &order, StatusDelivered, statusActor{UserID: req.ActorUserId, Role: req.ActorRole}, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outboxRelay.Wake()
+
+	return shipmentToResponse(&shipment, order.Status), nil
+}
+
+// enqueueShipmentEvent queues a shipment event for the order
+func enqueueShipmentEvent(tx *gorm.DB, routingKey string, order *Order, shipment *Shipment) error {
+	event := &eventspb.OrderShippedEvent{
+		OrderId:        order.ID,
+		UserId:         order.UserID,
+		OrderStatus:    order.Status,
+		ShipmentId:     shipment.ID,
+		Carrier:        shipment.Carrier,
+		TrackingNumber: shipment.TrackingNumber,
+		TrackingUrl:    shipment.TrackingURL,
+		UserEmail:      order.UserEmail,
+	}
+	for _, item := range shipment.Items {
+		event.Items = append(event.Items, &eventspb.EventItem{ProductId: item.ProductID, Quantity: item.Quantity})
+	}
+	if err := enqueueOrderEvent(tx, routingKey, event); err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	return nil
+}
+
+func shipmentToResponse(shipment *Shipment, orderStatus string) *pb.ShipmentResponse {
+	resp := &pb.ShipmentResponse{
+		ShipmentId:     shipment.ID,
+		OrderId:        shipment.OrderID,
+		Carrier:        shipment.Carrier,
+		TrackingNumber: shipment.TrackingNumber,
+		TrackingUrl:    shipment.TrackingURL,
+		Status:         shipment.Status,
+		ShippedAt:      shipment.ShippedAt,
+		DeliveredAt:    shipment.DeliveredAt,
+		OrderStatus:    orderStatus,
+	}
+	for _, item := range shipment.Items {
+		resp.Items = append(resp.Items, &pb.ShipmentItem{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+	return resp
+}
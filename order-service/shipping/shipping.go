@@ -0,0 +1,283 @@
+// Package shipping prices shipping methods from rate tables kept in a local JSON file.
+//
+// Destinations are grouped into zones by country. Every shipping method has a rate
+// table with rows per zone; a row applies up to a billable weight and from an order
+// value, so a row with price 0 and a min_order_value is a free-shipping threshold.
+// Prices and order values in the file are decimal amounts of its currency; orders in
+// other currencies are converted at the current exchange rates.
+package shipping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"shared/money"
+)
+
+// AnyZone in a rate row matches every destination, including none for pickup methods
+const AnyZone = "*"
+
+// Config is the content of the shipping rates file
+type Config struct {
+	Currency      string   `json:"currency"` // of every price in the file, e.g. "USD"
+	DefaultMethod string   `json:"default_method"`
+	Zones         []Zone   `json:"zones"`
+	Methods       []Method `json:"methods"`
+}
+
+// Zone is a group of destination countries. A zone listing "*" takes every country
+// not listed in another zone.
+type Zone struct {
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"`
+}
+
+// Method is a way of getting an order to the customer
+type Method struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	MinDays int32  `json:"min_days"`
+	MaxDays int32  `json:"max_days"`
+	// NoAddress is set for methods such as store pickup that ship nothing
+	NoAddress bool `json:"no_address"`
+	// VolumetricDivisor turns a parcel's volume in cm³ into kilograms of billable
+	// weight, e.g. 5000. Zero bills the actual weight only.
+	VolumetricDivisor float64 `json:"volumetric_divisor"`
+	Rates             []Rate  `json:"rates"`
+}
+
+// Rate is one row of a method's rate table
+type Rate struct {
+	Zone           string  `json:"zone"`
+	MaxWeightGrams int32   `json:"max_weight_grams"` // 0 for no limit
+	MinOrderValue  float64 `json:"min_order_value"`
+	Price          float64 `json:"price"`
+}
+
+// LoadConfig reads and validates a shipping rates file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shipping rates: %v", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse shipping rates %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid shipping rates %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	c.Currency = money.Normalize(c.Currency)
+	if !money.IsValid(c.Currency) {
+		return fmt.Errorf("unsupported currency %q", c.Currency)
+	}
+
+	zones := map[string]bool{AnyZone: true}
+	for _, zone := range c.Zones {
+		if zone.Name == "" || zone.Name == AnyZone {
+			return fmt.Errorf("zone name %q is not allowed", zone.Name)
+		}
+		if zones[zone.Name] {
+			return fmt.Errorf("zone %s is listed more than once", zone.Name)
+		}
+		zones[zone.Name] = true
+	}
+
+	methods := make(map[string]bool)
+	for _, method := range c.Methods {
+		if method.Code == "" {
+			return fmt.Errorf("shipping method without a code")
+		}
+		if methods[method.Code] {
+			return fmt.Errorf("shipping method %s is listed more than once", method.Code)
+		}
+		methods[method.Code] = true
+
+		for _, rate := range method.Rates {
+			if !zones[rate.Zone] {
+				return fmt.Errorf("shipping method %s has a rate for unknown zone %q", method.Code, rate.Zone)
+			}
+			if rate.Price < 0 || rate.MaxWeightGrams < 0 || rate.MinOrderValue < 0 {
+				return fmt.Errorf("shipping method %s has a negative rate", method.Code)
+			}
+		}
+	}
+	if c.DefaultMethod != "" && !methods[c.DefaultMethod] {
+		return fmt.Errorf("default method %s is not listed", c.DefaultMethod)
+	}
+	return nil
+}
+
+// Calculator prices shipping with a Config
+type Calculator struct {
+	config *Config
+}
+
+// NewCalculator returns a calculator for config. A nil config offers no methods.
+func NewCalculator(config *Config) *Calculator {
+	if config == nil {
+		config = &Config{}
+	}
+	return &Calculator{config: config}
+}
+
+// DefaultMethod is the method used when the customer does not choose one
+func (c *Calculator) DefaultMethod() string {
+	return c.config.DefaultMethod
+}
+
+// Item is a quantity of one product in the parcel
+type Item struct {
+	Quantity    int32
+	WeightGrams int32
+	LengthCm    float64
+	WidthCm     float64
+	HeightCm    float64
+}
+
+// Quote is the price of one method for a parcel
+type Quote struct {
+	Method  string
+	Name    string
+	Price   money.Money
+	MinDays int32
+	MaxDays int32
+}
+
+// NotAvailableError is returned by Price when a method cannot deliver the parcel
+type NotAvailableError struct {
+	Method string
+	Reason string
+}
+
+func (e *NotAvailableError) Error() string {
+	return fmt.Sprintf("shipping method %s is not available: %s", e.Method, e.Reason)
+}
+
+// Price quotes one method for items shipped to country, in the currency of orderValue.
+// country is ignored by methods that need no address. orderValue is the order subtotal
+// compared to MinOrderValue. rates convert from the currency of the rates file.
+func (c *Calculator) Price(method string, country string, items []Item, orderValue money.Money, rates *money.Rates) (*Quote, error) {
+	m := c.method(method)
+	if m == nil {
+		return nil, &NotAvailableError{Method: method, Reason: "unknown method"}
+	}
+
+	zone := AnyZone
+	if !m.NoAddress {
+		if country == "" {
+			return nil, &NotAvailableError{Method: method, Reason: "a shipping address is required"}
+		}
+		zone = c.zone(country)
+		if zone == "" {
+			return nil, &NotAvailableError{Method: method, Reason: fmt.Sprintf("no delivery to %s", strings.ToUpper(country))}
+		}
+	}
+
+	weight := billableWeight(m, items)
+	value, err := rates.Convert(orderValue.Minor, orderValue.Currency, c.config.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cheapest row for the zone that takes the weight and the order value
+	var best *Rate
+	for i := range m.Rates {
+		rate := &m.Rates[i]
+		if rate.Zone != zone && rate.Zone != AnyZone {
+			continue
+		}
+		if rate.MaxWeightGrams != 0 && weight > rate.MaxWeightGrams {
+			continue
+		}
+		if value < money.FromFloat(rate.MinOrderValue, c.config.Currency) {
+			continue
+		}
+		if best == nil || rate.Price < best.Price {
+			best = rate
+		}
+	}
+	if best == nil {
+		return nil, &NotAvailableError{Method: method, Reason: fmt.Sprintf("no rate for %d g to zone %s", weight, zone)}
+	}
+
+	price, err := rates.Convert(money.FromFloat(best.Price, c.config.Currency), c.config.Currency, orderValue.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &Quote{
+		Method:  m.Code,
+		Name:    m.Name,
+		Price:   money.Money{Minor: price, Currency: orderValue.Currency},
+		MinDays: m.MinDays,
+		MaxDays: m.MaxDays,
+	}, nil
+}
+
+// Quotes prices every method available for the parcel, cheapest first
+func (c *Calculator) Quotes(country string, items []Item, orderValue money.Money, rates *money.Rates) []Quote {
+	var quotes []Quote
+	for _, m := range c.config.Methods {
+		quote, err := c.Price(m.Code, country, items, orderValue, rates)
+		if err != nil {
+			continue
+		}
+		quotes = append(quotes, *quote)
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].Price.Minor < quotes[j].Price.Minor })
+	return quotes
+}
+
+func (c *Calculator) method(code string) *Method {
+	for i := range c.config.Methods {
+		if c.config.Methods[i].Code == code {
+			return &c.config.Methods[i]
+		}
+	}
+	return nil
+}
+
+// zone returns the zone of a country, or "" if no zone covers it
+func (c *Calculator) zone(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	fallback := ""
+	for _, zone := range c.config.Zones {
+		for _, code := range zone.Countries {
+			if strings.ToUpper(code) == country {
+				return zone.Name
+			}
+			if code == AnyZone {
+				fallback = zone.Name
+			}
+		}
+	}
+	return fallback
+}
+
+// billableWeight is the larger of the actual and the volumetric weight, in grams
+func billableWeight(m *Method, items []Item) int32 {
+	var grams int64
+	var volume float64
+	for _, item := range items {
+		grams += int64(item.WeightGrams) * int64(item.Quantity)
+		volume += item.LengthCm * item.WidthCm * item.HeightCm * float64(item.Quantity)
+	}
+	if m.VolumetricDivisor > 0 {
+		if volumetric := int64(math.Ceil(volume / m.VolumetricDivisor * 1000)); volumetric > grams {
+			grams = volumetric
+		}
+	}
+	if grams > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(grams)
+}
@@ -0,0 +1,228 @@
+// Package tax computes the tax on order lines from rules kept in a local JSON file.
+//
+// Rules are grouped by jurisdiction (a country, optionally narrowed to a region).
+// Each jurisdiction says whether its prices already include tax and lists one or
+// more taxes, e.g. a federal and a provincial sales tax, with a rate per product
+// tax category.
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"shared/money"
+)
+
+// DefaultCategory is used for products without a tax category
+const DefaultCategory = "standard"
+
+// Rules is the content of the tax rules file
+type Rules struct {
+	Jurisdictions []Jurisdiction `json:"jurisdictions"`
+}
+
+// Jurisdiction holds the taxes of a country, or of one region of it when Region is set
+type Jurisdiction struct {
+	Country          string `json:"country"`          // ISO 3166-1 alpha-2, e.g. "DE"
+	Region           string `json:"region,omitempty"` // e.g. "CA" for California, empty for the whole country
+	PricesIncludeTax bool   `json:"prices_include_tax"`
+	Taxes            []Tax  `json:"taxes"`
+}
+
+// Tax is one tax levied in a jurisdiction, with its rate per product tax category.
+// Categories without a rate are not taxed.
+type Tax struct {
+	Name  string             `json:"name"`
+	Rates map[string]float64 `json:"rates"` // e.g. {"standard": 0.19, "reduced": 0.07}
+}
+
+// LoadRules reads and validates a tax rules file
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tax rules: %v", err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse tax rules %s: %v", path, err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("invalid tax rules %s: %v", path, err)
+	}
+	return &rules, nil
+}
+
+func (r *Rules) validate() error {
+	seen := make(map[string]bool)
+	for i, j := range r.Jurisdictions {
+		if j.Country == "" {
+			return fmt.Errorf("jurisdiction %d has no country", i)
+		}
+		key := normalize(j.Country) + "/" + normalize(j.Region)
+		if seen[key] {
+			return fmt.Errorf("jurisdiction %s is listed more than once", key)
+		}
+		seen[key] = true
+
+		for _, t := range j.Taxes {
+			if t.Name == "" {
+				return fmt.Errorf("jurisdiction %s has a tax without a name", key)
+			}
+			for category, rate := range t.Rates {
+				if rate < 0 || rate >= 1 {
+					return fmt.Errorf("%s rate for %s in %s must be a fraction between 0 and 1", t.Name, category, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Calculator applies a set of rules
+type Calculator struct {
+	rules *Rules
+}
+
+// NewCalculator returns a calculator for rules. A nil rules charges no tax anywhere.
+func NewCalculator(rules *Rules) *Calculator {
+	if rules == nil {
+		rules = &Rules{}
+	}
+	return &Calculator{rules: rules}
+}
+
+// Line is an amount to tax in minor units, normally an order line after discounts
+type Line struct {
+	Category string
+	Amount   int64
+}
+
+// LineResult is the tax on one Line. Taxes has an entry per tax that applies.
+type LineResult struct {
+	Tax   int64
+	Taxes []Amount
+}
+
+// Amount is the part of a tax charged on some taxable amount. Taxable is net of tax.
+type Amount struct {
+	Name    string
+	Rate    float64
+	Taxable int64
+	Tax     int64
+}
+
+// Result is the tax on a whole order
+type Result struct {
+	Jurisdiction     string // "COUNTRY" or "COUNTRY/REGION", empty when no rule matched
+	PricesIncludeTax bool
+	Lines            []LineResult // in the order of the lines given
+	Tax              int64
+	Breakdown        []Amount // totals per tax and rate, in the order the rules list them
+}
+
+// Calculate works out the tax on lines shipped to country and region. The region
+// rule wins over the country rule; without either no tax is charged. When prices
+// include tax the tax is extracted from the line amounts, otherwise it is added on top.
+// Tax is rounded to minor units per line and tax.
+func (c *Calculator) Calculate(country, region string, lines []Line) *Result {
+	result := &Result{Lines: make([]LineResult, len(lines))}
+
+	j := c.find(country, region)
+	if j == nil {
+		return result
+	}
+	result.Jurisdiction = normalize(j.Country)
+	if j.Region != "" {
+		result.Jurisdiction += "/" + normalize(j.Region)
+	}
+	result.PricesIncludeTax = j.PricesIncludeTax
+
+	type breakdownKey struct {
+		name string
+		rate float64
+	}
+	totals := make(map[breakdownKey]*Amount)
+	var order []breakdownKey
+
+	for i, line := range lines {
+		category := line.Category
+		if category == "" {
+			category = DefaultCategory
+		}
+
+		combined := new(big.Rat)
+		for _, t := range j.Taxes {
+			combined.Add(combined, money.RatFromFloat(t.Rates[category]))
+		}
+		if combined.Sign() == 0 || line.Amount <= 0 {
+			continue
+		}
+
+		net := line.Amount
+		if j.PricesIncludeTax {
+			net = money.MulRat(line.Amount, new(big.Rat).Inv(combined.Add(combined, big.NewRat(1, 1))))
+		}
+
+		lr := &result.Lines[i]
+		for _, t := range j.Taxes {
+			rate := t.Rates[category]
+			if rate == 0 {
+				continue
+			}
+			amount := Amount{Name: t.Name, Rate: rate, Taxable: net, Tax: money.MulRat(net, money.RatFromFloat(rate))}
+			lr.Taxes = append(lr.Taxes, amount)
+			lr.Tax += amount.Tax
+		}
+		if j.PricesIncludeTax {
+			// The last tax takes the rounding difference, so net and tax add up to the price
+			last := &lr.Taxes[len(lr.Taxes)-1]
+			last.Tax += line.Amount - net - lr.Tax
+			lr.Tax = line.Amount - net
+		}
+		result.Tax += lr.Tax
+
+		for _, amount := range lr.Taxes {
+			key := breakdownKey{amount.Name, amount.Rate}
+			if totals[key] == nil {
+				totals[key] = &Amount{Name: amount.Name, Rate: amount.Rate}
+				order = append(order, key)
+			}
+			totals[key].Taxable += amount.Taxable
+			totals[key].Tax += amount.Tax
+		}
+	}
+
+	for _, key := range order {
+		result.Breakdown = append(result.Breakdown, *totals[key])
+	}
+	return result
+}
+
+func (c *Calculator) find(country, region string) *Jurisdiction {
+	country, region = normalize(country), normalize(region)
+	if country == "" {
+		return nil
+	}
+
+	var countryRule *Jurisdiction
+	for i := range c.rules.Jurisdictions {
+		j := &c.rules.Jurisdictions[i]
+		if normalize(j.Country) != country {
+			continue
+		}
+		if j.Region == "" {
+			countryRule = j
+		} else if region != "" && normalize(j.Region) == region {
+			return j
+		}
+	}
+	return countryRule
+}
+
+func normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,202 @@
+package tax
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRules covers tax included in prices (DE), a region without a country rule
+// (US/CA), a region overriding its country (CA/BC) and two taxes on one line
+var testRules = &Rules{Jurisdictions: []Jurisdiction{
+	{Country: "DE", PricesIncludeTax: true, Taxes: []Tax{
+		{Name: "VAT", Rates: map[string]float64{"standard": 0.19, "reduced": 0.07}},
+	}},
+	{Country: "US", Region: "CA", Taxes: []Tax{
+		{Name: "Sales Tax", Rates: map[string]float64{"standard": 0.0725}},
+	}},
+	{Country: "CA", Taxes: []Tax{
+		{Name: "GST", Rates: map[string]float64{"standard": 0.05, "books": 0.05}},
+	}},
+	{Country: "CA", Region: "BC", Taxes: []Tax{
+		{Name: "GST", Rates: map[string]float64{"standard": 0.05, "books": 0.05}},
+		{Name: "PST", Rates: map[string]float64{"standard": 0.07}},
+	}},
+}}
+
+func TestCalculateLine(t *testing.T) {
+	tests := []struct {
+		name             string
+		country, region  string
+		line             Line
+		wantJurisdiction string
+		wantTaxes        []Amount
+	}{
+		{
+			name: "included in the price", country: "DE", line: Line{"standard", 11900},
+			wantJurisdiction: "DE",
+			wantTaxes:        []Amount{{Name: "VAT", Rate: 0.19, Taxable: 10000, Tax: 1900}},
+		},
+		{
+			name: "included, reduced rate", country: "DE", line: Line{"reduced", 1070},
+			wantJurisdiction: "DE",
+			wantTaxes:        []Amount{{Name: "VAT", Rate: 0.07, Taxable: 1000, Tax: 70}},
+		},
+		{
+			// 999 / 1.19 = 839.496 net, and 19% of 839 rounds to 159; the tax takes the missing cent
+			name: "included, net and tax add up to the price", country: "DE", line: Line{"standard", 999},
+			wantJurisdiction: "DE",
+			wantTaxes:        []Amount{{Name: "VAT", Rate: 0.19, Taxable: 839, Tax: 160}},
+		},
+		{
+			name: "added on top", country: "US", region: "CA", line: Line{"standard", 1999},
+			wantJurisdiction: "US/CA",
+			wantTaxes:        []Amount{{Name: "Sales Tax", Rate: 0.0725, Taxable: 1999, Tax: 145}}, // 144.93
+		},
+		{
+			name: "codes are normalized", country: " us ", region: "ca", line: Line{"standard", 1999},
+			wantJurisdiction: "US/CA",
+			wantTaxes:        []Amount{{Name: "Sales Tax", Rate: 0.0725, Taxable: 1999, Tax: 145}},
+		},
+		{
+			name: "empty category is standard", country: "US", region: "CA", line: Line{"", 1000},
+			wantJurisdiction: "US/CA",
+			wantTaxes:        []Amount{{Name: "Sales Tax", Rate: 0.0725, Taxable: 1000, Tax: 73}}, // 72.5
+		},
+		{
+			name: "region without a rule and no country rule", country: "US", region: "NY", line: Line{"standard", 1999},
+		},
+		{
+			name: "unknown country", country: "FR", line: Line{"standard", 1999},
+		},
+		{
+			name: "no address", line: Line{"standard", 1999},
+		},
+		{
+			name: "category without a rate", country: "US", region: "CA", line: Line{"books", 1999},
+			wantJurisdiction: "US/CA",
+		},
+		{
+			name: "nothing to tax", country: "DE", line: Line{"standard", 0},
+			wantJurisdiction: "DE",
+		},
+		{
+			name: "two taxes", country: "CA", region: "BC", line: Line{"standard", 10000},
+			wantJurisdiction: "CA/BC",
+			wantTaxes: []Amount{
+				{Name: "GST", Rate: 0.05, Taxable: 10000, Tax: 500},
+				{Name: "PST", Rate: 0.07, Taxable: 10000, Tax: 700},
+			},
+		},
+		{
+			name: "region rule wins", country: "CA", region: "BC", line: Line{"books", 10000},
+			wantJurisdiction: "CA/BC",
+			wantTaxes:        []Amount{{Name: "GST", Rate: 0.05, Taxable: 10000, Tax: 500}},
+		},
+		{
+			name: "country rule for other regions", country: "CA", region: "ON", line: Line{"standard", 10000},
+			wantJurisdiction: "CA",
+			wantTaxes:        []Amount{{Name: "GST", Rate: 0.05, Taxable: 10000, Tax: 500}},
+		},
+	}
+
+	calc := NewCalculator(testRules)
+	for _, tt := range tests {
+		result := calc.Calculate(tt.country, tt.region, []Line{tt.line})
+		if result.Jurisdiction != tt.wantJurisdiction {
+			t.Errorf("%s: jurisdiction %q, want %q", tt.name, result.Jurisdiction, tt.wantJurisdiction)
+		}
+		got := result.Lines[0].Taxes
+		if !reflect.DeepEqual(got, tt.wantTaxes) {
+			t.Errorf("%s: taxes %+v, want %+v", tt.name, got, tt.wantTaxes)
+		}
+		var want int64
+		for _, amount := range tt.wantTaxes {
+			want += amount.Tax
+		}
+		if result.Lines[0].Tax != want || result.Tax != want {
+			t.Errorf("%s: line tax %d, order tax %d, want %d", tt.name, result.Lines[0].Tax, result.Tax, want)
+		}
+	}
+}
+
+func TestCalculateBreakdown(t *testing.T) {
+	result := NewCalculator(testRules).Calculate("CA", "BC", []Line{
+		{"standard", 10000},
+		{"books", 2000},
+		{"standard", 333},
+	})
+
+	wantLines := []int64{1200, 100, 40} // 333: 16.65 GST + 23.31 PST round to 17 + 23
+	for i, want := range wantLines {
+		if result.Lines[i].Tax != want {
+			t.Errorf("line %d: tax %d, want %d", i, result.Lines[i].Tax, want)
+		}
+	}
+	if result.Tax != 1340 {
+		t.Errorf("order tax %d, want 1340", result.Tax)
+	}
+
+	wantBreakdown := []Amount{
+		{Name: "GST", Rate: 0.05, Taxable: 12333, Tax: 617},
+		{Name: "PST", Rate: 0.07, Taxable: 10333, Tax: 723},
+	}
+	if !reflect.DeepEqual(result.Breakdown, wantBreakdown) {
+		t.Errorf("breakdown %+v, want %+v", result.Breakdown, wantBreakdown)
+	}
+}
+
+func TestCalculateWithoutRules(t *testing.T) {
+	result := NewCalculator(nil).Calculate("DE", "", []Line{{"standard", 11900}})
+	if result.Tax != 0 || result.Jurisdiction != "" || len(result.Lines) != 1 {
+		t.Errorf("got %+v, want no tax", result)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		wantErr string // empty if valid
+	}{
+		{"valid", *testRules, ""},
+		{"no country", Rules{Jurisdictions: []Jurisdiction{{Region: "CA"}}}, "has no country"},
+		{
+			"duplicate", Rules{Jurisdictions: []Jurisdiction{{Country: "US", Region: "CA"}, {Country: "us", Region: " ca"}}},
+			"more than once",
+		},
+		{
+			"tax without a name", Rules{Jurisdictions: []Jurisdiction{{Country: "DE", Taxes: []Tax{{Rates: map[string]float64{"standard": 0.19}}}}}},
+			"without a name",
+		},
+		{
+			"rate as a percentage", Rules{Jurisdictions: []Jurisdiction{{Country: "DE", Taxes: []Tax{{Name: "VAT", Rates: map[string]float64{"standard": 19}}}}}},
+			"between 0 and 1",
+		},
+		{
+			"negative rate", Rules{Jurisdictions: []Jurisdiction{{Country: "DE", Taxes: []Tax{{Name: "VAT", Rates: map[string]float64{"standard": -0.19}}}}}},
+			"between 0 and 1",
+		},
+	}
+	for _, tt := range tests {
+		err := tt.rules.validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%s: no error, want %q", tt.name, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%s: error %q, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadShippedRules(t *testing.T) {
+	rules, err := LoadRules("../config/tax_rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Jurisdictions) == 0 {
+		t.Error("config/tax_rules.json lists no jurisdictions")
+	}
+}
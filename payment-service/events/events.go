@@ -0,0 +1,45 @@
+// Package events publishes and consumes the events of the shared catalogue as
+// payment-service
+package events
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	catalogue "shared/events"
+	"shared/messaging"
+)
+
+// Source identifies this service as the producer of the events it publishes
+const Source = "payment-service"
+
+// OutboxTable holds the payment events until the outbox relay publishes them, apart
+// from the outbox of order-service, which shares the database
+const OutboxTable = "payment_outbox_events"
+
+// New wraps data in an envelope for eventType, checking that data is the payload
+// the catalogue lists for it
+func New(ctx context.Context, eventType string, data proto.Message) (*messaging.Envelope, error) {
+	return catalogue.New(ctx, Source, eventType, data)
+}
+
+// Enqueue writes an event to the outbox as part of tx. Its correlation ID comes from
+// the context of tx.
+func Enqueue(tx *gorm.DB, exchange, eventType string, data proto.Message) error {
+	envelope, err := New(tx.Statement.Context, eventType, data)
+	if err != nil {
+		return err
+	}
+	return messaging.EnqueueEvent(tx, OutboxTable, exchange, eventType, envelope)
+}
+
+// Handle registers handle on q for eventType, accepting schema versions up to the
+// one in the catalogue
+func Handle[T any, P interface {
+	*T
+	proto.Message
+}](q *messaging.Queue, eventType string, handle func(ctx context.Context, env *messaging.Envelope, event P) error) {
+	catalogue.Handle(q, eventType, handle)
+}
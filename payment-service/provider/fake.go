@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// Fake settles payments without a payment processor, for local runs. The outcome
+// only depends on the request: amounts above DeclineAbove are declined as if the
+// card limit was reached and everything else succeeds. Refunds always succeed.
+// Intent and refund IDs are derived from the idempotency key, so a retry gets the
+// same intent or refund back.
+type Fake struct {
+	DeclineAbove int64 // minor units in any currency, 0 to accept every amount
+}
+
+// NewFake configures a Fake from the decline_above option
+func NewFake(options map[string]string) (*Fake, error) {
+	fake := &Fake{}
+	if value := options["decline_above"]; value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid decline_above %q", value)
+		}
+		fake.DeclineAbove = limit
+	}
+	return fake, nil
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	if req.IdempotencyKey == "" {
+		return nil, fmt.Errorf("idempotency key required")
+	}
+
+	sum := sha256.Sum256([]byte(req.IdempotencyKey))
+	intent := &Intent{
+		ID:     "fake_pi_" + hex.EncodeToString(sum[:12]),
+		Status: IntentSucceeded,
+	}
+	if f.DeclineAbove > 0 && req.Amount > f.DeclineAbove {
+		intent.Status = IntentFailed
+		intent.FailureReason = "card_declined"
+	}
+	return intent, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	if req.IdempotencyKey == "" || req.IntentID == "" {
+		return nil, fmt.Errorf("idempotency key and intent ID required")
+	}
+
+	sum := sha256.Sum256([]byte(req.IdempotencyKey))
+	return &Refund{ID: "fake_re_" + hex.EncodeToString(sum[:12])}, nil
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFakeOutcomes(t *testing.T) {
+	fake, err := NewFake(map[string]string{"decline_above": "10000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		key        string
+		amount     int64
+		wantStatus string
+		wantReason string
+	}{
+		{"pay1", 1999, IntentSucceeded, ""},
+		{"pay2", 10000, IntentSucceeded, ""},
+		{"pay3", 10001, IntentFailed, "card_declined"},
+	}
+	ids := map[string]bool{}
+	for _, tt := range tests {
+		req := IntentRequest{IdempotencyKey: tt.key, OrderID: "o1", Amount: tt.amount, Currency: "USD"}
+		intent, err := fake.CreateIntent(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if intent.Status != tt.wantStatus || intent.FailureReason != tt.wantReason {
+			t.Errorf("intent of %d is %s (%q), want %s (%q)", tt.amount, intent.Status, intent.FailureReason, tt.wantStatus, tt.wantReason)
+		}
+		// A retry with the same key gets the same intent
+		again, err := fake.CreateIntent(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *again != *intent {
+			t.Errorf("retry of %s got %+v, want %+v", tt.key, again, intent)
+		}
+		if ids[intent.ID] {
+			t.Errorf("intent ID %s handed out for two keys", intent.ID)
+		}
+		ids[intent.ID] = true
+	}
+
+	refund, err := fake.Refund(ctx, RefundRequest{IdempotencyKey: "pay1:refund", IntentID: "fake_pi_1", Amount: 1999, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := fake.Refund(ctx, RefundRequest{IdempotencyKey: "pay1:refund", IntentID: "fake_pi_1", Amount: 1999, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.ID == "" || again.ID != refund.ID {
+		t.Errorf("refunds %q and %q for the same key, want the same ID", refund.ID, again.ID)
+	}
+
+	if _, err := fake.CreateIntent(ctx, IntentRequest{Amount: 100, Currency: "USD"}); err == nil {
+		t.Error("intent without idempotency key accepted")
+	}
+	if _, err := fake.Refund(ctx, RefundRequest{IdempotencyKey: "pay1:refund"}); err == nil {
+		t.Error("refund without intent accepted")
+	}
+}
+
+func TestNewFake(t *testing.T) {
+	fake, err := NewFake(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a limit every amount is accepted
+	intent, err := fake.CreateIntent(context.Background(), IntentRequest{IdempotencyKey: "k", Amount: 1 << 40, Currency: "USD"})
+	if err != nil || intent.Status != IntentSucceeded {
+		t.Errorf("intent %+v (error %v), want succeeded", intent, err)
+	}
+
+	for _, value := range []string{"-1", "ten"} {
+		if _, err := NewFake(map[string]string{"decline_above": value}); err == nil {
+			t.Errorf("decline_above %q accepted", value)
+		}
+	}
+}
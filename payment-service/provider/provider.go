@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+)
+
+// Intent statuses
+const (
+	IntentSucceeded = "succeeded"
+	IntentFailed    = "failed"
+)
+
+// IntentRequest asks for an amount to be charged for an order
+type IntentRequest struct {
+	// Retrying with the same key returns the first intent instead of charging again
+	IdempotencyKey string
+	OrderID        string
+	UserID         string
+	Amount         int64 // minor units of Currency
+	Currency       string
+}
+
+// Intent is the outcome of a payment attempt at the provider
+type Intent struct {
+	ID            string // the provider's reference
+	Status        string
+	FailureReason string // why the payment was declined, e.g. card_declined
+}
+
+// RefundRequest asks for a succeeded intent to be paid back in full
+type RefundRequest struct {
+	// Retrying with the same key returns the first refund instead of refunding again
+	IdempotencyKey string
+	IntentID       string
+	Amount         int64 // minor units of Currency
+	Currency       string
+}
+
+// Refund is a refund issued by the provider
+type Refund struct {
+	ID string // the provider's reference
+}
+
+// Provider takes payments through a payment processor. CreateIntent creates and
+// confirms a payment intent. A declined payment is an Intent with status failed;
+// an error means the outcome is unknown and the call may be retried with the same
+// IdempotencyKey. Refund pays a succeeded intent back, with the same rules for
+// errors.
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+}
+
+// New returns the provider called name, configured from options
+func New(name string, options map[string]string) (Provider, error) {
+	switch name {
+	case "fake":
+		return NewFake(options)
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"payment-service/events"
+	"payment-service/provider"
+	"shared/messaging"
+	pb "shared/proto"
+)
+
+// Payment statuses stored in Payment.Status
+const (
+	StatusPending   = "pending" // not settled by the provider yet
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRefunded  = "refunded"  // paid back after its order was cancelled
+	StatusCancelled = "cancelled" // its order was cancelled before it was charged
+)
+
+// paymentEventsExchange is the topic exchange all payment events are published to
+const paymentEventsExchange = "payment_events"
+
+// orderEventsExchange is the topic exchange order-service publishes to
+const orderEventsExchange = "order_events"
+
+// orderEventsQueue receives the order events payment-service acts on
+const orderEventsQueue = "payment-service.orders"
+
+// maxAttempts is how often the provider is tried before a payment is failed
+const maxAttempts = 10
+
+// providerTimeout bounds every call to the provider
+const providerTimeout = 30 * time.Second
+
+// Payment is the payment of one order
+type Payment struct {
+	ID            string `gorm:"primaryKey;type:varchar(255)"`
+	OrderID       string `gorm:"not null;type:varchar(255);uniqueIndex"`
+	UserID        string `gorm:"not null;type:varchar(255);index"`
+	Amount        int64  `gorm:"column:amount_minor;not null"` // Minor units of Currency
+	Currency      string `gorm:"not null;type:varchar(3)"`
+	Provider      string `gorm:"not null;type:varchar(50)"`
+	IntentID      string `gorm:"type:varchar(255)"` // The provider's reference
+	Status        string `gorm:"not null;type:varchar(50);index"`
+	FailureReason string `gorm:"type:text"`
+	Attempts      int    `gorm:"not null;default:0"`
+	LastError     string `gorm:"type:text"`
+	// The order was cancelled while the payment was pending; it is refunded if it succeeds
+	RefundRequested bool   `gorm:"not null;default:false"`
+	RefundID        string `gorm:"type:varchar(255)"` // The provider's reference for the refund
+	CreatedAt       int64  `gorm:"autoCreateTime"`
+	UpdatedAt       int64  `gorm:"autoUpdateTime"`
+}
+
+type PaymentService struct {
+	db          *gorm.DB
+	provider    provider.Provider
+	outboxRelay *messaging.OutboxRelay
+}
+
+func NewPaymentService(db *gorm.DB, provider provider.Provider, outboxRelay *messaging.OutboxRelay) *PaymentService {
+	return &PaymentService{
+		db:          db,
+		provider:    provider,
+		outboxRelay: outboxRelay,
+	}
+}
+
+// ConsumeOrderEvents starts taking payment for every new order and paying back the
+// payments of cancelled orders. The queue gives up on an event long after settle has
+// given up on its payment.
+func (s *PaymentService) ConsumeOrderEvents(ctx context.Context, broker messaging.Broker) error {
+	queue := &messaging.Queue{Name: orderEventsQueue, Exchange: orderEventsExchange, MaxAttempts: 2 * maxAttempts}
+	events.Handle(queue, "order.created", s.handleOrderCreated)
+	events.Handle(queue, "order.cancelled", s.handleOrderCancelled)
+	return broker.Consume(ctx, queue)
+}
+
+// handleOrderCreated takes the payment for a new order and publishes payment.succeeded
+// or payment.failed. Redelivered events are safe: there is one payment per order, and
+// the provider gets the payment ID as idempotency key so it never charges twice. A
+// payment whose order was cancelled while it was being settled is refunded here.
+func (s *PaymentService) handleOrderCreated(ctx context.Context, env *messaging.Envelope, event *pb.OrderCreatedEvent) error {
+	if event.OrderId == "" || event.Currency == "" || event.TotalMinor < 0 {
+		return messaging.Permanent(fmt.Errorf("order ID, currency and a total required"))
+	}
+
+	payment, err := s.startPayment(ctx, event)
+	if err != nil {
+		return err
+	}
+	if payment.Status == StatusPending {
+		if err := s.settle(ctx, payment); err != nil {
+			return err
+		}
+	}
+	if payment.Status == StatusSucceeded && payment.RefundRequested {
+		return s.refund(ctx, payment)
+	}
+	return nil
+}
+
+// handleOrderCancelled pays back the payment of a cancelled order, whatever the
+// reason: the customer or an admin cancelled it, it expired, or order-service
+// rejected the payment. A payment still being settled is flagged and refunded by
+// handleOrderCreated once it succeeds. An order cancelled before its payment was
+// started gets a cancelled payment, so it is never charged.
+func (s *PaymentService) handleOrderCancelled(ctx context.Context, env *messaging.Envelope, event *pb.OrderCancelledEvent) error {
+	if event.OrderId == "" {
+		return messaging.Permanent(fmt.Errorf("order ID missing"))
+	}
+
+	var payment Payment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ?", event.OrderId).Limit(1).Find(&payment)
+		if result.Error != nil {
+			return fmt.Errorf("database error: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			payment = Payment{
+				ID:       generateID(),
+				OrderID:  event.OrderId,
+				UserID:   event.UserId,
+				Amount:   event.TotalMinor,
+				Currency: event.Currency,
+				Provider: s.provider.Name(),
+				Status:   StatusCancelled,
+			}
+			if err := tx.Create(&payment).Error; err != nil {
+				return fmt.Errorf("failed to create payment: %v", err)
+			}
+			return nil
+		}
+		if payment.Status == StatusPending && !payment.RefundRequested {
+			payment.RefundRequested = true
+			if err := tx.Model(&payment).Update("refund_requested", true).Error; err != nil {
+				return fmt.Errorf("failed to update payment: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if payment.Status != StatusSucceeded {
+		return nil
+	}
+	return s.refund(ctx, &payment)
+}
+
+// startPayment returns the payment of the order, creating it if this is the first
+// delivery of its event
+func (s *PaymentService) startPayment(ctx context.Context, event *pb.OrderCreatedEvent) (*Payment, error) {
+	payment := &Payment{
+		ID:       generateID(),
+		OrderID:  event.OrderId,
+		UserID:   event.UserId,
+		Amount:   event.TotalMinor,
+		Currency: event.Currency,
+		Provider: s.provider.Name(),
+		Status:   StatusPending,
+	}
+	db := s.db.WithContext(ctx)
+	result := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "order_id"}}, DoNothing: true}).Create(payment)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create payment: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// Read into a fresh struct, the generated ID would otherwise be part of the query
+		var existing Payment
+		if err := db.Where("order_id = ?", event.OrderId).First(&existing).Error; err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		return &existing, nil
+	}
+	return payment, nil
+}
+
+// settle asks the provider for the payment and records the outcome. An error leaves
+// the payment pending for the next delivery to retry, up to maxAttempts.
+func (s *PaymentService) settle(ctx context.Context, payment *Payment) error {
+	// Nothing to charge, e.g. when a coupon covers the whole order
+	if payment.Amount == 0 {
+		return s.finish(ctx, payment, &provider.Intent{Status: provider.IntentSucceeded})
+	}
+
+	payment.Attempts++
+	callCtx, cancel := context.WithTimeout(ctx, providerTimeout)
+	intent, err := s.provider.CreateIntent(callCtx, provider.IntentRequest{
+		IdempotencyKey: payment.ID,
+		OrderID:        payment.OrderID,
+		UserID:         payment.UserID,
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+	})
+	cancel()
+	if err != nil {
+		if payment.Attempts >= maxAttempts {
+			log.Printf("Giving up on payment %s after %d attempts: %v", payment.ID, payment.Attempts, err)
+			return s.finish(ctx, payment, &provider.Intent{Status: provider.IntentFailed, FailureReason: "provider_unavailable"})
+		}
+		updates := map[string]interface{}{"attempts": payment.Attempts, "last_error": err.Error()}
+		if uerr := s.db.WithContext(ctx).Model(payment).Updates(updates).Error; uerr != nil {
+			log.Printf("Failed to record error for payment %s: %v", payment.ID, uerr)
+		}
+		return fmt.Errorf("payment %s: %v", payment.ID, err)
+	}
+	return s.finish(ctx, payment, intent)
+}
+
+// finish records the outcome of a payment and its event in one transaction, and
+// reads whether the order was cancelled in the meantime
+func (s *PaymentService) finish(ctx context.Context, payment *Payment, intent *provider.Intent) error {
+	payment.IntentID = intent.ID
+	payment.Status = StatusSucceeded
+	routingKey := "payment.succeeded"
+	if intent.Status != provider.IntentSucceeded {
+		payment.Status = StatusFailed
+		payment.FailureReason = intent.FailureReason
+		routingKey = "payment.failed"
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"intent_id":      payment.IntentID,
+			"status":         payment.Status,
+			"failure_reason": payment.FailureReason,
+			"attempts":       payment.Attempts,
+		}
+		if err := tx.Model(payment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update payment: %v", err)
+		}
+		// The update holds the row lock, so a cancellation either committed before it
+		// and is seen here, or waits and finds the payment settled
+		var current Payment
+		if err := tx.Select("refund_requested").Where("id = ?", payment.ID).Take(&current).Error; err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		payment.RefundRequested = current.RefundRequested
+
+		event := &pb.PaymentSettledEvent{
+			PaymentId:   payment.ID,
+			OrderId:     payment.OrderID,
+			UserId:      payment.UserID,
+			Currency:    payment.Currency,
+			AmountMinor: payment.Amount,
+			Provider:    payment.Provider,
+			IntentId:    payment.IntentID,
+			Status:      payment.Status,
+			Reason:      payment.FailureReason,
+		}
+		return events.Enqueue(tx, paymentEventsExchange, routingKey, event)
+	})
+	if err != nil {
+		return err
+	}
+	s.outboxRelay.Wake()
+
+	log.Printf("Payment %s for order %s %s", payment.ID, payment.OrderID, payment.Status)
+	return nil
+}
+
+// refund pays a succeeded payment back in full. An error leaves the payment
+// succeeded for the next delivery of the event to retry; the provider gets the same
+// idempotency key every time so it never refunds twice.
+func (s *PaymentService) refund(ctx context.Context, payment *Payment) error {
+	// Nothing was charged, so there is nothing to pay back
+	if payment.Amount > 0 {
+		callCtx, cancel := context.WithTimeout(ctx, providerTimeout)
+		refund, err := s.provider.Refund(callCtx, provider.RefundRequest{
+			IdempotencyKey: payment.ID + ":refund",
+			IntentID:       payment.IntentID,
+			Amount:         payment.Amount,
+			Currency:       payment.Currency,
+		})
+		cancel()
+		if err != nil {
+			if uerr := s.db.WithContext(ctx).Model(payment).Update("last_error", err.Error()).Error; uerr != nil {
+				log.Printf("Failed to record error for payment %s: %v", payment.ID, uerr)
+			}
+			return fmt.Errorf("refund of payment %s: %v", payment.ID, err)
+		}
+		payment.RefundID = refund.ID
+	}
+
+	payment.Status = StatusRefunded
+	updates := map[string]interface{}{"status": payment.Status, "refund_id": payment.RefundID}
+	result := s.db.WithContext(ctx).Model(payment).Where("status = ?", StatusSucceeded).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update payment: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Payment %s for cancelled order %s refunded", payment.ID, payment.OrderID)
+	}
+	return nil
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
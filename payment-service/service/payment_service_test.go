@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"payment-service/events"
+	"payment-service/provider"
+	"shared/messaging"
+	pb "shared/proto"
+)
+
+// declineAbove is the amount above which the test provider declines payments
+const declineAbove = 10000
+
+// flakyProvider is the fake provider, failing every call while err is set
+type flakyProvider struct {
+	*provider.Fake
+	err     error
+	refunds int
+}
+
+func (p *flakyProvider) CreateIntent(ctx context.Context, req provider.IntentRequest) (*provider.Intent, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.Fake.CreateIntent(ctx, req)
+}
+
+func (p *flakyProvider) Refund(ctx context.Context, req provider.RefundRequest) (*provider.Refund, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.refunds++
+	return p.Fake.Refund(ctx, req)
+}
+
+// newTestService returns a PaymentService on an empty sqlite database that consumes
+// order events from a MemoryBroker
+func newTestService(t *testing.T) (*PaymentService, *flakyProvider, *messaging.MemoryBroker) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "payments.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Payment{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := messaging.MigrateOutbox(db, events.OutboxTable); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &flakyProvider{Fake: &provider.Fake{DeclineAbove: declineAbove}}
+	broker := messaging.NewMemoryBroker()
+	s := NewPaymentService(db, fake, messaging.NewOutboxRelay(db, events.OutboxTable, broker))
+	if err := s.ConsumeOrderEvents(context.Background(), broker); err != nil {
+		t.Fatal(err)
+	}
+	return s, fake, broker
+}
+
+func orderEvent(t *testing.T, eventType string, data proto.Message) *messaging.Envelope {
+	env, err := events.New(context.Background(), eventType, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func publish(t *testing.T, broker *messaging.MemoryBroker, env *messaging.Envelope) {
+	if err := broker.PublishEvent(orderEventsExchange, env.Type, env); err != nil {
+		t.Fatal(err)
+	}
+	broker.Drain()
+}
+
+// findPayment returns the payment of the order, nil if it has none
+func findPayment(t *testing.T, s *PaymentService, orderID string) *Payment {
+	var payments []Payment
+	if err := s.db.Where("order_id = ?", orderID).Find(&payments).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) == 0 {
+		return nil
+	}
+	return &payments[0]
+}
+
+// outboxEvents returns the routing keys written to the outbox, in order
+func outboxEvents(t *testing.T, s *PaymentService) []string {
+	var outbox []messaging.OutboxEvent
+	if err := s.db.Table(events.OutboxTable).Order("id").Find(&outbox).Error; err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, row := range outbox {
+		keys = append(keys, row.RoutingKey)
+	}
+	return keys
+}
+
+func TestOrderCreatedSettlesPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      *pb.OrderCreatedEvent
+		wantStatus string // no payment if empty
+		wantReason string
+		wantEvents []string
+		wantDead   bool
+	}{
+		{
+			name:       "paid",
+			event:      &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: 1999},
+			wantStatus: StatusSucceeded, wantEvents: []string{"payment.succeeded"},
+		},
+		{
+			name:       "declined",
+			event:      &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: declineAbove + 1},
+			wantStatus: StatusFailed, wantReason: "card_declined", wantEvents: []string{"payment.failed"},
+		},
+		{
+			name:       "nothing to charge",
+			event:      &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD"},
+			wantStatus: StatusSucceeded, wantEvents: []string{"payment.succeeded"},
+		},
+		{
+			name:     "no currency",
+			event:    &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", TotalMinor: 1999},
+			wantDead: true,
+		},
+	}
+
+	for _, tt := range tests {
+		s, fake, broker := newTestService(t)
+		env := orderEvent(t, "order.created", tt.event)
+
+		// A redelivered event settles the payment only once
+		publish(t, broker, env)
+		publish(t, broker, env)
+
+		if gotDead := len(broker.DeadLetters(orderEventsQueue)) > 0; gotDead != tt.wantDead {
+			t.Errorf("%s: dead-lettered %v, want %v", tt.name, gotDead, tt.wantDead)
+		}
+		if got := fmt.Sprint(outboxEvents(t, s)); got != fmt.Sprint(tt.wantEvents) {
+			t.Errorf("%s: outbox has %s, want %v", tt.name, got, tt.wantEvents)
+		}
+		payment := findPayment(t, s, "o1")
+		if tt.wantStatus == "" {
+			if payment != nil {
+				t.Errorf("%s: payment %+v, want none", tt.name, payment)
+			}
+			continue
+		}
+		if payment == nil {
+			t.Fatalf("%s: no payment", tt.name)
+		}
+		if payment.Status != tt.wantStatus || payment.FailureReason != tt.wantReason || payment.Amount != tt.event.TotalMinor {
+			t.Errorf("%s: payment %s (%q) of %d, want %s (%q) of %d", tt.name,
+				payment.Status, payment.FailureReason, payment.Amount, tt.wantStatus, tt.wantReason, tt.event.TotalMinor)
+		}
+
+		// The intent is the one the provider hands out for the payment's ID
+		if tt.event.TotalMinor == 0 {
+			if payment.IntentID != "" || payment.Attempts != 0 {
+				t.Errorf("%s: provider called for a free order, intent %q", tt.name, payment.IntentID)
+			}
+			continue
+		}
+		intent, err := fake.Fake.CreateIntent(context.Background(), provider.IntentRequest{IdempotencyKey: payment.ID, Amount: payment.Amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.IntentID != intent.ID || payment.Attempts != 1 {
+			t.Errorf("%s: intent %s after %d attempts, want %s after one", tt.name, payment.IntentID, payment.Attempts, intent.ID)
+		}
+	}
+}
+
+func TestOrderCreatedProviderUnavailable(t *testing.T) {
+	s, fake, broker := newTestService(t)
+	fake.err = errors.New("connection refused")
+
+	publish(t, broker, orderEvent(t, "order.created", &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: 1999}))
+
+	payment := findPayment(t, s, "o1")
+	if payment.Status != StatusFailed || payment.FailureReason != "provider_unavailable" || payment.Attempts != maxAttempts {
+		t.Errorf("payment %s (%q) after %d attempts, want failed as provider_unavailable after %d",
+			payment.Status, payment.FailureReason, payment.Attempts, maxAttempts)
+	}
+	if got := fmt.Sprint(outboxEvents(t, s)); got != "[payment.failed]" {
+		t.Errorf("outbox has %s, want payment.failed", got)
+	}
+	if dead := broker.DeadLetters(orderEventsQueue); len(dead) != 0 {
+		t.Errorf("%d events dead-lettered, want none", len(dead))
+	}
+}
+
+func TestOrderCancelledRefunds(t *testing.T) {
+	created := func(total int64) *pb.OrderCreatedEvent {
+		return &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: total}
+	}
+	cancelled := &pb.OrderCancelledEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: 1999, Reason: "customer"}
+
+	tests := []struct {
+		name        string
+		created     *pb.OrderCreatedEvent
+		createdLate bool // order.created arrives after order.cancelled
+		wantStatus  string
+		wantRefunds int
+		wantEvents  []string
+	}{
+		{"paid", created(1999), false, StatusRefunded, 1, []string{"payment.succeeded"}},
+		{"nothing charged", created(0), false, StatusRefunded, 0, []string{"payment.succeeded"}},
+		{"declined", created(declineAbove + 1), false, StatusFailed, 0, []string{"payment.failed"}},
+		{"cancelled before payment", created(1999), true, StatusCancelled, 0, nil},
+	}
+	for _, tt := range tests {
+		s, fake, broker := newTestService(t)
+		createdEnv := orderEvent(t, "order.created", tt.created)
+		cancelledEnv := orderEvent(t, "order.cancelled", cancelled)
+
+		if !tt.createdLate {
+			publish(t, broker, createdEnv)
+		}
+		publish(t, broker, cancelledEnv)
+		publish(t, broker, cancelledEnv)
+		if tt.createdLate {
+			publish(t, broker, createdEnv)
+		}
+
+		payment := findPayment(t, s, "o1")
+		if payment.Status != tt.wantStatus || fake.refunds != tt.wantRefunds {
+			t.Errorf("%s: payment %s after %d refunds, want %s after %d", tt.name, payment.Status, fake.refunds, tt.wantStatus, tt.wantRefunds)
+		}
+		if tt.wantRefunds > 0 && payment.RefundID == "" {
+			t.Errorf("%s: refunded payment has no refund ID", tt.name)
+		}
+		if got := fmt.Sprint(outboxEvents(t, s)); got != fmt.Sprint(tt.wantEvents) {
+			t.Errorf("%s: outbox has %s, want %v", tt.name, got, tt.wantEvents)
+		}
+	}
+}
+
+func TestOrderCancelledWhileSettling(t *testing.T) {
+	s, fake, _ := newTestService(t)
+	ctx := context.Background()
+	created := &pb.OrderCreatedEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: 1999}
+	createdEnv := orderEvent(t, "order.created", created)
+	cancelled := &pb.OrderCancelledEvent{OrderId: "o1", UserId: "u1", Currency: "USD", TotalMinor: 1999}
+
+	// The provider is down, so the payment stays pending when the order is cancelled
+	fake.err = errors.New("connection refused")
+	if err := s.handleOrderCreated(ctx, createdEnv, created); err == nil {
+		t.Fatal("order.created handled although the provider is down")
+	}
+	if err := s.handleOrderCancelled(ctx, orderEvent(t, "order.cancelled", cancelled), cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if payment := findPayment(t, s, "o1"); payment.Status != StatusPending || !payment.RefundRequested {
+		t.Fatalf("payment %s with refund requested %v, want pending with a refund requested", payment.Status, payment.RefundRequested)
+	}
+
+	// The redelivered order.created charges and then pays back at once
+	fake.err = nil
+	if err := s.handleOrderCreated(ctx, createdEnv, created); err != nil {
+		t.Fatal(err)
+	}
+	if payment := findPayment(t, s, "o1"); payment.Status != StatusRefunded || fake.refunds != 1 {
+		t.Errorf("payment %s after %d refunds, want refunded once", payment.Status, fake.refunds)
+	}
+}
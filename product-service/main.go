@@ -3,10 +3,12 @@ package main
 import (
 	"log"
 	"net"
+	"os"
 	"time"
 
 	pb "product-service/product-service/proto"
 	"product-service/service"
+	"shared/money"
 
 	"google.golang.org/grpc"
 )
@@ -20,6 +22,18 @@ func main() {
 	}
 	log.Println("Database connected successfully")
 
+	// Prices without a currency, and exchange rates, are in the base currency
+	baseCurrency := money.Normalize(os.Getenv("BASE_CURRENCY"))
+	if baseCurrency == "" {
+		baseCurrency = "USD"
+	}
+	if !money.IsValid(baseCurrency) {
+		log.Fatalf("Unsupported BASE_CURRENCY %q", baseCurrency)
+	}
+	if err := service.MigrateLegacyPrices(db, baseCurrency); err != nil {
+		log.Fatalf("Failed to migrate prices: %v", err)
+	}
+
 	// Wait a bit for database to be fully ready
 	time.Sleep(2 * time.Second)
 
@@ -29,7 +43,7 @@ func main() {
 	}
 
 	s := grpc.NewServer()
-	pb.RegisterProductServiceServer(s, service.NewProductService(db))
+	pb.RegisterProductServiceServer(s, service.NewProductService(db, baseCurrency))
 
 	log.Printf("Product service listening on :50052")
 	if err := s.Serve(lis); err != nil {
@@ -43,7 +43,7 @@ func InitDB() (*gorm.DB, error) {
 	}
 
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&Product{})
+	err = db.AutoMigrate(&Product{}, &ProductPrice{}, &ExchangeRate{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"gorm.io/gorm"
+
+	pb "product-service/product-service/proto"
+	"shared/money"
+)
+
+// ProductPrice is the price of a product in a currency other than its base currency
+type ProductPrice struct {
+	ProductID  string `gorm:"primaryKey;type:varchar(255)"`
+	Currency   string `gorm:"primaryKey;type:varchar(3)"`
+	PriceMinor int64  `gorm:"not null"`
+	UpdatedAt  int64  `gorm:"autoUpdateTime"`
+}
+
+// ExchangeRate is how many units of Currency one unit of the base currency buys.
+// Rate is kept as decimal text so that it converts exactly.
+type ExchangeRate struct {
+	Currency  string `gorm:"primaryKey;type:varchar(3)"`
+	Rate      string `gorm:"not null;type:varchar(40)"`
+	UpdatedAt int64  `gorm:"autoUpdateTime"`
+}
+
+// MigrateLegacyPrices moves prices from the old decimal price column to minor
+// units of the base currency and drops the column. It does nothing once done.
+func MigrateLegacyPrices(db *gorm.DB, baseCurrency string) error {
+	if !db.Migrator().HasColumn(&Product{}, "price") {
+		return nil
+	}
+
+	scale := math.Pow10(money.Exponent(baseCurrency))
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE products SET price_minor = ROUND(price * ?), currency = ? WHERE currency = ''", scale, baseCurrency).Error; err != nil {
+			return fmt.Errorf("failed to migrate prices: %v", err)
+		}
+		if err := tx.Migrator().DropColumn(&Product{}, "price"); err != nil {
+			return fmt.Errorf("failed to drop price column: %v", err)
+		}
+		return nil
+	})
+}
+
+// moneyFromProto validates a price. A missing currency means defaultCurrency.
+func moneyFromProto(m *pb.Money, defaultCurrency string) (money.Money, error) {
+	if m == nil {
+		return money.Money{Currency: defaultCurrency}, nil
+	}
+	currency := money.Normalize(m.Currency)
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if !money.IsValid(currency) {
+		return money.Money{}, fmt.Errorf("unsupported currency %q", m.Currency)
+	}
+	return money.Money{Minor: m.MinorUnits, Currency: currency}, nil
+}
+
+func moneyToProto(m money.Money) *pb.Money {
+	return &pb.Money{MinorUnits: m.Minor, Currency: m.Currency}
+}
+
+// productToResponse returns product with its price in currency, or in its base
+// currency when currency is empty
+func (s *ProductService) productToResponse(ctx context.Context, product *Product, currency string) (*pb.ProductResponse, error) {
+	var overrides []ProductPrice
+	if err := s.db.Where("product_id = ?", product.ID).Order("currency").Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	base := money.Money{Minor: product.PriceMinor, Currency: product.Currency}
+	resp := &pb.ProductResponse{
+		ProductId:   product.ID,
+		Name:        product.Name,
+		Description: product.Description,
+		Stock:       product.Stock,
+		TaxCategory: product.TaxCategory,
+		WeightGrams: product.WeightGrams,
+		LengthCm:    product.LengthCm,
+		WidthCm:     product.WidthCm,
+		HeightCm:    product.HeightCm,
+		Prices:      []*pb.Money{moneyToProto(base)},
+	}
+	for _, override := range overrides {
+		resp.Prices = append(resp.Prices, moneyToProto(money.Money{Minor: override.PriceMinor, Currency: override.Currency}))
+	}
+
+	price, err := s.priceIn(base, overrides, money.Normalize(currency))
+	if err != nil {
+		return nil, err
+	}
+	resp.Price = moneyToProto(price)
+	return resp, nil
+}
+
+// priceIn picks the price set for currency, or converts the base price
+func (s *ProductService) priceIn(base money.Money, overrides []ProductPrice, currency string) (money.Money, error) {
+	if currency == "" || currency == base.Currency {
+		return base, nil
+	}
+	if !money.IsValid(currency) {
+		return money.Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+	for _, override := range overrides {
+		if override.Currency == currency {
+			return money.Money{Minor: override.PriceMinor, Currency: currency}, nil
+		}
+	}
+
+	rates, err := s.loadRates()
+	if err != nil {
+		return money.Money{}, err
+	}
+	minor, err := rates.Convert(base.Minor, base.Currency, currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.Money{Minor: minor, Currency: currency}, nil
+}
+
+func (s *ProductService) loadRates() (*money.Rates, error) {
+	var rows []ExchangeRate
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	rates := &money.Rates{Base: s.baseCurrency, PerBase: make(map[string]*big.Rat)}
+	for _, row := range rows {
+		rate, err := money.ParseRat(row.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("exchange rate for %s: %v", row.Currency, err)
+		}
+		rates.PerBase[row.Currency] = rate
+	}
+	return rates, nil
+}
+
+func (s *ProductService) SetProductPrice(ctx context.Context, req *pb.SetProductPriceRequest) (*pb.ProductResponse, error) {
+	if req.Price == nil || money.Normalize(req.Price.Currency) == "" {
+		return nil, fmt.Errorf("price with a currency required")
+	}
+	price, err := moneyFromProto(req.Price, "")
+	if err != nil {
+		return nil, err
+	}
+	if price.Minor < 0 {
+		return nil, fmt.Errorf("price must not be negative")
+	}
+
+	var product Product
+	if err := s.db.Where("id = ?", req.ProductId).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("product not found")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	if price.Currency == product.Currency {
+		product.PriceMinor = price.Minor
+		if err := s.db.Save(&product).Error; err != nil {
+			return nil, fmt.Errorf("failed to update price: %v", err)
+		}
+	} else {
+		row := ProductPrice{ProductID: product.ID, Currency: price.Currency, PriceMinor: price.Minor}
+		if err := s.db.Save(&row).Error; err != nil {
+			return nil, fmt.Errorf("failed to update price: %v", err)
+		}
+	}
+
+	return s.productToResponse(ctx, &product, price.Currency)
+}
+
+func (s *ProductService) RemoveProductPrice(ctx context.Context, req *pb.RemoveProductPriceRequest) (*pb.ProductResponse, error) {
+	var product Product
+	if err := s.db.Where("id = ?", req.ProductId).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("product not found")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	currency := money.Normalize(req.Currency)
+	if currency == product.Currency {
+		return nil, fmt.Errorf("the base price of a product cannot be removed")
+	}
+	result := s.db.Where("product_id = ? AND currency = ?", product.ID, currency).Delete(&ProductPrice{})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to remove price: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("product has no %s price", currency)
+	}
+
+	return s.productToResponse(ctx, &product, "")
+}
+
+func (s *ProductService) SetExchangeRate(ctx context.Context, req *pb.SetExchangeRateRequest) (*pb.ExchangeRate, error) {
+	currency := money.Normalize(req.Currency)
+	if !money.IsValid(currency) {
+		return nil, fmt.Errorf("unsupported currency %q", req.Currency)
+	}
+	if currency == s.baseCurrency {
+		return nil, fmt.Errorf("%s is the base currency", currency)
+	}
+	rate, err := money.ParseRat(req.Rate)
+	if err != nil {
+		return nil, err
+	}
+	if rate.Sign() <= 0 {
+		return nil, fmt.Errorf("exchange rate must be positive")
+	}
+
+	row := ExchangeRate{Currency: currency, Rate: strings.TrimSpace(req.Rate)}
+	if err := s.db.Save(&row).Error; err != nil {
+		return nil, fmt.Errorf("failed to save exchange rate: %v", err)
+	}
+	return exchangeRateToProto(row), nil
+}
+
+func (s *ProductService) ListExchangeRates(ctx context.Context, req *pb.ListExchangeRatesRequest) (*pb.ListExchangeRatesResponse, error) {
+	var rows []ExchangeRate
+	if err := s.db.Order("currency").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	resp := &pb.ListExchangeRatesResponse{BaseCurrency: s.baseCurrency}
+	for _, row := range rows {
+		resp.Rates = append(resp.Rates, exchangeRateToProto(row))
+	}
+	return resp, nil
+}
+
+func exchangeRateToProto(row ExchangeRate) *pb.ExchangeRate {
+	return &pb.ExchangeRate{
+		Currency:  row.Currency,
+		Rate:      row.Rate,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	pb "product-service/product-service/proto"
+	"shared/money"
+)
+
+// newTestService returns a ProductService with USD as base currency on an empty
+// sqlite database
+func newTestService(t *testing.T) *ProductService {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "products.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Product{}, &ProductPrice{}, &ExchangeRate{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewProductService(db, "USD")
+}
+
+func TestProductPriceConversion(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	for currency, rate := range map[string]string{"JPY": "150", "EUR": "0.92", "KWD": "0.307"} {
+		if _, err := s.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{Currency: currency, Rate: rate}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	products := []Product{
+		{ID: "usd", Name: "Priced in dollars", PriceMinor: 1999, Currency: "USD"},
+		{ID: "cent", Name: "One cent", PriceMinor: 1, Currency: "USD"},
+		{ID: "jpy", Name: "Priced in yen", PriceMinor: 2999, Currency: "JPY"},
+		{ID: "eur", Name: "Priced in euros", PriceMinor: 1000, Currency: "EUR"},
+		{ID: "override", Name: "With a yen price", PriceMinor: 1999, Currency: "USD"},
+	}
+	if err := s.db.Create(&products).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&ProductPrice{ProductID: "override", Currency: "JPY", PriceMinor: 2500}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		productID string
+		currency  string
+		want      int64
+	}{
+		{"usd", "", 1999},    // base price
+		{"usd", "usd", 1999}, // currencies are case insensitive
+		{"usd", "JPY", 2999}, // 2998.5 yen, half away from zero
+		{"usd", "EUR", 1839}, // 1839.08 cents
+		{"usd", "KWD", 6137}, // 6.13693 dinars, three minor digits
+		{"cent", "JPY", 2},   // 1.5 yen
+		{"cent", "EUR", 1},   // 0.92 cents
+		{"jpy", "USD", 1999}, // 1999.33 cents
+		{"jpy", "EUR", 1839}, // 1839.39 cents, neither is the base
+		{"eur", "JPY", 1630}, // 1630.43 yen
+		{"override", "JPY", 2500},
+		{"override", "EUR", 1839}, // only the JPY price is set
+	}
+	for _, tt := range tests {
+		resp, err := s.GetProduct(ctx, &pb.GetProductRequest{ProductId: tt.productID, Currency: tt.currency})
+		if err != nil {
+			t.Errorf("price of %s in %q: %v", tt.productID, tt.currency, err)
+			continue
+		}
+		wantCurrency := strings.ToUpper(tt.currency)
+		if wantCurrency == "" {
+			wantCurrency = "USD"
+		}
+		if resp.Price.MinorUnits != tt.want || resp.Price.Currency != wantCurrency {
+			t.Errorf("price of %s in %q = %d %s, want %d %s", tt.productID, tt.currency,
+				resp.Price.MinorUnits, resp.Price.Currency, tt.want, wantCurrency)
+		}
+	}
+}
+
+func TestPriceInRoundsHalfAwayFromZero(t *testing.T) {
+	s := newTestService(t)
+	if err := s.db.Create(&ExchangeRate{Currency: "JPY", Rate: "150"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		minor int64
+		want  int64
+	}{
+		{1, 2}, // 1.5
+		{-1, -2},
+		{3, 5}, // 4.5
+		{-3, -5},
+		{1999, 2999},
+		{-1999, -2999},
+	}
+	for _, tt := range tests {
+		got, err := s.priceIn(money.Money{Minor: tt.minor, Currency: "USD"}, nil, "JPY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Minor != tt.want {
+			t.Errorf("%d cents in yen = %d, want %d", tt.minor, got.Minor, tt.want)
+		}
+	}
+}
+
+func TestProductPriceConversionErrors(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if err := s.db.Create(&ExchangeRate{Currency: "JPY", Rate: "150"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	products := []Product{
+		{ID: "usd", Name: "Priced in dollars", PriceMinor: 1999, Currency: "USD"},
+		{ID: "gbp", Name: "Priced in pounds", PriceMinor: 1999, Currency: "GBP"},
+	}
+	if err := s.db.Create(&products).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		productID string
+		currency  string
+		wantErr   string
+	}{
+		{"usd", "EUR", "no exchange rate for EUR"},
+		{"gbp", "JPY", "no exchange rate for GBP"},
+		{"usd", "XYZ", `unsupported currency "XYZ"`},
+	}
+	for _, tt := range tests {
+		_, err := s.GetProduct(ctx, &pb.GetProductRequest{ProductId: tt.productID, Currency: tt.currency})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("price of %s in %s: error %v, want %q", tt.productID, tt.currency, err, tt.wantErr)
+		}
+	}
+
+	// Callers tell a missing product from a failure by its code
+	if _, err := s.GetProduct(ctx, &pb.GetProductRequest{ProductId: "missing", Currency: "JPY"}); status.Code(err) != codes.NotFound {
+		t.Errorf("price of a missing product: error %v, want NotFound", err)
+	}
+
+	if _, err := s.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{Currency: "XYZ", Rate: "2"}); err == nil {
+		t.Error("exchange rate for an unsupported currency accepted")
+	}
+	if _, err := s.SetExchangeRate(ctx, &pb.SetExchangeRateRequest{Currency: "EUR", Rate: "0"}); err == nil {
+		t.Error("zero exchange rate accepted")
+	}
+}
@@ -7,51 +7,76 @@ import (
 	"fmt"
 
 	pb "product-service/product-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
 type Product struct {
-	ID          string  `gorm:"primaryKey;type:varchar(255)"`
-	Name        string  `gorm:"not null;type:varchar(255)"`
-	Description string  `gorm:"type:text"`
-	Price       float64 `gorm:"not null;type:decimal(10,2)"`
-	Stock       int32   `gorm:"not null;default:0"`
+	ID          string `gorm:"primaryKey;type:varchar(255)"`
+	Name        string `gorm:"not null;type:varchar(255)"`
+	Description string `gorm:"type:text"`
+	PriceMinor  int64  `gorm:"not null;default:0"`                  // Base price in minor units of Currency
+	Currency    string `gorm:"not null;type:varchar(3);default:''"` // Base currency, see MigrateLegacyPrices
+	Stock       int32  `gorm:"not null;default:0"`
+	TaxCategory string `gorm:"not null;type:varchar(50);default:'standard'"` // Looked up in the order service's tax rules
+	// Shipping weight and package dimensions of one unit
+	WeightGrams int32   `gorm:"not null;default:0"`
+	LengthCm    float64 `gorm:"not null;default:0"`
+	WidthCm     float64 `gorm:"not null;default:0"`
+	HeightCm    float64 `gorm:"not null;default:0"`
 	CreatedAt   int64   `gorm:"autoCreateTime"`
 	UpdatedAt   int64   `gorm:"autoUpdateTime"`
 }
 
 type ProductService struct {
 	pb.UnimplementedProductServiceServer
-	db *gorm.DB
+	db           *gorm.DB
+	baseCurrency string
 }
 
-func NewProductService(db *gorm.DB) *ProductService {
+func NewProductService(db *gorm.DB, baseCurrency string) *ProductService {
 	return &ProductService{
-		db: db,
+		db:           db,
+		baseCurrency: baseCurrency,
 	}
 }
 
 func (s *ProductService) CreateProduct(ctx context.Context, req *pb.CreateProductRequest) (*pb.ProductResponse, error) {
+	price, err := moneyFromProto(req.Price, s.baseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if price.Minor < 0 {
+		return nil, fmt.Errorf("price must not be negative")
+	}
+
 	productID := generateID()
 	product := &Product{
 		ID:          productID,
 		Name:        req.Name,
 		Description: req.Description,
-		Price:       req.Price,
+		PriceMinor:  price.Minor,
+		Currency:    price.Currency,
 		Stock:       req.Stock,
+		TaxCategory: req.TaxCategory,
+		WeightGrams: req.WeightGrams,
+		LengthCm:    req.LengthCm,
+		WidthCm:     req.WidthCm,
+		HeightCm:    req.HeightCm,
+	}
+	if product.WeightGrams < 0 || product.LengthCm < 0 || product.WidthCm < 0 || product.HeightCm < 0 {
+		return nil, fmt.Errorf("weight and dimensions must not be negative")
+	}
+	if product.TaxCategory == "" {
+		product.TaxCategory = "standard"
 	}
 
 	if err := s.db.Create(product).Error; err != nil {
 		return nil, fmt.Errorf("failed to create product: %v", err)
 	}
 
-	return &pb.ProductResponse{
-		ProductId:   productID,
-		Name:        product.Name,
-		Description: product.Description,
-		Price:       product.Price,
-		Stock:       product.Stock,
-	}, nil
+	return s.productToResponse(ctx, product, "")
 }
 
 func (s *ProductService) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.ProductResponse, error) {
@@ -59,18 +84,12 @@ func (s *ProductService) GetProduct(ctx context.Context, req *pb.GetProductReque
 	result := s.db.Where("id = ?", req.ProductId).First(&product)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("product not found")
+			return nil, status.Error(codes.NotFound, "product not found")
 		}
-		return nil, fmt.Errorf("database error: %v", result.Error)
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
 	}
 
-	return &pb.ProductResponse{
-		ProductId:   product.ID,
-		Name:        product.Name,
-		Description: product.Description,
-		Price:       product.Price,
-		Stock:       product.Stock,
-	}, nil
+	return s.productToResponse(ctx, &product, req.Currency)
 }
 
 func (s *ProductService) ListProducts(ctx context.Context, req *pb.ListProductsRequest) (*pb.ListProductsResponse, error) {
@@ -87,14 +106,12 @@ func (s *ProductService) ListProducts(ctx context.Context, req *pb.ListProductsR
 	}
 
 	var products []*pb.ProductResponse
-	for _, p := range dbProducts {
-		products = append(products, &pb.ProductResponse{
-			ProductId:   p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-			Stock:       p.Stock,
-		})
+	for i := range dbProducts {
+		product, err := s.productToResponse(ctx, &dbProducts[i], req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
 	}
 
 	return &pb.ListProductsResponse{Products: products}, nil
@@ -105,28 +122,22 @@ func (s *ProductService) UpdateInventory(ctx context.Context, req *pb.UpdateInve
 	result := s.db.Where("id = ?", req.ProductId).First(&product)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("product not found")
+			return nil, status.Error(codes.NotFound, "product not found")
 		}
-		return nil, fmt.Errorf("database error: %v", result.Error)
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
 	}
 
 	newStock := product.Stock + req.QuantityChange
 	if newStock < 0 {
-		return nil, fmt.Errorf("insufficient stock")
+		return nil, status.Error(codes.FailedPrecondition, "insufficient stock")
 	}
 
 	product.Stock = newStock
 	if err := s.db.Save(&product).Error; err != nil {
-		return nil, fmt.Errorf("failed to update inventory: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to update inventory: %v", err)
 	}
 
-	return &pb.ProductResponse{
-		ProductId:   product.ID,
-		Name:        product.Name,
-		Description: product.Description,
-		Price:       product.Price,
-		Stock:       product.Stock,
-	}, nil
+	return s.productToResponse(ctx, &product, "")
 }
 
 func generateID() string {
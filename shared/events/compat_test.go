@@ -0,0 +1,157 @@
+package events
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var update = flag.Bool("update", false, "record compatible schema changes in testdata/schemas.json")
+
+const goldenFile = "testdata/schemas.json"
+
+// recordedSchema is the shape of one event type as consumers have seen it
+type recordedSchema struct {
+	Version int             `json:"version"`
+	Message string          `json:"message"`
+	Fields  []recordedField `json:"fields"`
+}
+
+// recordedField is a payload field, nested fields named by their path
+type recordedField struct {
+	Path     string `json:"path"`
+	Number   int    `json:"number"`
+	Kind     string `json:"kind"`
+	Repeated bool   `json:"repeated,omitempty"`
+}
+
+// TestSchemasCompatible compares the payloads with testdata/schemas.json and fails
+// on changes that would break consumers of an unchanged schema version: removed or
+// renamed event types, fields or messages, and fields with a new number, type or
+// cardinality. Added event types and fields are compatible; run
+// go test ./events -update to record them.
+func TestSchemasCompatible(t *testing.T) {
+	current := describeSchemas()
+
+	recorded := map[string]recordedSchema{}
+	data, err := os.ReadFile(goldenFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			t.Fatalf("invalid %s: %v", goldenFile, err)
+		}
+	}
+
+	breaking := false
+	for eventType, old := range recorded {
+		for _, problem := range incompatibilities(old, current[eventType], eventType) {
+			t.Error(problem)
+			breaking = true
+		}
+	}
+	if breaking {
+		t.Log("Breaking changes need a new payload message and a higher schema version")
+		return
+	}
+
+	if *update {
+		data, err := json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !reflect.DeepEqual(recorded, current) {
+		t.Errorf("schemas changed compatibly, run go test ./events -update to record them")
+	}
+}
+
+// incompatibilities lists how cur breaks consumers of old
+func incompatibilities(old, cur recordedSchema, eventType string) []string {
+	if cur.Message == "" {
+		return []string{fmt.Sprintf("%s: event type removed", eventType)}
+	}
+	if cur.Version < old.Version {
+		return []string{fmt.Sprintf("%s: schema version went down from %d to %d", eventType, old.Version, cur.Version)}
+	}
+	if cur.Version > old.Version {
+		return nil
+	}
+
+	var problems []string
+	if cur.Message != old.Message {
+		problems = append(problems, fmt.Sprintf("%s: payload changed from %s to %s", eventType, old.Message, cur.Message))
+	}
+	fields := map[string]recordedField{}
+	for _, field := range cur.Fields {
+		fields[field.Path] = field
+	}
+	for _, was := range old.Fields {
+		is, ok := fields[was.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: field %s removed or renamed", eventType, was.Path))
+		case is.Number != was.Number:
+			problems = append(problems, fmt.Sprintf("%s: field %s renumbered from %d to %d", eventType, was.Path, was.Number, is.Number))
+		case is.Kind != was.Kind:
+			problems = append(problems, fmt.Sprintf("%s: field %s changed from %s to %s", eventType, was.Path, was.Kind, is.Kind))
+		case is.Repeated != was.Repeated:
+			problems = append(problems, fmt.Sprintf("%s: field %s changed cardinality", eventType, was.Path))
+		}
+	}
+	return problems
+}
+
+func describeSchemas() map[string]recordedSchema {
+	described := map[string]recordedSchema{}
+	for _, schema := range Schemas() {
+		desc := schema.Message.ProtoReflect().Descriptor()
+		described[schema.Type] = recordedSchema{
+			Version: schema.Version,
+			Message: string(desc.FullName()),
+			Fields:  describeFields(desc, "", map[protoreflect.FullName]bool{}),
+		}
+	}
+	return described
+}
+
+// describeFields flattens the fields of desc and the messages it contains
+func describeFields(desc protoreflect.MessageDescriptor, prefix string, seen map[protoreflect.FullName]bool) []recordedField {
+	seen[desc.FullName()] = true
+	defer delete(seen, desc.FullName())
+
+	var fields []recordedField
+	for i := 0; i < desc.Fields().Len(); i++ {
+		fd := desc.Fields().Get(i)
+		field := recordedField{
+			Path:     prefix + string(fd.Name()),
+			Number:   int(fd.Number()),
+			Kind:     fd.Kind().String(),
+			Repeated: fd.Cardinality() == protoreflect.Repeated,
+		}
+		switch {
+		case fd.IsMap():
+			field.Kind = "map<" + fd.MapKey().Kind().String() + "," + fd.MapValue().Kind().String() + ">"
+		case fd.Message() != nil:
+			field.Kind = string(fd.Message().FullName())
+		case fd.Enum() != nil:
+			field.Kind = string(fd.Enum().FullName())
+		}
+		fields = append(fields, field)
+
+		if fd.Message() != nil && !fd.IsMap() && !seen[fd.Message().FullName()] {
+			fields = append(fields, describeFields(fd.Message(), field.Path+".", seen)...)
+		}
+	}
+	return fields
+}
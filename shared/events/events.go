@@ -0,0 +1,78 @@
+// Package events is the catalogue of the events on the message bus: the payload
+// message and schema version of every event type. The payloads are defined in
+// proto/events.proto of this module, which every service builds from.
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"shared/messaging"
+	pb "shared/proto"
+)
+
+// Schema describes the payload of one event type
+type Schema struct {
+	Type    string
+	Version int           // bumped when the payload changes incompatibly
+	Message proto.Message // an empty payload
+}
+
+var schemas = []Schema{
+	{Type: "order.created", Version: 1, Message: &pb.OrderCreatedEvent{}},
+	{Type: "order.status_changed", Version: 1, Message: &pb.OrderStatusChangedEvent{}},
+	{Type: "order.cancelled", Version: 1, Message: &pb.OrderCancelledEvent{}},
+	{Type: "order.shipped", Version: 1, Message: &pb.OrderShippedEvent{}},
+	{Type: "order.expired", Version: 1, Message: &pb.OrderExpiredEvent{}},
+	{Type: "return.requested", Version: 1, Message: &pb.ReturnStatusChangedEvent{}},
+	{Type: "return.approved", Version: 1, Message: &pb.ReturnStatusChangedEvent{}},
+	{Type: "return.received", Version: 1, Message: &pb.ReturnStatusChangedEvent{}},
+	{Type: "return.refunded", Version: 1, Message: &pb.ReturnStatusChangedEvent{}},
+	{Type: "return.rejected", Version: 1, Message: &pb.ReturnStatusChangedEvent{}},
+	{Type: "payment.succeeded", Version: 1, Message: &pb.PaymentSettledEvent{}},
+	{Type: "payment.failed", Version: 1, Message: &pb.PaymentSettledEvent{}},
+	{Type: "user.registered", Version: 1, Message: &pb.UserRegisteredEvent{}},
+}
+
+// Schemas returns the schema of every event type
+func Schemas() []Schema {
+	return append([]Schema(nil), schemas...)
+}
+
+// Lookup returns the schema of eventType
+func Lookup(eventType string) (Schema, bool) {
+	for _, schema := range schemas {
+		if schema.Type == eventType {
+			return schema, true
+		}
+	}
+	return Schema{}, false
+}
+
+// New wraps data in an envelope for eventType published by source, checking that
+// data is the payload the catalogue lists for it
+func New(ctx context.Context, source, eventType string, data proto.Message) (*messaging.Envelope, error) {
+	schema, ok := Lookup(eventType)
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %s", eventType)
+	}
+	if proto.MessageName(data) != proto.MessageName(schema.Message) {
+		return nil, fmt.Errorf("%s payload must be %s, not %s", eventType, proto.MessageName(schema.Message), proto.MessageName(data))
+	}
+	return messaging.NewEnvelope(ctx, source, eventType, schema.Version, data)
+}
+
+// Handle registers handle on q for eventType, accepting schema versions up to the
+// one in the catalogue
+func Handle[T any, P interface {
+	*T
+	proto.Message
+}](q *messaging.Queue, eventType string, handle func(ctx context.Context, env *messaging.Envelope, event P) error) {
+	schema, ok := Lookup(eventType)
+	if !ok {
+		panic("unknown event type " + eventType)
+	}
+	messaging.HandleEvent(q, eventType, schema.Version, handle)
+}
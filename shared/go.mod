@@ -0,0 +1,17 @@
+module shared
+
+go 1.23.0
+
+require (
+	github.com/streadway/amqp v1.1.0
+	google.golang.org/protobuf v1.36.6
+	gorm.io/driver/sqlite v1.6.0
+	gorm.io/gorm v1.31.1
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/text v0.20.0 // indirect
+)
@@ -0,0 +1,210 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Broker publishes events and runs consumers. MessageBroker talks to RabbitMQ and
+// MemoryBroker stands in for it in tests.
+type Broker interface {
+	Publisher
+	// Consume declares q with its bindings and starts handling its messages until
+	// ctx is cancelled. It returns once the queue is set up.
+	Consume(ctx context.Context, q *Queue) error
+	// Health reports the state of the connection to the broker
+	Health() Health
+}
+
+// Message is one delivery handed to a Handler
+type Message struct {
+	Exchange   string
+	RoutingKey string // the key the message was published with, also on retries
+	Body       []byte
+	Attempt    int // 1 on the first delivery
+}
+
+// Handler processes one message. Returning nil acks it. An error retries it with
+// backoff until the queue's MaxAttempts, after which it goes to the dead-letter
+// queue; wrap the error with Permanent to dead-letter it at once. Messages can be
+// delivered more than once, so handlers must be idempotent.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Queue is a durable queue bound to routing-key patterns of one topic exchange,
+// with a handler per pattern. Patterns use the topic syntax: * matches one word
+// and # zero or more. Failed messages wait in the queue Name.retry and poison
+// messages end up in Name.dead.
+type Queue struct {
+	Name        string
+	Exchange    string
+	Concurrency int           // messages handled at once, default 1
+	MaxAttempts int           // deliveries before a message is dead-lettered, default 5
+	MinBackoff  time.Duration // delay before the first retry, doubled on every retry, default 1s
+	MaxBackoff  time.Duration // default 5m
+
+	routes []route
+}
+
+type route struct {
+	pattern string
+	handler Handler
+}
+
+// Handle binds the queue to pattern and sends matching messages to handler. A message
+// matching several patterns goes to the first one registered.
+func (q *Queue) Handle(pattern string, handler Handler) {
+	q.routes = append(q.routes, route{pattern: pattern, handler: handler})
+}
+
+// HandleJSON registers a handler for messages matching pattern whose JSON body is
+// decoded into a T. Bodies that cannot be decoded are dead-lettered.
+func HandleJSON[T any](q *Queue, pattern string, handle func(ctx context.Context, routingKey string, event *T) error) {
+	q.Handle(pattern, func(ctx context.Context, msg *Message) error {
+		var event T
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			return Permanent(fmt.Errorf("invalid %s body: %v", msg.RoutingKey, err))
+		}
+		return handle(ctx, msg.RoutingKey, &event)
+	})
+}
+
+// Patterns returns the routing-key patterns the queue is bound to
+func (q *Queue) Patterns() []string {
+	patterns := make([]string, 0, len(q.routes))
+	for _, r := range q.routes {
+		patterns = append(patterns, r.pattern)
+	}
+	return patterns
+}
+
+func (q *Queue) retryQueue() string {
+	return q.Name + ".retry"
+}
+
+func (q *Queue) deadQueue() string {
+	return q.Name + ".dead"
+}
+
+// prepare checks the queue and fills in the defaults
+func (q *Queue) prepare() error {
+	if q.Name == "" || q.Exchange == "" {
+		return fmt.Errorf("queue name and exchange required")
+	}
+	if len(q.routes) == 0 {
+		return fmt.Errorf("queue %s has no handlers", q.Name)
+	}
+	if q.Concurrency <= 0 {
+		q.Concurrency = 1
+	}
+	if q.MaxAttempts <= 0 {
+		q.MaxAttempts = 5
+	}
+	if q.MinBackoff <= 0 {
+		q.MinBackoff = time.Second
+	}
+	if q.MaxBackoff <= 0 {
+		q.MaxBackoff = 5 * time.Minute
+	}
+	if q.MaxBackoff < q.MinBackoff {
+		q.MaxBackoff = q.MinBackoff
+	}
+	return nil
+}
+
+// backoff doubles the delay with every failed attempt, up to MaxBackoff
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.MinBackoff
+	for i := 1; i < attempt && delay < q.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.MaxBackoff {
+		delay = q.MaxBackoff
+	}
+	return delay
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the message is dead-lettered instead of retried
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// outcome is what becomes of a message after its handler ran
+type outcome struct {
+	retryAfter time.Duration // retry after this delay if positive
+	dead       bool
+	err        error
+}
+
+// dispatch runs the handler for msg and decides whether it is acked, retried or
+// dead-lettered. A panicking handler counts as a permanent failure.
+func (q *Queue) dispatch(ctx context.Context, msg *Message) (result outcome) {
+	var handler Handler
+	for _, r := range q.routes {
+		if matchRoutingKey(r.pattern, msg.RoutingKey) {
+			handler = r.handler
+			break
+		}
+	}
+	if handler == nil {
+		err := fmt.Errorf("no handler for %s", msg.RoutingKey)
+		log.Printf("Dead-lettering %s from %s: %v", msg.RoutingKey, q.Name, err)
+		return outcome{dead: true, err: err}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			result = outcome{dead: true, err: fmt.Errorf("handler panicked: %v", p)}
+			log.Printf("Dead-lettering %s from %s: %v", msg.RoutingKey, q.Name, result.err)
+		}
+	}()
+
+	err := handler(ctx, msg)
+	if err == nil {
+		return outcome{}
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) || msg.Attempt >= q.MaxAttempts {
+		log.Printf("Dead-lettering %s from %s after %d attempts: %v", msg.RoutingKey, q.Name, msg.Attempt, err)
+		return outcome{dead: true, err: err}
+	}
+	delay := q.backoff(msg.Attempt)
+	log.Printf("Retrying %s from %s in %s (attempt %d): %v", msg.RoutingKey, q.Name, delay, msg.Attempt, err)
+	return outcome{retryAfter: delay, err: err}
+}
+
+// matchRoutingKey reports whether a routing key matches a topic pattern
+func matchRoutingKey(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,128 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConsumerRetries(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name           string
+		maxAttempts    int
+		failures       int   // deliveries that fail before one succeeds
+		err            error // returned by the failing deliveries
+		wantDeliveries int
+		wantDead       bool
+	}{
+		{"handled at once", 3, 0, errFailed, 1, false},
+		{"succeeds on a retry", 3, 2, errFailed, 3, false},
+		{"dead-lettered after max attempts", 3, 5, errFailed, 3, true},
+		{"default max attempts", 0, 10, errFailed, 5, true},
+		{"permanent error is not retried", 3, 1, Permanent(errFailed), 1, true},
+		{"wrapped permanent error", 3, 1, fmt.Errorf("order 1: %w", Permanent(errFailed)), 1, true},
+	}
+
+	for _, tt := range tests {
+		broker := NewMemoryBroker()
+		queue := &Queue{Name: "test.orders", Exchange: "order_events", MaxAttempts: tt.maxAttempts, MinBackoff: time.Millisecond}
+		var attempts []int
+		queue.Handle("order.*", func(ctx context.Context, msg *Message) error {
+			attempts = append(attempts, msg.Attempt)
+			if len(attempts) <= tt.failures {
+				return tt.err
+			}
+			return nil
+		})
+		if err := broker.Consume(context.Background(), queue); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if err := broker.PublishEvent("order_events", "order.created", map[string]string{"order_id": "1"}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := broker.Drain(); got != tt.wantDeliveries {
+			t.Errorf("%s: %d deliveries, want %d", tt.name, got, tt.wantDeliveries)
+		}
+		for i, attempt := range attempts {
+			if attempt != i+1 {
+				t.Errorf("%s: delivery %d has attempt %d", tt.name, i+1, attempt)
+			}
+		}
+		dead := broker.DeadLetters("test.orders")
+		if gotDead := len(dead) == 1; gotDead != tt.wantDead {
+			t.Errorf("%s: %d dead letters, want dead-lettered %v", tt.name, len(dead), tt.wantDead)
+		}
+	}
+}
+
+func TestConsumerRouting(t *testing.T) {
+	broker := NewMemoryBroker()
+	var handled []string
+	record := func(name string) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			handled = append(handled, name+":"+msg.RoutingKey)
+			return nil
+		}
+	}
+	orders := &Queue{Name: "test.orders", Exchange: "order_events"}
+	orders.Handle("order.created", record("created"))
+	orders.Handle("order.#", record("any"))
+	returns := &Queue{Name: "test.returns", Exchange: "order_events"}
+	returns.Handle("return.*", record("return"))
+	for _, q := range []*Queue{orders, returns} {
+		if err := broker.Consume(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		exchange, routingKey string
+		wantErr              error
+	}{
+		{"order_events", "order.created", nil},
+		{"order_events", "order.status_changed", nil},
+		{"order_events", "return.approved", nil},
+		{"order_events", "payment.succeeded", ErrUnroutable},
+		{"payment_events", "order.created", ErrUnroutable},
+	}
+	for _, tt := range tests {
+		err := broker.PublishEvent(tt.exchange, tt.routingKey, struct{}{})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("publish %s to %s: error %v, want %v", tt.routingKey, tt.exchange, err, tt.wantErr)
+		}
+	}
+	broker.Drain()
+
+	want := []string{"created:order.created", "any:order.status_changed", "return:return.approved"}
+	if fmt.Sprint(handled) != fmt.Sprint(want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+	if got := len(broker.Published()); got != len(tests) {
+		t.Errorf("%d messages recorded as published, want %d", got, len(tests))
+	}
+}
+
+func TestConsumerPanicIsDeadLettered(t *testing.T) {
+	broker := NewMemoryBroker()
+	queue := &Queue{Name: "test.orders", Exchange: "order_events"}
+	deliveries := 0
+	queue.Handle("order.created", func(ctx context.Context, msg *Message) error {
+		deliveries++
+		panic("boom")
+	})
+	if err := broker.Consume(context.Background(), queue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := broker.PublishEvent("order_events", "order.created", struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	broker.Drain()
+	if deliveries != 1 || len(broker.DeadLetters("test.orders")) != 1 {
+		t.Errorf("%d deliveries and %d dead letters, want 1 and 1", deliveries, len(broker.DeadLetters("test.orders")))
+	}
+}
@@ -0,0 +1,141 @@
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// SpecVersion is the CloudEvents version the envelope follows
+const SpecVersion = "1.0"
+
+// Envelope is the CloudEvents-style wrapper every event is published in. Data holds
+// the payload, a protobuf message in its JSON form; DataSchema names the message and
+// SchemaVersion is bumped when the payload of Type changes incompatibly.
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"` // also the routing key
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	SchemaVersion   int             `json:"schemaversion"`
+	CorrelationID   string          `json:"correlationid"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// NewEnvelope wraps data as an event of eventType published by source. An event
+// published while handling another one inherits its correlation ID through ctx;
+// any other event starts a correlation of its own.
+func NewEnvelope(ctx context.Context, source, eventType string, schemaVersion int, data proto.Message) (*Envelope, error) {
+	body, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s payload: %v", eventType, err)
+	}
+
+	id := newEventID()
+	correlationID := CorrelationID(ctx)
+	if correlationID == "" {
+		correlationID = id
+	}
+	return &Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		DataSchema:      string(proto.MessageName(data)),
+		SchemaVersion:   schemaVersion,
+		CorrelationID:   correlationID,
+		Data:            body,
+	}, nil
+}
+
+// Decode unmarshals the payload into msg. Fields msg does not know yet are
+// ignored, so producers can add fields before every consumer is upgraded.
+func (e *Envelope) Decode(msg proto.Message) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(e.Data, msg)
+}
+
+// parseEnvelope reads the envelope of msg. Events published before the envelope
+// was introduced carry the bare payload and are read as version 1 of their
+// routing key.
+func parseEnvelope(msg *Message) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(msg.Body, &env); err != nil {
+		return nil, err
+	}
+	if env.SpecVersion == "" {
+		return &Envelope{Type: msg.RoutingKey, SchemaVersion: 1, Data: msg.Body}, nil
+	}
+	if env.SpecVersion != SpecVersion {
+		return nil, fmt.Errorf("unsupported specversion %q", env.SpecVersion)
+	}
+	return &env, nil
+}
+
+// HandleEvent registers a handler for events of eventType whose payload is decoded
+// into a P. Events with a schema version above maxVersion, another payload message
+// or an undecodable payload are dead-lettered, to be replayed once the consumer
+// understands them. handle runs with the event's correlation ID in ctx.
+func HandleEvent[T any, P interface {
+	*T
+	proto.Message
+}](q *Queue, eventType string, maxVersion int, handle func(ctx context.Context, env *Envelope, event P) error) {
+	q.Handle(eventType, func(ctx context.Context, msg *Message) error {
+		env, err := parseEnvelope(msg)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid %s envelope: %v", msg.RoutingKey, err))
+		}
+		if env.SchemaVersion > maxVersion {
+			return Permanent(fmt.Errorf("%s schema version %d is newer than the supported %d", env.Type, env.SchemaVersion, maxVersion))
+		}
+
+		event := P(new(T))
+		if env.DataSchema != "" && env.DataSchema != string(proto.MessageName(event)) {
+			return Permanent(fmt.Errorf("%s carries %s, expected %s", env.Type, env.DataSchema, proto.MessageName(event)))
+		}
+		if err := env.Decode(event); err != nil {
+			return Permanent(fmt.Errorf("invalid %s payload: %v", env.Type, err))
+		}
+		return handle(WithCorrelationID(ctx, env.CorrelationID), env, event)
+	})
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose events are published with id as their
+// correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID set by WithCorrelationID, if any
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// newEventID returns a random UUID
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
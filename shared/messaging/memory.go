@@ -0,0 +1,134 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemoryBroker is an in-process Broker for unit tests. Published events are routed to
+// the consumed queues like a topic exchange would and handled by Drain, which applies
+// the same retry and dead-letter rules as RabbitMQ but redelivers at once instead of
+// waiting for the backoff.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	queues    []*memoryQueue
+	published []*Message
+}
+
+type memoryQueue struct {
+	queue   *Queue
+	ctx     context.Context
+	pending []*Message
+	dead    []*Message
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) PublishEvent(exchange, routingKey string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, &Message{Exchange: exchange, RoutingKey: routingKey, Body: body})
+	routed := false
+	for _, mq := range b.queues {
+		if mq.queue.Exchange != exchange {
+			continue
+		}
+		for _, pattern := range mq.queue.Patterns() {
+			if matchRoutingKey(pattern, routingKey) {
+				mq.pending = append(mq.pending, &Message{Exchange: exchange, RoutingKey: routingKey, Body: body, Attempt: 1})
+				routed = true
+				break
+			}
+		}
+	}
+	if !routed {
+		// Like a mandatory publish to RabbitMQ
+		return fmt.Errorf("%w: %s to %s", ErrUnroutable, routingKey, exchange)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context, q *Queue) error {
+	if err := q.prepare(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queues = append(b.queues, &memoryQueue{queue: q, ctx: ctx})
+	return nil
+}
+
+// Health always reports the in-process broker connected
+func (b *MemoryBroker) Health() Health {
+	return Health{State: StateConnected}
+}
+
+// Drain handles pending messages, including those published by the handlers, until
+// every queue is empty, and returns how many deliveries it made
+func (b *MemoryBroker) Drain() int {
+	deliveries := 0
+	for {
+		mq, msg := b.next()
+		if msg == nil {
+			return deliveries
+		}
+		deliveries++
+
+		result := mq.queue.dispatch(mq.ctx, msg)
+		b.mu.Lock()
+		switch {
+		case result.dead:
+			mq.dead = append(mq.dead, msg)
+		case result.retryAfter > 0:
+			retry := *msg
+			retry.Attempt++
+			mq.pending = append(mq.pending, &retry)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// next takes the oldest pending message of the first queue that has one
+func (b *MemoryBroker) next() (*memoryQueue, *Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, mq := range b.queues {
+		if mq.ctx.Err() != nil || len(mq.pending) == 0 {
+			continue
+		}
+		msg := mq.pending[0]
+		mq.pending = mq.pending[1:]
+		return mq, msg
+	}
+	return nil, nil
+}
+
+// Published returns every message published so far, in order
+func (b *MemoryBroker) Published() []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Message(nil), b.published...)
+}
+
+// DeadLetters returns the messages dead-lettered from the named queue
+func (b *MemoryBroker) DeadLetters(queue string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var dead []*Message
+	for _, mq := range b.queues {
+		if mq.queue.Name == queue {
+			dead = append(dead, mq.dead...)
+		}
+	}
+	return dead
+}
@@ -0,0 +1,216 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxEvent is an event waiting to be published. It is written in the same
+// transaction as the change it describes, so an event exists if and only if
+// the change was committed. Services sharing a database keep their events in
+// outbox tables of their own, see MigrateOutbox.
+type OutboxEvent struct {
+	ID            uint   `gorm:"primaryKey"`
+	Exchange      string `gorm:"not null;type:varchar(255)"`
+	RoutingKey    string `gorm:"not null;type:varchar(255)"`
+	Payload       string `gorm:"not null;type:text"`
+	Attempts      int    `gorm:"not null;default:0"`
+	LastError     string `gorm:"type:text"`
+	NextAttemptAt int64  `gorm:"not null"`
+	SentAt        *int64
+	// Set when no queue took the event ParkAfter times in a row. Parked rows are no
+	// longer published; clearing parked_at publishes them again.
+	ParkedAt  *int64 `gorm:"index"`
+	CreatedAt int64  `gorm:"autoCreateTime"`
+}
+
+// Publisher is the part of MessageBroker the outbox relay needs
+type Publisher interface {
+	PublishEvent(exchange, routingKey string, event interface{}) error
+}
+
+// MigrateOutbox creates or updates the outbox table and the index the relay looks
+// for pending rows with
+func MigrateOutbox(db *gorm.DB, table string) error {
+	if err := db.Table(table).AutoMigrate(&OutboxEvent{}); err != nil {
+		return err
+	}
+	// Named per table, as index names are unique across a Postgres schema
+	index := "idx_" + strings.TrimSuffix(table, "_events") + "_pending"
+	return db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (sent_at, next_attempt_at)", index, table)).Error
+}
+
+// EnqueueEvent stores an event in the outbox table using tx, which should be the
+// transaction that writes the change the event describes
+func EnqueueEvent(tx *gorm.DB, table, exchange, routingKey string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize event: %v", err)
+	}
+
+	row := &OutboxEvent{
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Payload:       string(body),
+		NextAttemptAt: time.Now().Unix(),
+	}
+	if err := tx.Table(table).Create(row).Error; err != nil {
+		return fmt.Errorf("failed to write outbox event: %v", err)
+	}
+	return nil
+}
+
+// OutboxRelay publishes pending outbox rows and marks them sent. Delivery is
+// at-least-once: a row published just before a crash is published again, and a
+// row no queue is bound for yet stays pending, with backoff, until one is.
+type OutboxRelay struct {
+	db        *gorm.DB
+	table     string
+	publisher Publisher
+
+	PollInterval time.Duration
+	BatchSize    int
+	ClaimTimeout time.Duration // how long a claimed batch is left to its relay
+	MaxBackoff   time.Duration
+	ParkAfter    int           // unroutable attempts before a row is parked
+	Retention    time.Duration // how long sent rows are kept
+
+	wake chan struct{}
+}
+
+func NewOutboxRelay(db *gorm.DB, table string, publisher Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		db:           db,
+		table:        table,
+		publisher:    publisher,
+		PollInterval: time.Second,
+		BatchSize:    20,
+		ClaimTimeout: 5 * time.Minute,
+		MaxBackoff:   5 * time.Minute,
+		ParkAfter:    12,
+		Retention:    7 * 24 * time.Hour,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake asks the relay to look for pending rows now instead of at the next poll
+func (r *OutboxRelay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays pending events until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	lastPurge := time.Time{}
+	for {
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				log.Printf("Outbox relay failed: %v", err)
+				break
+			}
+			if n < r.BatchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) > time.Hour {
+			r.purgeSent()
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// relayBatch publishes one batch of due rows. The rows are claimed in a short
+// transaction by pushing their next attempt ClaimTimeout into the future; SKIP
+// LOCKED lets several replicas of a service claim batches side by side. They are
+// published after it commits, so no row lock is held while waiting for confirms.
+// Rows of a relay that dies mid-batch are published again once their claim runs out.
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	var events []OutboxEvent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Table(r.table).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND parked_at IS NULL AND next_attempt_at <= ?", time.Now().Unix()).
+			Order("id").
+			Limit(r.BatchSize).
+			Find(&events)
+		if result.Error != nil || len(events) == 0 {
+			return result.Error
+		}
+
+		ids := make([]uint, len(events))
+		for i := range events {
+			ids[i] = events[i].ID
+		}
+		claimedUntil := time.Now().Add(r.ClaimTimeout).Unix()
+		return tx.Table(r.table).Where("id IN ?", ids).Update("next_attempt_at", claimedUntil).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		event := &events[i]
+		err := r.publisher.PublishEvent(event.Exchange, event.RoutingKey, json.RawMessage(event.Payload))
+		now := time.Now()
+		updates := map[string]interface{}{"sent_at": now.Unix()}
+		if err != nil {
+			event.Attempts++
+			updates = map[string]interface{}{
+				"attempts":        event.Attempts,
+				"last_error":      err.Error(),
+				"next_attempt_at": now.Add(r.backoff(event.Attempts)).Unix(),
+			}
+			// Nothing consumes the event yet. It waits for a consumer to bind its
+			// queue, and is parked for inspection if none does.
+			if errors.Is(err, ErrUnroutable) && event.Attempts >= r.ParkAfter {
+				log.Printf("Parking outbox event %d after %d attempts: %v", event.ID, event.Attempts, err)
+				updates["parked_at"] = now.Unix()
+			}
+		}
+		// Recorded even when ctx is cancelled, so a shutdown does not publish the row twice
+		if err := r.db.Table(r.table).Where("id = ?", event.ID).Updates(updates).Error; err != nil {
+			return len(events), err
+		}
+	}
+	return len(events), nil
+}
+
+// backoff doubles the delay with every failed attempt, up to MaxBackoff
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
+
+func (r *OutboxRelay) purgeSent() {
+	cutoff := time.Now().Add(-r.Retention).Unix()
+	if err := r.db.Table(r.table).Where("sent_at IS NOT NULL AND sent_at < ?", cutoff).Delete(&OutboxEvent{}).Error; err != nil {
+		log.Printf("Failed to purge sent outbox events: %v", err)
+	}
+}
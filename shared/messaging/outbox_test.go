@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testOutboxTable is not the default table name, so queries that forget it fail
+const testOutboxTable = "test_outbox_events"
+
+func newTestOutbox(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "outbox.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateOutbox(db, testOutboxTable); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// failingPublisher fails every publish with err
+type failingPublisher struct {
+	err error
+}
+
+func (p failingPublisher) PublishEvent(exchange, routingKey string, event interface{}) error {
+	return p.err
+}
+
+func TestOutboxRelay(t *testing.T) {
+	errDown := errors.New("connection down")
+	tests := []struct {
+		name         string
+		bound        bool  // a queue is bound for the event
+		publishErr   error // fails every publish if set
+		attempts     int   // failed attempts before this relay run
+		wantSent     bool
+		wantParked   bool
+		wantAttempts int
+	}{
+		{"routed", true, nil, 0, true, false, 0},
+		{"unroutable stays pending", false, nil, 0, false, false, 1},
+		{"unroutable is parked at last", false, nil, 11, false, true, 12},
+		{"other errors are never parked", true, errDown, 11, false, false, 12},
+	}
+
+	for _, tt := range tests {
+		db := newTestOutbox(t)
+		broker := NewMemoryBroker()
+		if tt.bound {
+			queue := &Queue{Name: "test.orders", Exchange: "order_events"}
+			queue.Handle("order.*", func(ctx context.Context, msg *Message) error { return nil })
+			if err := broker.Consume(context.Background(), queue); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var publisher Publisher = broker
+		if tt.publishErr != nil {
+			publisher = failingPublisher{tt.publishErr}
+		}
+		relay := NewOutboxRelay(db, testOutboxTable, publisher)
+
+		if err := EnqueueEvent(db, testOutboxTable, "order_events", "order.created", map[string]string{"order_id": "1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Table(testOutboxTable).Where("1 = 1").Update("attempts", tt.attempts).Error; err != nil {
+			t.Fatal(err)
+		}
+		if n, err := relay.relayBatch(context.Background()); err != nil || n != 1 {
+			t.Fatalf("%s: relayed %d events: %v", tt.name, n, err)
+		}
+
+		var row OutboxEvent
+		if err := db.Table(testOutboxTable).First(&row).Error; err != nil {
+			t.Fatal(err)
+		}
+		if gotSent := row.SentAt != nil; gotSent != tt.wantSent {
+			t.Errorf("%s: sent %v, want %v", tt.name, gotSent, tt.wantSent)
+		}
+		if gotParked := row.ParkedAt != nil; gotParked != tt.wantParked {
+			t.Errorf("%s: parked %v, want %v", tt.name, gotParked, tt.wantParked)
+		}
+		if row.Attempts != tt.wantAttempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, row.Attempts, tt.wantAttempts)
+		}
+		if !tt.wantSent && row.NextAttemptAt <= time.Now().Unix() {
+			t.Errorf("%s: retried without backoff", tt.name)
+		}
+
+		// Nothing is due again right away
+		if n, err := relay.relayBatch(context.Background()); err != nil || n != 0 {
+			t.Errorf("%s: second run relayed %d events: %v", tt.name, n, err)
+		}
+	}
+}
+
+func TestOutboxRelaySkipsClaimedEvents(t *testing.T) {
+	db := newTestOutbox(t)
+	relay := NewOutboxRelay(db, testOutboxTable, NewMemoryBroker())
+	if err := EnqueueEvent(db, testOutboxTable, "order_events", "order.created", map[string]string{"order_id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claimed by a relay that has not recorded the outcome yet
+	claimedUntil := time.Now().Add(relay.ClaimTimeout).Unix()
+	if err := db.Table(testOutboxTable).Where("1 = 1").Update("next_attempt_at", claimedUntil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := relay.relayBatch(context.Background()); err != nil || n != 0 {
+		t.Errorf("relayed %d claimed events: %v", n, err)
+	}
+}
@@ -0,0 +1,579 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Connection states reported by MessageBroker.Health
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+)
+
+var (
+	// ErrNotConnected is returned by PublishEvent while the connection is down
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+	// ErrUnroutable is returned by PublishEvent when no queue is bound for the message
+	ErrUnroutable = errors.New("message returned as unroutable")
+	// ErrBrokerClosed is returned once Close has been called
+	ErrBrokerClosed = errors.New("message broker closed")
+)
+
+const (
+	// confirmTimeout bounds how long PublishEvent waits for RabbitMQ to confirm a message
+	confirmTimeout = 10 * time.Second
+	// reconnectMinBackoff and reconnectMaxBackoff bound the delay between reconnects
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// Health is a snapshot of the broker's connection
+type Health struct {
+	State      string
+	Since      time.Time // when State was entered
+	LastError  string    // why the connection was last lost
+	Reconnects int       // successful reconnects since start
+	Returned   int       // messages returned as unroutable since start
+}
+
+// Healthy reports whether events can be published and consumed
+func (h Health) Healthy() bool {
+	return h.State == StateConnected
+}
+
+// MessageBroker publishes and consumes over one RabbitMQ connection. A lost
+// connection is re-established with backoff, and consumers resume on the new one.
+// Every publish is mandatory and waits for RabbitMQ's confirm, so PublishEvent
+// returning nil means the message was routed to a queue and accepted.
+type MessageBroker struct {
+	url       string
+	exchanges []string // declared on every connect
+
+	mu     sync.Mutex // guards the fields below
+	conn   *amqp.Connection
+	ready  chan struct{} // closed while conn is up
+	health Health
+	closed chan struct{}
+
+	publishMu sync.Mutex // one publish at a time on the confirm channel
+	publisher *publishChannel
+}
+
+// publishChannel is a channel in confirm mode with its listeners
+type publishChannel struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// NewMessageBroker connects to RabbitMQ at url and keeps reconnecting whenever the
+// connection is lost until Close is called. Only the first connection attempt has
+// to succeed. exchanges are the topic exchanges the service publishes to; consumers
+// declare the exchanges of their queues themselves.
+func NewMessageBroker(url string, exchanges ...string) (*MessageBroker, error) {
+	mb := &MessageBroker{
+		url:       url,
+		exchanges: exchanges,
+		ready:     make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	conn, err := mb.connect()
+	if err != nil {
+		return nil, err
+	}
+	go mb.supervise(conn)
+	return mb, nil
+}
+
+// connect dials RabbitMQ, declares the exchanges and makes the connection current
+func (mb *MessageBroker) connect() (*amqp.Connection, error) {
+	conn, err := amqp.Dial(mb.url)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer ch.Close()
+
+	for _, exchange := range mb.exchanges {
+		err = ch.ExchangeDeclare(
+			exchange, // exchange name
+			"topic",  // exchange type
+			true,     // durable
+			false,    // auto-deleted
+			false,    // internal
+			false,    // no-wait
+			nil,      // arguments
+		)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare exchange %s: %v", exchange, err)
+		}
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	select {
+	case <-mb.closed:
+		conn.Close()
+		return nil, ErrBrokerClosed
+	default:
+	}
+	mb.conn = conn
+	mb.setState(StateConnected, "")
+	close(mb.ready)
+	return conn, nil
+}
+
+// supervise waits for conn to close and reconnects with backoff, until Close
+func (mb *MessageBroker) supervise(conn *amqp.Connection) {
+	for {
+		lost := conn.NotifyClose(make(chan *amqp.Error, 1))
+		reason := "connection closed"
+		select {
+		case amqpErr := <-lost:
+			if amqpErr != nil {
+				reason = amqpErr.Error()
+			}
+		case <-mb.closed:
+			return
+		}
+
+		mb.mu.Lock()
+		select {
+		case <-mb.closed:
+			mb.mu.Unlock()
+			return
+		default:
+		}
+		mb.conn = nil
+		mb.ready = make(chan struct{})
+		mb.setState(StateReconnecting, reason)
+		mb.mu.Unlock()
+		log.Printf("Lost connection to RabbitMQ, reconnecting: %s", reason)
+
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-time.After(reconnectDelay(attempt)):
+			case <-mb.closed:
+				return
+			}
+			var err error
+			conn, err = mb.connect()
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to reconnect to RabbitMQ (attempt %d): %v", attempt, err)
+		}
+
+		mb.mu.Lock()
+		mb.health.Reconnects++
+		mb.mu.Unlock()
+		log.Printf("Reconnected to RabbitMQ")
+	}
+}
+
+// reconnectDelay doubles the delay with every failed attempt, up to reconnectMaxBackoff
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMinBackoff
+	for i := 1; i < attempt && delay < reconnectMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > reconnectMaxBackoff {
+		delay = reconnectMaxBackoff
+	}
+	return delay
+}
+
+// setState records a state change; mb.mu must be held
+func (mb *MessageBroker) setState(state, reason string) {
+	mb.health.State = state
+	mb.health.Since = time.Now()
+	if reason != "" {
+		mb.health.LastError = reason
+	}
+}
+
+// Health returns the state of the connection
+func (mb *MessageBroker) Health() Health {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.health
+}
+
+// connection returns the current connection, waiting for a reconnect if it is down.
+// The connection may still turn out to be closed, as the loss is noticed
+// asynchronously.
+func (mb *MessageBroker) connection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		mb.mu.Lock()
+		conn, ready := mb.conn, mb.ready
+		mb.mu.Unlock()
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-mb.closed:
+			return nil, ErrBrokerClosed
+		}
+	}
+}
+
+// PublishEvent publishes event as JSON and waits until RabbitMQ confirms it. It fails
+// fast while the connection is down; the outbox relay retries failed events.
+func (mb *MessageBroker) PublishEvent(exchange, routingKey string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	mb.publishMu.Lock()
+	defer mb.publishMu.Unlock()
+
+	pc, err := mb.publishChannel()
+	if err != nil {
+		return err
+	}
+	err = pc.ch.Publish(
+		exchange,
+		routingKey,
+		true, // mandatory: return the message if no queue is bound
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+	if err != nil {
+		mb.dropPublishChannel()
+		return err
+	}
+
+	// RabbitMQ sends the return of an unroutable message before its confirm
+	select {
+	case confirm, ok := <-pc.confirms:
+		if !ok {
+			mb.dropPublishChannel()
+			return fmt.Errorf("channel closed before %s was confirmed", routingKey)
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("RabbitMQ rejected %s", routingKey)
+		}
+	case <-time.After(confirmTimeout):
+		// A late confirm would be taken for the next message's
+		mb.dropPublishChannel()
+		return fmt.Errorf("timed out waiting for RabbitMQ to confirm %s", routingKey)
+	}
+	select {
+	case returned := <-pc.returns:
+		mb.mu.Lock()
+		mb.health.Returned++
+		mb.mu.Unlock()
+		return fmt.Errorf("%w: %s to %s: %s", ErrUnroutable, returned.RoutingKey, returned.Exchange, returned.ReplyText)
+	default:
+	}
+
+	// The body holds customer data, so only the event ID is logged
+	log.Printf("Published event %s to %s", eventID(body), routingKey)
+	return nil
+}
+
+// eventID returns the ID of the envelope in body, or "without ID" if it has none
+func eventID(body []byte) string {
+	var env struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil || env.ID == "" {
+		return "without ID"
+	}
+	return env.ID
+}
+
+// publishChannel returns the confirm channel, opening one on the current connection
+// if needed; mb.publishMu must be held
+func (mb *MessageBroker) publishChannel() (*publishChannel, error) {
+	if mb.publisher != nil {
+		return mb.publisher, nil
+	}
+
+	mb.mu.Lock()
+	conn := mb.conn
+	mb.mu.Unlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+	mb.publisher = &publishChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}
+	return mb.publisher, nil
+}
+
+// dropPublishChannel closes the confirm channel so that the next publish opens a
+// fresh one; mb.publishMu must be held
+func (mb *MessageBroker) dropPublishChannel() {
+	if mb.publisher != nil {
+		mb.publisher.ch.Close()
+		mb.publisher = nil
+	}
+}
+
+// Message headers the consumer keeps across retries
+const (
+	headerAttempt    = "x-attempt"
+	headerExchange   = "x-original-exchange"
+	headerRoutingKey = "x-original-routing-key"
+	headerError      = "x-error"
+)
+
+// Consume sets up q and its retry and dead-letter queues and handles its messages with
+// q.Concurrency workers on a channel of its own. A failed message is acked once RabbitMQ
+// has confirmed a copy parked in the retry queue with the backoff as its TTL, from where
+// RabbitMQ moves it back to q. If that publish fails the message is requeued instead. The retry
+// queue expires messages in order, so a long backoff holds up shorter ones behind it.
+// When the channel or connection is lost, consuming resumes once it is back.
+func (mb *MessageBroker) Consume(ctx context.Context, q *Queue) error {
+	if err := q.prepare(); err != nil {
+		return err
+	}
+	conn, err := mb.connection(ctx)
+	if err != nil {
+		return err
+	}
+	stopped, err := startConsumer(ctx, conn, q)
+	if err != nil {
+		return err
+	}
+	go mb.keepConsuming(ctx, q, stopped)
+	return nil
+}
+
+// keepConsuming starts q again every time its consumer stops, until ctx is cancelled
+// or the broker is closed
+func (mb *MessageBroker) keepConsuming(ctx context.Context, q *Queue, stopped <-chan struct{}) {
+	for {
+		<-stopped
+		for attempt := 1; ; attempt++ {
+			conn, err := mb.connection(ctx)
+			if err != nil {
+				log.Printf("Consumer of %s stopped", q.Name)
+				return
+			}
+			stopped, err = startConsumer(ctx, conn, q)
+			if err == nil {
+				log.Printf("Consumer of %s resumed", q.Name)
+				break
+			}
+			log.Printf("Failed to resume consumer of %s (attempt %d): %v", q.Name, attempt, err)
+			select {
+			case <-time.After(reconnectDelay(attempt)):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// startConsumer opens a channel on conn, declares q and starts its workers. The
+// returned channel is closed once they have stopped.
+func startConsumer(ctx context.Context, conn *amqp.Connection, q *Queue) (<-chan struct{}, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := declareQueue(ch, q); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	if err := ch.Qos(q.Concurrency, 0, false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	// Messages moved to the retry or dead-letter queue are only acked once RabbitMQ
+	// has confirmed their copy
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	deliveries, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to consume %s: %v", q.Name, err)
+	}
+
+	// Workers share the channel, which must not publish concurrently
+	var publishMu sync.Mutex
+	publish := func(queue string, msg amqp.Publishing) error {
+		publishMu.Lock()
+		defer publishMu.Unlock()
+		if err := ch.Publish("", queue, false, false, msg); err != nil {
+			return err
+		}
+		select {
+		case confirm, ok := <-confirms:
+			if !ok {
+				return fmt.Errorf("channel closed before the copy in %s was confirmed", queue)
+			}
+			if !confirm.Ack {
+				return fmt.Errorf("RabbitMQ rejected the copy in %s", queue)
+			}
+			return nil
+		case <-time.After(confirmTimeout):
+			// A late confirm would be taken for the next copy's. Closing the channel
+			// requeues its unacked deliveries and the consumer resumes on a new one.
+			ch.Close()
+			return fmt.Errorf("timed out waiting for RabbitMQ to confirm the copy in %s", queue)
+		}
+	}
+
+	var workers sync.WaitGroup
+	for i := 0; i < q.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for d := range deliveries {
+				handleDelivery(ctx, q, d, publish)
+			}
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		ch.Close()
+	}()
+	return stopped, nil
+}
+
+// declareQueue declares the exchange, q bound to its patterns, the retry queue that
+// dead-letters expired messages back into q and the dead-letter queue
+func declareQueue(ch *amqp.Channel, q *Queue) error {
+	if err := ch.ExchangeDeclare(q.Exchange, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange: %v", err)
+	}
+	if _, err := ch.QueueDeclare(q.Name, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", q.Name, err)
+	}
+	for _, pattern := range q.Patterns() {
+		if err := ch.QueueBind(q.Name, pattern, q.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %v", q.Name, pattern, err)
+		}
+	}
+
+	retryArgs := amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": q.Name,
+	}
+	if _, err := ch.QueueDeclare(q.retryQueue(), true, false, false, false, retryArgs); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", q.retryQueue(), err)
+	}
+	if _, err := ch.QueueDeclare(q.deadQueue(), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", q.deadQueue(), err)
+	}
+	return nil
+}
+
+func handleDelivery(ctx context.Context, q *Queue, d amqp.Delivery, publish func(string, amqp.Publishing) error) {
+	// Retried messages come back through the default exchange, keyed by queue name
+	msg := &Message{Exchange: d.Exchange, RoutingKey: d.RoutingKey, Body: d.Body, Attempt: 1}
+	if exchange, ok := d.Headers[headerExchange].(string); ok {
+		msg.Exchange = exchange
+	}
+	if key, ok := d.Headers[headerRoutingKey].(string); ok {
+		msg.RoutingKey = key
+	}
+	switch attempt := d.Headers[headerAttempt].(type) {
+	case int32:
+		msg.Attempt = int(attempt)
+	case int64:
+		msg.Attempt = int(attempt)
+	}
+
+	result := q.dispatch(ctx, msg)
+	if result.err == nil {
+		d.Ack(false)
+		return
+	}
+
+	headers := amqp.Table{
+		headerAttempt:    int32(msg.Attempt + 1),
+		headerExchange:   msg.Exchange,
+		headerRoutingKey: msg.RoutingKey,
+		headerError:      result.err.Error(),
+	}
+	out := amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         d.Body,
+	}
+	queue := q.deadQueue()
+	if !result.dead {
+		queue = q.retryQueue()
+		out.Expiration = strconv.FormatInt(result.retryAfter.Milliseconds(), 10)
+	}
+	if err := publish(queue, out); err != nil {
+		log.Printf("Failed to move %s to %s, requeueing: %v", msg.RoutingKey, queue, err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+// Close stops reconnecting and closes the connection
+func (mb *MessageBroker) Close() {
+	mb.mu.Lock()
+	select {
+	case <-mb.closed:
+		mb.mu.Unlock()
+		return
+	default:
+	}
+	close(mb.closed)
+	conn := mb.conn
+	mb.conn = nil
+	mb.setState(StateClosed, "")
+	mb.mu.Unlock()
+
+	mb.publishMu.Lock()
+	mb.dropPublishChannel()
+	mb.publishMu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
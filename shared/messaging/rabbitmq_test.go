@@ -0,0 +1,94 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// recordingAcknowledger records what became of a delivery
+type recordingAcknowledger struct {
+	acked, nacked, requeued bool
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked, a.requeued = true, requeue
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestHandleDelivery(t *testing.T) {
+	errUnconfirmed := errors.New("timed out waiting for RabbitMQ to confirm")
+	tests := []struct {
+		name         string
+		handlerErr   error
+		attempt      int32 // x-attempt header, 0 if unset
+		publishErr   error // fails the copy to the retry or dead-letter queue
+		wantQueue    string
+		wantAcked    bool
+		wantRequeued bool
+	}{
+		{"handled", nil, 0, nil, "", true, false},
+		{"retried", errors.New("failed"), 0, nil, "test.orders.retry", true, false},
+		{"dead-lettered", errors.New("failed"), 3, nil, "test.orders.dead", true, false},
+		{"retry copy not confirmed", errors.New("failed"), 0, errUnconfirmed, "test.orders.retry", false, true},
+		{"dead-letter copy not confirmed", errors.New("failed"), 3, errUnconfirmed, "test.orders.dead", false, true},
+	}
+
+	for _, tt := range tests {
+		queue := &Queue{Name: "test.orders", Exchange: "order_events", MaxAttempts: 3, MinBackoff: time.Millisecond}
+		queue.Handle("order.*", func(ctx context.Context, msg *Message) error { return tt.handlerErr })
+		if err := queue.prepare(); err != nil {
+			t.Fatal(err)
+		}
+
+		ack := &recordingAcknowledger{}
+		d := amqp.Delivery{Acknowledger: ack, Exchange: "order_events", RoutingKey: "order.created", Body: []byte("{}")}
+		if tt.attempt > 0 {
+			d.Headers = amqp.Table{headerAttempt: tt.attempt}
+		}
+		var published []string
+		publish := func(queue string, msg amqp.Publishing) error {
+			published = append(published, queue)
+			return tt.publishErr
+		}
+		handleDelivery(context.Background(), queue, d, publish)
+
+		if tt.wantQueue == "" && len(published) > 0 || tt.wantQueue != "" && (len(published) != 1 || published[0] != tt.wantQueue) {
+			t.Errorf("%s: copied to %v, want %q", tt.name, published, tt.wantQueue)
+		}
+		if ack.acked != tt.wantAcked {
+			t.Errorf("%s: acked %v, want %v", tt.name, ack.acked, tt.wantAcked)
+		}
+		if ack.requeued != tt.wantRequeued {
+			t.Errorf("%s: requeued %v, want %v", tt.name, ack.requeued, tt.wantRequeued)
+		}
+	}
+}
+
+func TestEventID(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"id":"e1","type":"user.registered","data":{"email":"u1@example.com"}}`, "e1"},
+		{`{"type":"user.registered"}`, "without ID"},
+		{`"not an envelope"`, "without ID"},
+	}
+	for _, tt := range tests {
+		if got := eventID([]byte(tt.body)); got != tt.want {
+			t.Errorf("eventID(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
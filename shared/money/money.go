@@ -0,0 +1,217 @@
+// Package money does exact arithmetic on amounts of money kept as integer minor
+// units (cents for USD) of an ISO 4217 currency.
+//
+// Amounts are int64 minor units everywhere. Fractions only appear when multiplying
+// by a rate (tax, discount percentage, exchange rate); those are done with big.Rat
+// and rounded half away from zero, once.
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// exponents lists the supported currencies and their number of minor unit digits
+var exponents = map[string]int{
+	"AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2,
+	"GBP": 2, "HKD": 2, "INR": 2, "MXN": 2, "NOK": 2, "NZD": 2, "PLN": 2, "SEK": 2,
+	"SGD": 2, "USD": 2, "ZAR": 2,
+	"JPY": 0, "KRW": 0,
+	"BHD": 3, "KWD": 3,
+}
+
+// Money is an amount in minor units of a currency
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// Normalize upper-cases and trims a currency code
+func Normalize(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// IsValid reports whether currency is a supported ISO 4217 code
+func IsValid(currency string) bool {
+	_, ok := exponents[currency]
+	return ok
+}
+
+// Exponent returns the number of minor unit digits of a supported currency
+func Exponent(currency string) int {
+	exp, ok := exponents[currency]
+	if !ok {
+		panic(fmt.Sprintf("money: unsupported currency %q", currency))
+	}
+	return exp
+}
+
+// String formats m as a decimal amount followed by its currency, e.g. "12.50 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Decimal formats m as a decimal amount without its currency, e.g. "12.50"
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d", sign, minor)
+	}
+	scale := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/scale, exp, minor%scale)
+}
+
+// FromFloat converts a decimal amount, e.g. from a config file or a legacy
+// decimal column, to minor units of currency
+func FromFloat(amount float64, currency string) int64 {
+	return MulRat(1, new(big.Rat).Mul(RatFromFloat(amount), big.NewRat(int64(math.Pow10(Exponent(currency))), 1)))
+}
+
+// ParseRat parses a decimal string such as "0.0725" exactly
+func ParseRat(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	return r, nil
+}
+
+// RatFromFloat returns the decimal f is written as, so 0.07 becomes exactly 7/100
+// rather than the nearest binary fraction
+func RatFromFloat(f float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return r
+}
+
+// MulRat multiplies minor by r and rounds half away from zero
+func MulRat(minor int64, r *big.Rat) int64 {
+	product := new(big.Rat).Mul(big.NewRat(minor, 1), r)
+	return roundRat(product)
+}
+
+// BasisPoints returns bp hundredths of a percent of minor, rounded half away from zero
+func BasisPoints(minor, bp int64) int64 {
+	return MulRat(minor, big.NewRat(bp, 10000))
+}
+
+func roundRat(r *big.Rat) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+
+	// (2*num + den) / (2*den) rounds half up on the absolute value
+	num.Mul(num, big.NewInt(2))
+	num.Add(num, den)
+	q := new(big.Int).Quo(num, new(big.Int).Mul(den, big.NewInt(2)))
+	if negative {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// Allocate splits total over weights in proportion, so that the parts add up to
+// total exactly. Remainders go to the parts with the largest fractions first.
+// Zero or negative weights get nothing.
+func Allocate(total int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+	var sum int64
+	for _, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+	}
+	if sum == 0 || total == 0 {
+		return parts
+	}
+
+	type remainder struct {
+		index int
+		rem   int64
+	}
+	var rems []remainder
+	allocated := int64(0)
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		share := new(big.Int).Mul(big.NewInt(total), big.NewInt(w))
+		q, r := new(big.Int).QuoRem(share, big.NewInt(sum), new(big.Int))
+		parts[i] = q.Int64()
+		allocated += parts[i]
+		rems = append(rems, remainder{i, r.Int64()})
+	}
+
+	// Hand out what integer division left over, largest remainder first
+	left := total - allocated
+	for left != 0 {
+		best := -1
+		for j := range rems {
+			if best < 0 || abs(rems[j].rem) > abs(rems[best].rem) {
+				best = j
+			}
+		}
+		step := int64(1)
+		if left < 0 {
+			step = -1
+		}
+		parts[rems[best].index] += step
+		rems[best].rem = 0
+		left -= step
+	}
+	return parts
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Rates converts between currencies through a base currency. PerBase holds how many
+// units of each currency one unit of the base currency buys; the base itself is 1.
+type Rates struct {
+	Base    string
+	PerBase map[string]*big.Rat
+}
+
+// Convert converts minor units of from into minor units of to
+func (r *Rates) Convert(minor int64, from, to string) (int64, error) {
+	if from == to {
+		return minor, nil
+	}
+	fromRate, err := r.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := r.rate(to)
+	if err != nil {
+		return 0, err
+	}
+
+	// minor / 10^exp(from) / fromRate * toRate * 10^exp(to)
+	factor := new(big.Rat).Quo(toRate, fromRate)
+	factor.Mul(factor, big.NewRat(int64(math.Pow10(Exponent(to))), int64(math.Pow10(Exponent(from)))))
+	return MulRat(minor, factor), nil
+}
+
+func (r *Rates) rate(currency string) (*big.Rat, error) {
+	if currency == r.Base {
+		return big.NewRat(1, 1), nil
+	}
+	rate, ok := r.PerBase[currency]
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("no exchange rate for %s", currency)
+	}
+	return rate, nil
+}
@@ -0,0 +1,173 @@
+package money
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1999, "USD"}, "19.99"},
+		{Money{5, "USD"}, "0.05"},
+		{Money{-5, "USD"}, "-0.05"},
+		{Money{0, "EUR"}, "0.00"},
+		{Money{500, "JPY"}, "500"},
+		{Money{-500, "JPY"}, "-500"},
+		{Money{1234, "KWD"}, "1.234"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tt.m.Minor, tt.m.Currency, got, tt.want)
+		}
+	}
+
+	if got := (Money{1250, "USD"}).String(); got != "12.50 USD" {
+		t.Errorf("String() = %q, want %q", got, "12.50 USD")
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{19.99, "USD", 1999},
+		{0.07, "USD", 7},
+		{1.005, "USD", 101}, // exactly half a cent, not 1.00499... as a binary float
+		{-1.005, "USD", -101},
+		{500, "JPY", 500},
+		{1.2345, "KWD", 1235},
+	}
+	for _, tt := range tests {
+		if got := FromFloat(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("FromFloat(%v, %s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	tests := []struct {
+		minor int64
+		r     *big.Rat
+		want  int64
+	}{
+		{5, big.NewRat(1, 2), 3}, // half away from zero
+		{-5, big.NewRat(1, 2), -3},
+		{3, big.NewRat(1, 2), 2},
+		{7, big.NewRat(1, 3), 2},
+		{-7, big.NewRat(1, 3), -2},
+		{8, big.NewRat(1, 3), 3},
+		{1999, big.NewRat(0, 1), 0},
+		{1999, big.NewRat(1, 1), 1999},
+	}
+	for _, tt := range tests {
+		if got := MulRat(tt.minor, tt.r); got != tt.want {
+			t.Errorf("MulRat(%d, %s) = %d, want %d", tt.minor, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestBasisPoints(t *testing.T) {
+	tests := []struct {
+		minor int64
+		bp    int64
+		want  int64
+	}{
+		{1999, 1000, 200},
+		{1999, 1250, 250},
+		{25, 1000, 3},
+		{-25, 1000, -3},
+		{1000, 0, 0},
+		{1000, 10000, 1000},
+		{10000, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := BasisPoints(tt.minor, tt.bp); got != tt.want {
+			t.Errorf("BasisPoints(%d, %d) = %d, want %d", tt.minor, tt.bp, got, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		weights []int64
+		want    []int64
+	}{
+		{"even", 90, []int64{1, 1, 1}, []int64{30, 30, 30}},
+		{"remainder to the first of equal fractions", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"negative total", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"largest fractions first", 1000, []int64{1999, 2999, 5}, []int64{400, 599, 1}},
+		{"zero and negative weights get nothing", 10, []int64{3, 0, -2, 7}, []int64{3, 0, 0, 7}},
+		{"zero total", 0, []int64{1, 2}, []int64{0, 0}},
+		{"no positive weight", 10, []int64{0, -1}, []int64{0, 0}},
+		{"no weights", 10, nil, []int64{}},
+	}
+	for _, tt := range tests {
+		got := Allocate(tt.total, tt.weights)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Allocate(%d, %v) = %v, want %v", tt.name, tt.total, tt.weights, got, tt.want)
+		}
+	}
+}
+
+func TestAllocateAddsUp(t *testing.T) {
+	weights := []int64{1999, 1, 333, 0, 4250, 17}
+	for _, total := range []int64{1, 7, 99, 1000, 123457, -1, -99, -123457} {
+		var sum int64
+		for _, part := range Allocate(total, weights) {
+			sum += part
+		}
+		if sum != total {
+			t.Errorf("Allocate(%d, %v) adds up to %d", total, weights, sum)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rates := &Rates{
+		Base: "USD",
+		PerBase: map[string]*big.Rat{
+			"EUR": big.NewRat(92, 100),
+			"JPY": big.NewRat(150, 1),
+			"KWD": big.NewRat(307, 1000),
+			"CHF": big.NewRat(0, 1),
+		},
+	}
+	tests := []struct {
+		minor    int64
+		from, to string
+		want     int64
+	}{
+		{1999, "USD", "USD", 1999},
+		{1999, "USD", "EUR", 1839}, // 1839.08
+		{1000, "EUR", "USD", 1087}, // 1086.96
+		{1999, "USD", "JPY", 2999}, // 2998.5, half away from zero
+		{-1999, "USD", "JPY", -2999},
+		{500, "JPY", "EUR", 307}, // 306.67, neither is the base
+		{100, "USD", "KWD", 307}, // three minor digits
+		{307, "KWD", "USD", 100},
+	}
+	for _, tt := range tests {
+		got, err := rates.Convert(tt.minor, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("Convert(%d, %s, %s): %v", tt.minor, tt.from, tt.to, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Convert(%d, %s, %s) = %d, want %d", tt.minor, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	for _, pair := range [][2]string{{"USD", "GBP"}, {"GBP", "USD"}, {"EUR", "CHF"}} {
+		if _, err := rates.Convert(100, pair[0], pair[1]); err == nil {
+			t.Errorf("Convert(100, %s, %s) succeeded without a usable rate", pair[0], pair[1])
+		}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 
+	"shared/messaging"
+	"user-service/events"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -44,6 +47,9 @@ func InitDB() (*gorm.DB, error) {
 
 	// Auto-migrate the schema
 	err = db.AutoMigrate(&User{})
+	if err == nil {
+		err = messaging.MigrateOutbox(db, events.OutboxTable)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
@@ -5,10 +5,17 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"regexp"
+	"strings"
 
+	"shared/messaging"
+	eventspb "shared/proto"
+	"user-service/events"
 	pb "user-service/user-service/proto"
 
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
@@ -17,21 +24,44 @@ type User struct {
 	Email        string `gorm:"uniqueIndex;not null;type:varchar(255)"`
 	PasswordHash string `gorm:"not null;type:varchar(255)"` // Store hashed password
 	Name         string `gorm:"not null;type:varchar(255)"`
+	Role         string `gorm:"not null;type:varchar(50);default:'customer'"`
+	Locale       string `gorm:"not null;type:varchar(35);default:'en'"` // BCP 47 language tag for emails
 	CreatedAt    int64  `gorm:"autoCreateTime"`
 	UpdatedAt    int64  `gorm:"autoUpdateTime"`
 }
 
 type UserService struct {
 	pb.UnimplementedUserServiceServer
-	db *gorm.DB
+	db          *gorm.DB
+	outboxRelay *messaging.OutboxRelay
 }
 
-func NewUserService(db *gorm.DB) *UserService {
+func NewUserService(db *gorm.DB, outboxRelay *messaging.OutboxRelay) *UserService {
 	return &UserService{
-		db: db,
+		db:          db,
+		outboxRelay: outboxRelay,
 	}
 }
 
+// userEventsExchange is the topic exchange all user events are published to
+const userEventsExchange = "user_events"
+
+// defaultLocale is the locale of users who did not choose one
+const defaultLocale = "en"
+
+// User roles. Admins can use the admin routes of the gateway.
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
+func isValidRole(role string) bool {
+	return role == RoleCustomer || role == RoleAdmin
+}
+
+// localePattern accepts BCP 47 language tags such as en, de-DE or zh-Hant-TW
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
 func (s *UserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
 	// Check if email already exists
 	var existingUser User
@@ -49,22 +79,56 @@ func (s *UserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 		return nil, fmt.Errorf("failed to hash password: %v", err)
 	}
 
+	role := req.Role
+	if role == "" {
+		role = RoleCustomer
+	}
+	if !isValidRole(role) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid role %q", req.Role)
+	}
+
+	locale := strings.TrimSpace(req.Locale)
+	if locale == "" {
+		locale = defaultLocale
+	}
+	if !localePattern.MatchString(locale) {
+		return nil, fmt.Errorf("invalid locale %q", req.Locale)
+	}
+
 	userID := generateID()
 	user := &User{
 		ID:           userID,
 		Email:        req.Email,
 		PasswordHash: string(hashedPassword),
 		Name:         req.Name,
+		Role:         role,
+		Locale:       locale,
 	}
 
-	if err := s.db.Create(user).Error; err != nil {
-		return nil, fmt.Errorf("failed to create user: %v", err)
+	// The user and its user.registered event are committed together
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+		event := &eventspb.UserRegisteredEvent{
+			UserId: user.ID,
+			Email:  user.Email,
+			Name:   user.Name,
+			Locale: user.Locale,
+		}
+		return events.Enqueue(tx, userEventsExchange, "user.registered", event)
+	})
+	if err != nil {
+		return nil, err
 	}
+	s.outboxRelay.Wake()
 
 	return &pb.UserResponse{
 		UserId: userID,
 		Email:  user.Email,
 		Name:   user.Name,
+		Role:   user.Role,
+		Locale: user.Locale,
 	}, nil
 }
 
@@ -82,9 +146,66 @@ func (s *UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.
 		UserId: user.ID,
 		Email:  user.Email,
 		Name:   user.Name,
+		Role:   user.Role,
+		Locale: user.Locale,
+	}, nil
+}
+
+// SetUserRole makes a user a customer or an admin. The gateway restricts it to admins.
+func (s *UserService) SetUserRole(ctx context.Context, req *pb.SetUserRoleRequest) (*pb.UserResponse, error) {
+	if !isValidRole(req.Role) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid role %q", req.Role)
+	}
+
+	var user User
+	result := s.db.WithContext(ctx).Where("id = ?", req.UserId).First(&user)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "database error: %v", result.Error)
+	}
+	if err := s.db.WithContext(ctx).Model(&user).Update("role", req.Role).Error; err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update role: %v", err)
+	}
+	user.Role = req.Role
+
+	return &pb.UserResponse{
+		UserId: user.ID,
+		Email:  user.Email,
+		Name:   user.Name,
+		Role:   user.Role,
+		Locale: user.Locale,
 	}, nil
 }
 
+// EnsureAdmin gives the user with email the admin role, registering it with password
+// first if there is none. It lets the first admin in, since public registration only
+// creates customers. An existing account is only promoted if password is its password.
+func (s *UserService) EnsureAdmin(ctx context.Context, email, password string) error {
+	if password == "" {
+		return fmt.Errorf("a password is required for admin %s", email)
+	}
+
+	var user User
+	result := s.db.WithContext(ctx).Where("email = ?", email).Limit(1).Find(&user)
+	if result.Error != nil {
+		return fmt.Errorf("database error: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		_, err := s.CreateUser(ctx, &pb.CreateUserRequest{Email: email, Password: password, Name: "Admin", Role: RoleAdmin})
+		return err
+	}
+	if user.Role == RoleAdmin {
+		return nil
+	}
+	// An account someone else registered under the address is not promoted
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return fmt.Errorf("user %s exists with another password", email)
+	}
+	return s.db.WithContext(ctx).Model(&user).Update("role", RoleAdmin).Error
+}
+
 func (s *UserService) AuthenticateUser(ctx context.Context, req *pb.AuthRequest) (*pb.AuthResponse, error) {
 	var user User
 	result := s.db.Where("email = ?", req.Email).First(&user)
@@ -108,6 +229,7 @@ func (s *UserService) AuthenticateUser(ctx context.Context, req *pb.AuthRequest)
 		Success: true,
 		UserId:  user.ID,
 		Token:   token,
+		Role:    user.Role,
 	}, nil
 }
 